@@ -0,0 +1,140 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// openDNS answers myip.opendns.com with the public address of the client.
+const openDNS = "208.67.222.222:53"
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Host identifies the machine the agent runs on.
+type Host struct {
+	Name      string // Short hostname.
+	Domain    string // Domain name as in (hostname -d).
+	PrivateIP string
+	PublicIP  string
+}
+
+//-----------------------------------------------------------------------------
+// func: LocalHost
+//-----------------------------------------------------------------------------
+
+// LocalHost returns the host the agent runs on. The private IP is the one the
+// hostname resolves to, as in (hostname -i). Addresses already set in
+// override are kept.
+func LocalHost(override Host) (*Host, error) {
+
+	h := override
+
+	// The hostname:
+	fqdn, err := os.Hostname()
+	if err != nil {
+		log.WithField("cmd", "agent").Error(err)
+		return nil, err
+	}
+
+	if i := strings.Index(fqdn, "."); i > 0 {
+		if h.Domain == "" {
+			h.Domain = fqdn[i+1:]
+		}
+		fqdn = fqdn[:i]
+	}
+
+	h.Name = fqdn
+
+	if h.Domain == "" {
+		err := errors.New("the hostname has no domain")
+		log.WithField("cmd", "agent").Error(err)
+		return nil, err
+	}
+
+	// The private IP:
+	if h.PrivateIP == "" {
+		if h.PrivateIP, err = lookupIPv4(h.Name + "." + h.Domain); err != nil {
+			return nil, err
+		}
+	}
+
+	return &h, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: fqdn
+//-----------------------------------------------------------------------------
+
+func (h *Host) fqdn() string {
+	return h.Name + "." + h.Domain
+}
+
+//-----------------------------------------------------------------------------
+// func: PublicIP
+//-----------------------------------------------------------------------------
+
+// PublicIP asks OpenDNS for the public address of the host, as in
+// (dig +short myip.opendns.com @resolver1.opendns.com).
+func PublicIP() (string, error) {
+
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, openDNS)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addrs, err := r.LookupHost(ctx, "myip.opendns.com")
+	if err != nil {
+		log.WithField("cmd", "agent").Error(err)
+		return "", err
+	}
+
+	return addrs[0], nil
+}
+
+//-----------------------------------------------------------------------------
+// func: lookupIPv4
+//-----------------------------------------------------------------------------
+
+func lookupIPv4(name string) (string, error) {
+
+	addrs, err := net.LookupHost(name)
+	if err != nil {
+		log.WithField("cmd", "agent").Error(err)
+		return "", err
+	}
+
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip != nil && ip.To4() != nil && !ip.IsLoopback() {
+			return a, nil
+		}
+	}
+
+	err = errors.New("no private IPv4 address for " + name)
+	log.WithField("cmd", "agent").Error(err)
+	return "", err
+}
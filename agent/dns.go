@@ -0,0 +1,44 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/h0tbird/kato/dns"
+)
+
+//-----------------------------------------------------------------------------
+// func: DNSPublish
+//-----------------------------------------------------------------------------
+
+// DNSPublish publishes the A records of the host in the int.<domain> and
+// ext.<domain> zones, pointing to its private and public addresses.
+func DNSPublish(p dns.Publisher, h *Host, ttl int) error {
+
+	var err error
+
+	// Find the public address:
+	if h.PublicIP == "" {
+		if h.PublicIP, err = PublicIP(); err != nil {
+			return err
+		}
+	}
+
+	// Publish both records:
+	for _, z := range [][2]string{{"int", h.PrivateIP}, {"ext", h.PublicIP}} {
+
+		zone := z[0] + "." + h.Domain
+		r := dns.Record{Zone: zone, Name: h.Name + "." + zone, Answers: []string{z[1]}, TTL: ttl}
+
+		if err = p.Publish(r); err != nil {
+			log.WithFields(log.Fields{"cmd": "agent:dns-publish", "id": r.Name}).Error(err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// DefaultDockerSocket is where the Docker daemon listens.
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+type dockerClient struct {
+	client *http.Client
+}
+
+type dockerContainer struct {
+	ID      string `json:"Id"`
+	Image   string `json:"Image"`
+	ImageID string `json:"ImageID"`
+	State   string `json:"State"`
+}
+
+type dockerImage struct {
+	ID       string   `json:"Id"`
+	RepoTags []string `json:"RepoTags"`
+	Created  int64    `json:"Created"`
+	Size     int64    `json:"Size"`
+}
+
+type dockerVolume struct {
+	Name string `json:"Name"`
+}
+
+//-----------------------------------------------------------------------------
+// func: newDockerClient
+//-----------------------------------------------------------------------------
+
+// newDockerClient talks to the Docker remote API over a unix socket.
+func newDockerClient(socket string) *dockerClient {
+
+	if socket == "" {
+		socket = DefaultDockerSocket
+	}
+
+	return &dockerClient{
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}},
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: containers
+//-----------------------------------------------------------------------------
+
+// containers lists every container, running or not.
+func (d *dockerClient) containers() ([]dockerContainer, error) {
+	var list []dockerContainer
+	return list, d.do("GET", "/containers/json?all=1", &list)
+}
+
+//-----------------------------------------------------------------------------
+// func: images
+//-----------------------------------------------------------------------------
+
+// images lists the local images.
+func (d *dockerClient) images() ([]dockerImage, error) {
+	var list []dockerImage
+	return list, d.do("GET", "/images/json", &list)
+}
+
+//-----------------------------------------------------------------------------
+// func: volumes
+//-----------------------------------------------------------------------------
+
+// volumes lists the volumes not used by any container.
+func (d *dockerClient) volumes() ([]dockerVolume, error) {
+
+	var list struct {
+		Volumes []dockerVolume `json:"Volumes"`
+	}
+
+	query := "?filters=" + url.QueryEscape(`{"dangling":["true"]}`)
+	return list.Volumes, d.do("GET", "/volumes"+query, &list)
+}
+
+//-----------------------------------------------------------------------------
+// func: removeContainer
+//-----------------------------------------------------------------------------
+
+func (d *dockerClient) removeContainer(id string) error {
+	return d.do("DELETE", "/containers/"+id+"?v=1", nil)
+}
+
+//-----------------------------------------------------------------------------
+// func: removeImage
+//-----------------------------------------------------------------------------
+
+func (d *dockerClient) removeImage(name string) error {
+	return d.do("DELETE", "/images/"+name, nil)
+}
+
+//-----------------------------------------------------------------------------
+// func: removeVolume
+//-----------------------------------------------------------------------------
+
+func (d *dockerClient) removeVolume(name string) error {
+	return d.do("DELETE", "/volumes/"+name, nil)
+}
+
+//-----------------------------------------------------------------------------
+// func: do
+//-----------------------------------------------------------------------------
+
+func (d *dockerClient) do(method, path string, out interface{}) error {
+
+	// Forge the request:
+	req, err := http.NewRequest(method, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	// Send the request:
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		var msg struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(res.Body).Decode(&msg)
+		if msg.Message == "" {
+			msg.Message = res.Status
+		}
+		return errors.New("docker: " + method + " " + path + ": " + msg.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		log.WithField("cmd", "agent:docker").Error(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,422 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// imagesDir is where every host publishes the images it runs.
+const imagesDir = "/docker/images"
+
+// fleetUnits is where fleet stores the content of the submitted units.
+const fleetUnits = "/_coreos.com/fleet/unit"
+
+// DefaultGCStateFile is where DockerGC remembers when it first saw an image.
+const DefaultGCStateFile = "/var/lib/kato/docker-gc.json"
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// dockerPull finds the images pulled by a unit.
+var dockerPull = regexp.MustCompile(`docker\s+pull\s+([^\s'"]+)`)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// GC tunes DockerGC.
+type GC struct {
+	Socket        string        // Docker daemon unix socket.
+	Keep          []string      // Images matching any of these are never removed.
+	MinAge        time.Duration // Images seen here for less than this are never removed.
+	StateFile     string        // Where the first sighting of every image is kept.
+	HighWatermark int           // Remove unused images only above this disk usage, in percent.
+	DockerRoot    string        // Filesystem checked against HighWatermark.
+	DryRun        bool          // Report what would be removed and stop there.
+}
+
+type gcImage struct {
+	name string
+	size int64
+	seen time.Time
+}
+
+// gcSeen maps image IDs to the unix time DockerGC first saw them. An image
+// is only as old as its first sighting on this host, whatever its build time.
+type gcSeen map[string]int64
+
+//-----------------------------------------------------------------------------
+// func: DockerGC
+//-----------------------------------------------------------------------------
+
+// DockerGC removes the stopped containers, the dangling images and volumes,
+// and the local images no host in the cluster runs. The images running here
+// are published under /docker/images/<fqdn> for the other hosts to see.
+// Images pulled by fleet units, matching g.Keep or first seen here less than
+// g.MinAge ago are left alone. With g.HighWatermark set, unused images are
+// only removed, the longest seen first, while the disk usage is above it.
+func DockerGC(e Etcd, h *Host, g GC) error {
+
+	c, err := e.client()
+	if err != nil {
+		return err
+	}
+
+	// Compile the keep-list:
+	var keep []*regexp.Regexp
+	for _, k := range g.Keep {
+		re, err := regexp.Compile(k)
+		if err != nil {
+			log.WithField("cmd", "agent:docker-gc").Error(err)
+			return err
+		}
+		keep = append(keep, re)
+	}
+
+	d := newDockerClient(g.Socket)
+	gc := &gcRun{dryRun: g.DryRun}
+
+	// Remove the stopped containers:
+	containers, err := d.containers()
+	if err != nil {
+		log.WithField("cmd", "agent:docker-gc").Error(err)
+		return err
+	}
+
+	running := map[string]bool{}
+	inUse := map[string]bool{}
+	for _, ct := range containers {
+		if ct.State == "running" {
+			running[ct.Image] = true
+			inUse[ct.ImageID] = true
+			continue
+		}
+		gc.remove("container", ct.ID, 0, d.removeContainer)
+	}
+
+	// Publish the images running here:
+	var mine []string
+	for img := range running {
+		mine = append(mine, img)
+		inUse[img] = true
+	}
+	sort.Strings(mine)
+
+	if !g.DryRun {
+		if err := c.set(path.Join(imagesDir, h.fqdn()), strings.Join(mine, "\n"), 0); err != nil {
+			return err
+		}
+	}
+
+	// Collect the images running anywhere:
+	dir, _, err := c.get(imagesDir)
+	if err != nil && err != errNotFound {
+		return err
+	}
+
+	if dir != nil {
+		for _, n := range dir.Nodes {
+			for _, img := range strings.Fields(n.Value) {
+				inUse[img] = true
+			}
+		}
+	}
+
+	// Collect the images pinned by fleet units:
+	units, _, err := c.get(fleetUnits)
+	if err != nil && err != errNotFound {
+		return err
+	}
+
+	if units != nil {
+		for _, n := range units.Nodes {
+			u := struct{ Raw string }{}
+			if json.Unmarshal([]byte(n.Value), &u) != nil {
+				continue
+			}
+			for _, m := range dockerPull.FindAllStringSubmatch(u.Raw, -1) {
+				inUse[m[1]] = true
+			}
+		}
+	}
+
+	// Pick the candidates:
+	images, err := d.images()
+	if err != nil {
+		log.WithField("cmd", "agent:docker-gc").Error(err)
+		return err
+	}
+
+	// Age the images from their first sighting:
+	seen, err := loadSeen(g.StateFile)
+	if err != nil {
+		return err
+	}
+
+	seen.update(images, time.Now())
+	if !g.DryRun {
+		if err := seen.save(g.StateFile); err != nil {
+			return err
+		}
+	}
+
+	var dangling, unused []gcImage
+	for _, img := range images {
+
+		first := time.Unix(seen[img.ID], 0)
+		if inUse[img.ID] || time.Since(first) < g.MinAge {
+			continue
+		}
+
+		tags := img.RepoTags
+		if len(tags) == 0 || len(tags) == 1 && tags[0] == "<none>:<none>" {
+			dangling = append(dangling, gcImage{img.ID, img.Size, first})
+			continue
+		}
+
+		for _, tag := range tags {
+			if !inUse[tag] && !inUse[strings.TrimSuffix(tag, ":latest")] && !matchAny(keep, tag) {
+				unused = append(unused, gcImage{tag, img.Size, first})
+			}
+		}
+	}
+
+	// Remove the dangling images:
+	for _, img := range dangling {
+		gc.remove("image", img.name, img.size, d.removeImage)
+	}
+
+	// Remove the unused images, the longest seen first:
+	sort.Sort(bySeen(unused))
+	for _, img := range unused {
+
+		if g.HighWatermark > 0 {
+			usage, err := diskUsage(g.DockerRoot)
+			if err != nil {
+				log.WithField("cmd", "agent:docker-gc").Error(err)
+				return err
+			}
+			if usage < g.HighWatermark {
+				log.WithFields(log.Fields{"cmd": "agent:docker-gc", "id": g.DockerRoot}).
+					Info("- Disk usage at " + strconv.Itoa(usage) + "%, keeping the unused images")
+				break
+			}
+		}
+
+		gc.remove("image", img.name, img.size, d.removeImage)
+	}
+
+	// Remove the dangling volumes:
+	volumes, err := d.volumes()
+	if err != nil {
+		log.WithField("cmd", "agent:docker-gc").Error(err)
+		return err
+	}
+
+	for _, v := range volumes {
+		gc.remove("volume", v.Name, 0, d.removeVolume)
+	}
+
+	// The dry-run report:
+	if g.DryRun {
+		log.WithField("cmd", "agent:docker-gc").
+			Info("- Dry run: " + strconv.Itoa(gc.count) + " objects and " +
+				strconv.FormatInt(gc.bytes/1024/1024, 10) + " MB of images would be removed")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// gcRun:
+//-----------------------------------------------------------------------------
+
+// gcRun removes objects, or counts them in dry-run mode.
+type gcRun struct {
+	dryRun bool
+	count  int
+	bytes  int64
+}
+
+// remove logs the outcome of a removal. Failures are not fatal: the object
+// may be in use or already gone, and the next run will try again.
+func (r *gcRun) remove(kind, id string, size int64, remove func(string) error) {
+
+	if r.dryRun {
+		r.count++
+		r.bytes += size
+		log.WithFields(log.Fields{"cmd": "agent:docker-gc", "id": id}).
+			Info("- Would remove " + kind)
+		return
+	}
+
+	if err := remove(id); err != nil {
+		log.WithFields(log.Fields{"cmd": "agent:docker-gc", "id": id}).Warn(err)
+		return
+	}
+
+	r.count++
+	r.bytes += size
+	log.WithFields(log.Fields{"cmd": "agent:docker-gc", "id": id}).
+		Info("- Removed " + kind)
+}
+
+//-----------------------------------------------------------------------------
+// func: matchAny
+//-----------------------------------------------------------------------------
+
+func matchAny(list []*regexp.Regexp, s string) bool {
+	for _, re := range list {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: diskUsage
+//-----------------------------------------------------------------------------
+
+// diskUsage returns the used space of the filesystem holding dir in percent,
+// as reported by df.
+func diskUsage(dir string) (int, error) {
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+
+	used := st.Blocks - st.Bfree
+	if used+st.Bavail == 0 {
+		return 0, nil
+	}
+
+	return int(used * 100 / (used + st.Bavail)), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: loadSeen
+//-----------------------------------------------------------------------------
+
+// loadSeen reads the image sightings kept in file, if any.
+func loadSeen(file string) (gcSeen, error) {
+
+	seen := gcSeen{}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return seen, nil
+	}
+
+	if err == nil {
+		err = json.Unmarshal(data, &seen)
+	}
+
+	if err != nil {
+		log.WithField("cmd", "agent:docker-gc").Error(err)
+		return nil, err
+	}
+
+	return seen, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: update
+//-----------------------------------------------------------------------------
+
+// update records the images seen for the first time at now, and forgets the
+// ones gone since, so that a later pull starts a new sighting.
+func (s gcSeen) update(images []dockerImage, now time.Time) {
+
+	present := map[string]bool{}
+	for _, img := range images {
+		present[img.ID] = true
+		if _, ok := s[img.ID]; !ok {
+			s[img.ID] = now.Unix()
+		}
+	}
+
+	for id := range s {
+		if !present[id] {
+			delete(s, id)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: save
+//-----------------------------------------------------------------------------
+
+// save replaces file through a rename, so a crash never leaves it truncated.
+func (s gcSeen) save(file string) error {
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		log.WithField("cmd", "agent:docker-gc").Error(err)
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		log.WithField("cmd", "agent:docker-gc").Error(err)
+		return err
+	}
+
+	// Write a temporary file next to it:
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".docker-gc")
+	if err != nil {
+		log.WithField("cmd", "agent:docker-gc").Error(err)
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	// And move it in place:
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.WithField("cmd", "agent:docker-gc").Error(err)
+		return err
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// Sort by first sighting:
+//-----------------------------------------------------------------------------
+
+type bySeen []gcImage
+
+func (s bySeen) Len() int           { return len(s) }
+func (s bySeen) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySeen) Less(i, j int) bool { return s[i].seen.Before(s[j].seen) }
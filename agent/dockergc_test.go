@@ -0,0 +1,67 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+// func: TestSeen
+//-----------------------------------------------------------------------------
+
+// An image is aged from its first sighting, not from its build time, and a
+// removed image starts over when pulled again.
+func TestSeen(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "docker-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "kato", "docker-gc.json")
+	t0 := time.Unix(1500000000, 0)
+	old := dockerImage{ID: "sha256:old", Created: t0.Add(-365 * 24 * time.Hour).Unix()}
+	img := dockerImage{ID: "sha256:new", Created: t0.Unix()}
+
+	// Nothing seen yet:
+	seen, err := loadSeen(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// First run:
+	seen.update([]dockerImage{old}, t0)
+	if err := seen.save(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second run, an hour later:
+	if seen, err = loadSeen(file); err != nil {
+		t.Fatal(err)
+	}
+	seen.update([]dockerImage{old, img}, t0.Add(time.Hour))
+
+	want := gcSeen{"sha256:old": t0.Unix(), "sha256:new": t0.Add(time.Hour).Unix()}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("got %v, want %v", seen, want)
+	}
+
+	// The old image is removed and pulled again later:
+	seen.update([]dockerImage{img}, t0.Add(2*time.Hour))
+	seen.update([]dockerImage{old, img}, t0.Add(3*time.Hour))
+
+	if seen[old.ID] != t0.Add(3*time.Hour).Unix() {
+		t.Errorf("a pulled again image kept its first sighting: %v", seen)
+	}
+}
@@ -0,0 +1,217 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// errNotFound is returned when a key does not exist.
+var errNotFound = errors.New("etcd: key not found")
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Etcd holds the settings used to reach the local etcd member. They follow
+// the ETCDCTL_* variables so the agent finds etcd just like etcdctl does.
+type Etcd struct {
+	Endpoint string
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+type etcdClient struct {
+	endpoint string
+	client   *http.Client
+}
+
+type etcdNode struct {
+	Key           string      `json:"key"`
+	Value         string      `json:"value,omitempty"`
+	Dir           bool        `json:"dir,omitempty"`
+	Nodes         []*etcdNode `json:"nodes,omitempty"`
+	ModifiedIndex uint64      `json:"modifiedIndex,omitempty"`
+}
+
+type etcdResponse struct {
+	Action string    `json:"action"`
+	Node   *etcdNode `json:"node"`
+}
+
+//-----------------------------------------------------------------------------
+// func: client
+//-----------------------------------------------------------------------------
+
+// client returns a v2 keys API client for the first endpoint.
+func (e Etcd) client() (*etcdClient, error) {
+
+	endpoint := strings.Split(e.Endpoint, ",")[0]
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:2379"
+	}
+
+	c := &etcdClient{
+		endpoint: strings.TrimRight(endpoint, "/") + "/v2/keys",
+		client:   http.DefaultClient,
+	}
+
+	if e.CAFile == "" && e.CertFile == "" {
+		return c, nil
+	}
+
+	// Client certificate:
+	cfg := &tls.Config{}
+	if e.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile)
+		if err != nil {
+			log.WithField("cmd", "agent:etcd").Error(err)
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	// Trusted CA:
+	if e.CAFile != "" {
+		pem, err := ioutil.ReadFile(e.CAFile)
+		if err != nil {
+			log.WithField("cmd", "agent:etcd").Error(err)
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			err := errors.New("no certificates found in " + e.CAFile)
+			log.WithField("cmd", "agent:etcd").Error(err)
+			return nil, err
+		}
+	}
+
+	c.client = &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	return c, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: get
+//-----------------------------------------------------------------------------
+
+// get reads a key. Directories are read recursively and sorted. The etcd
+// index at the time of the read is returned too.
+func (c *etcdClient) get(key string) (*etcdNode, uint64, error) {
+
+	res, err := c.client.Get(c.endpoint + key + "?recursive=true&sorted=true")
+	if err != nil {
+		log.WithField("cmd", "agent:etcd").Error(err)
+		return nil, 0, err
+	}
+
+	return decodeEtcd(res)
+}
+
+//-----------------------------------------------------------------------------
+// func: watch
+//-----------------------------------------------------------------------------
+
+// watch blocks until key, or anything below it, changes after index.
+func (c *etcdClient) watch(key string, index uint64) error {
+
+	res, err := c.client.Get(c.endpoint + key + "?wait=true&recursive=true&waitIndex=" +
+		strconv.FormatUint(index+1, 10))
+	if err != nil {
+		log.WithField("cmd", "agent:etcd").Error(err)
+		return err
+	}
+
+	// The index was cleared from the history, treat it as a change:
+	if res.StatusCode == http.StatusBadRequest {
+		res.Body.Close()
+		return nil
+	}
+
+	_, _, err = decodeEtcd(res)
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: set
+//-----------------------------------------------------------------------------
+
+// set writes a key. A zero ttl never expires.
+func (c *etcdClient) set(key, value string, ttl int) error {
+
+	// Forge the request:
+	v := url.Values{"value": {value}}
+	if ttl > 0 {
+		v.Set("ttl", strconv.Itoa(ttl))
+	}
+
+	req, err := http.NewRequest("PUT", c.endpoint+key, strings.NewReader(v.Encode()))
+	if err != nil {
+		log.WithField("cmd", "agent:etcd").Error(err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Send the request:
+	res, err := c.client.Do(req)
+	if err != nil {
+		log.WithField("cmd", "agent:etcd").Error(err)
+		return err
+	}
+
+	_, _, err = decodeEtcd(res)
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: decodeEtcd
+//-----------------------------------------------------------------------------
+
+func decodeEtcd(res *http.Response) (*etcdNode, uint64, error) {
+
+	defer res.Body.Close()
+	index, _ := strconv.ParseUint(res.Header.Get("X-Etcd-Index"), 10, 64)
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusNotFound:
+		return nil, index, errNotFound
+	default:
+		err := errors.New("etcd: " + res.Status)
+		log.WithField("cmd", "agent:etcd").Error(err)
+		return nil, index, err
+	}
+
+	r := etcdResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		log.WithField("cmd", "agent:etcd").Error(err)
+		return nil, index, err
+	}
+
+	if r.Node == nil {
+		err := errors.New("etcd: empty response")
+		log.WithField("cmd", "agent:etcd").Error(err)
+		return nil, index, err
+	}
+
+	return r.Node, index, nil
+}
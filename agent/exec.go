@@ -0,0 +1,332 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+const (
+
+	// fleetMachines is where fleet registers the machines of the cluster.
+	fleetMachines = "/_coreos.com/fleet/machines"
+
+	// safeChars need no quoting for a POSIX shell.
+	safeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Machine is a host as registered by fleet.
+type Machine struct {
+	ID       string            `json:"ID"`
+	IP       string            `json:"PublicIP"`
+	Metadata map[string]string `json:"Metadata"`
+}
+
+// Target is a host to run a command on.
+type Target struct {
+	Name    string // Shown in front of every output line.
+	Address string // Where to SSH to.
+}
+
+// SSH tunes Exec.
+type SSH struct {
+	User       string // Remote user, core by default.
+	Parallel   int    // Hosts running the command at the same time.
+	KnownHosts string // Known hosts file, the user's one if empty.
+	Jump       string // Optional bastion host, as in ssh -J.
+}
+
+//-----------------------------------------------------------------------------
+// func: Machines
+//-----------------------------------------------------------------------------
+
+// Machines returns the machines fleet knows about, sorted by IP, as in
+// (fleetctl list-machines).
+func Machines(e Etcd) ([]Machine, error) {
+
+	c, err := e.client()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, _, err := c.get(fleetMachines)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Machine
+	for _, n := range dir.Nodes {
+		for _, o := range n.Nodes {
+			if !strings.HasSuffix(o.Key, "/object") {
+				continue
+			}
+			m := Machine{}
+			if err := json.Unmarshal([]byte(o.Value), &m); err != nil {
+				log.WithField("cmd", "agent:etcd").Error(err)
+				return nil, err
+			}
+			list = append(list, m)
+		}
+	}
+
+	sort.Sort(byIP(list))
+	return list, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: FleetTargets
+//-----------------------------------------------------------------------------
+
+// FleetTargets returns the fleet machines with the given role metadata. An
+// empty role matches any machine.
+func FleetTargets(e Etcd, role string) ([]Target, error) {
+
+	machines, err := Machines(e)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []Target
+	for _, m := range machines {
+		if role != "" && m.Metadata["role"] != role {
+			continue
+		}
+		name := m.IP
+		if m.Metadata["role"] != "" && m.Metadata["id"] != "" {
+			name = m.Metadata["role"] + "-" + m.Metadata["id"]
+		}
+		targets = append(targets, Target{Name: name, Address: m.IP})
+	}
+
+	return targets, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: ExecAll
+//-----------------------------------------------------------------------------
+
+// ExecAll runs command over SSH on every machine of the cluster, one after
+// the other, as the core user.
+func ExecAll(e Etcd, command []string, stdout, stderr io.Writer) error {
+
+	targets, err := FleetTargets(e, "")
+	if err != nil {
+		return err
+	}
+
+	return Exec(targets, command, SSH{}, stdout, stderr)
+}
+
+//-----------------------------------------------------------------------------
+// func: Exec
+//-----------------------------------------------------------------------------
+
+// Exec runs command over SSH on the targets, o.Parallel at a time. Every
+// output line is prefixed with the name of its host. Host keys are pinned:
+// unknown hosts are added to the known hosts file on first contact and a
+// changed key is refused. A summary is written to stderr when any host
+// fails.
+func Exec(targets []Target, command []string, o SSH, stdout, stderr io.Writer) error {
+
+	if len(targets) == 0 {
+		err := errors.New("no hosts to run the command on")
+		log.WithField("cmd", "exec").Error(err)
+		return err
+	}
+
+	if o.Parallel < 1 {
+		o.Parallel = 1
+	}
+
+	if o.User == "" {
+		o.User = "core"
+	}
+
+	// Align the prefixes:
+	width := 0
+	for _, t := range targets {
+		if len(t.Name) > width {
+			width = len(t.Name)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make([]error, len(targets))
+	sem := make(chan struct{}, o.Parallel)
+
+	for i, t := range targets {
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, t Target) {
+
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			prefix := fmt.Sprintf("%-*s | ", width, t.Name)
+			out := &prefixWriter{w: stdout, mu: &mu, prefix: prefix}
+			errOut := &prefixWriter{w: stderr, mu: &mu, prefix: prefix}
+
+			cmd := exec.Command("ssh", o.args(t.Address, command)...)
+			cmd.Stdout, cmd.Stderr = out, errOut
+			results[i] = cmd.Run()
+
+			out.flush()
+			errOut.flush()
+
+		}(i, t)
+	}
+
+	wg.Wait()
+
+	// Count the failures:
+	failed := 0
+	for _, err := range results {
+		if err != nil {
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	// Per-host summary:
+	tw := tabwriter.NewWriter(stderr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tRESULT")
+	for i, t := range targets {
+		res := "ok"
+		if results[i] != nil {
+			res = results[i].Error()
+		}
+		fmt.Fprintln(tw, t.Name+"\t"+res)
+	}
+	tw.Flush()
+
+	err := errors.New("command failed on " + strconv.Itoa(failed) + " of " +
+		strconv.Itoa(len(targets)) + " hosts")
+	log.WithField("cmd", "exec").Error(err)
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: args
+//-----------------------------------------------------------------------------
+
+// args returns the ssh command line for address.
+func (o SSH) args(address string, command []string) []string {
+
+	args := []string{
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-l", o.User,
+	}
+
+	if o.KnownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+o.KnownHosts)
+	}
+
+	if o.Jump != "" {
+		args = append(args, "-J", o.Jump)
+	}
+
+	return append(args, address, "--", shellJoin(command))
+}
+
+//-----------------------------------------------------------------------------
+// func: shellJoin
+//-----------------------------------------------------------------------------
+
+// shellJoin quotes every argument for the remote shell, which ssh hands the
+// command to as a single string.
+func shellJoin(command []string) string {
+
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		if arg != "" && strings.Trim(arg, safeChars) == "" {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+//-----------------------------------------------------------------------------
+// prefixWriter:
+//-----------------------------------------------------------------------------
+
+// prefixWriter writes whole lines to w, each one preceded by prefix. Writers
+// sharing mu never interleave their lines.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+
+	p.buf.Write(b)
+
+	for {
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		p.emit(p.buf.Next(i + 1))
+	}
+}
+
+// flush writes the last line if it has no newline.
+func (p *prefixWriter) flush() {
+	if p.buf.Len() > 0 {
+		p.emit(append(p.buf.Bytes(), '\n'))
+		p.buf.Reset()
+	}
+}
+
+func (p *prefixWriter) emit(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	io.WriteString(p.w, p.prefix)
+	p.w.Write(line)
+}
+
+//-----------------------------------------------------------------------------
+// Sort by IP:
+//-----------------------------------------------------------------------------
+
+type byIP []Machine
+
+func (s byIP) Len() int           { return len(s) }
+func (s byIP) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byIP) Less(i, j int) bool { return s[i].IP < s[j].IP }
@@ -0,0 +1,46 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+// func: TestShellJoin
+//-----------------------------------------------------------------------------
+
+// The remote shell must split the joined command back into the very same
+// arguments.
+func TestShellJoin(t *testing.T) {
+
+	command := []string{
+		"printf", `%s\n`, "plain", "two words", "", "it's", `"double"`,
+		"$HOME", "`id`", "a;b|c&d", "*", "back\\slash", "new\nline",
+	}
+
+	joined := shellJoin(command)
+	if !strings.HasPrefix(joined, "printf '%s\\n' plain 'two words' '' ") {
+		t.Errorf("unexpected quoting: %s", joined)
+	}
+
+	out, err := exec.Command("sh", "-c", joined).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	want := append([]string{}, command[2:len(command)-1]...)
+	want = append(want, "new", "line")
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,175 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// hostsDir is where every host publishes its /etc/hosts lines.
+const hostsDir = "/hosts"
+
+// DefaultHostsTTL is how long a host stays in /etc/hosts after it stops
+// refreshing its record, in seconds.
+const DefaultHostsTTL = 300
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Hosts describes the files handled by HostsSync.
+type Hosts struct {
+	File string // The generated hosts file, /etc/hosts.
+	Base string // Static entries copied on top, /etc/.hosts.
+	TTL  int    // Seconds the record of this host lives in etcd.
+	Once bool   // Write the hosts file once and return.
+}
+
+//-----------------------------------------------------------------------------
+// func: HostsSync
+//-----------------------------------------------------------------------------
+
+// HostsSync publishes the entries of h under /hosts/<fqdn> with a TTL and
+// keeps refreshing them. The hosts file is rewritten with the static entries
+// followed by the ones of every other host each time a record is set or
+// expires, so dead hosts drop out once their TTL runs out.
+func HostsSync(e Etcd, h *Host, f Hosts) error {
+
+	c, err := e.client()
+	if err != nil {
+		return err
+	}
+
+	if f.TTL <= 0 {
+		f.TTL = DefaultHostsTTL
+	}
+
+	// Push our own entries:
+	key := path.Join(hostsDir, h.fqdn())
+	if err := c.set(key, hostsEntry(h), f.TTL); err != nil {
+		return err
+	}
+
+	// Refresh them before they expire:
+	if !f.Once {
+		go func() {
+			for range time.Tick(time.Duration(f.TTL) * time.Second / 3) {
+				if err := c.set(key, hostsEntry(h), f.TTL); err != nil {
+					log.WithFields(log.Fields{"cmd": "agent:hosts-sync", "id": key}).Warn(err)
+				}
+			}
+		}()
+	}
+
+	for {
+
+		// Pull everyone else's:
+		dir, index, err := c.get(hostsDir)
+		if err != nil {
+			return err
+		}
+
+		if err := writeHosts(f, h, dir); err != nil {
+			return err
+		}
+
+		if f.Once {
+			return nil
+		}
+
+		// Wait for a record to change:
+		if err := c.watch(hostsDir, index); err != nil {
+			return err
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: writeHosts
+//-----------------------------------------------------------------------------
+
+// writeHosts replaces the hosts file through a rename, so readers never see
+// it half written. Nothing is written if the content did not change.
+func writeHosts(f Hosts, h *Host, dir *etcdNode) error {
+
+	base, err := ioutil.ReadFile(f.Base)
+	if err != nil {
+		log.WithField("cmd", "agent:hosts-sync").Error(err)
+		return err
+	}
+
+	buf := bytes.NewBuffer(base)
+	count := 0
+	for _, n := range dir.Nodes {
+		if path.Base(n.Key) != h.fqdn() {
+			buf.WriteString(n.Value + "\n")
+			count++
+		}
+	}
+
+	// Same as before?
+	if old, err := ioutil.ReadFile(f.File); err == nil && bytes.Equal(old, buf.Bytes()) {
+		return nil
+	}
+
+	// Write a temporary file next to it:
+	tmp, err := ioutil.TempFile(filepath.Dir(f.File), ".hosts-sync")
+	if err != nil {
+		log.WithField("cmd", "agent:hosts-sync").Error(err)
+		return err
+	}
+
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	// And move it in place:
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.File)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.WithField("cmd", "agent:hosts-sync").Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "agent:hosts-sync", "id": f.File}).
+		Info("- Hosts file updated with " + strconv.Itoa(count) + " hosts")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: hostsEntry
+//-----------------------------------------------------------------------------
+
+// hostsEntry returns the /etc/hosts lines of h, as in:
+//
+//	10.0.1.11 master-1.example.com master-1
+//	10.0.1.11 master-1.int.example.com master-1.int
+func hostsEntry(h *Host) string {
+	return h.PrivateIP + " " + h.fqdn() + " " + h.Name + "\n" +
+		h.PrivateIP + " " + h.Name + ".int." + h.Domain + " " + h.Name + ".int"
+}
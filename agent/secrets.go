@@ -0,0 +1,68 @@
+package agent
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/h0tbird/kato/secrets"
+)
+
+//-----------------------------------------------------------------------------
+// func: OpenSecrets
+//-----------------------------------------------------------------------------
+
+// OpenSecrets opens the sealed bundle in and writes its secrets to out as a
+// systemd environment file, readable by root only.
+func OpenSecrets(b secrets.Backend, in, out string) error {
+
+	sealed, err := ioutil.ReadFile(in)
+	if err != nil {
+		log.WithField("cmd", "agent:secrets").Error(err)
+		return err
+	}
+
+	s, err := secrets.Open(b, strings.TrimSpace(string(sealed)))
+	if err != nil {
+		return err
+	}
+
+	// One KEY=value line per secret:
+	keys := []string{}
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString(k + "=" + s[k] + "\n")
+	}
+
+	// Replace the file atomically:
+	tmp := out + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		log.WithField("cmd", "agent:secrets").Error(err)
+		return err
+	}
+
+	if err := os.Rename(tmp, out); err != nil {
+		log.WithField("cmd", "agent:secrets").Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "agent:secrets", "id": out}).
+		Info("Opened " + strconv.Itoa(len(keys)) + " secrets")
+	return nil
+}
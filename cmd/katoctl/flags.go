@@ -0,0 +1,161 @@
+package main
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"os"
+	"strconv"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// flagSource resolves deploy settings on top of a spec file: a flag in the
+// command line wins over its environment variable, which wins over the spec,
+// which wins over the flag default. The raw values are read on purpose, as
+// kingpin neither parses nor defaults the flags of the provider subcommand
+// when the provider comes from the spec.
+type flagSource struct {
+	set      map[string][]string // Command line or environment values.
+	defaults map[string][]string // Flag defaults.
+	err      error               // First value that failed to parse.
+}
+
+//-----------------------------------------------------------------------------
+// func: newFlagSource
+//-----------------------------------------------------------------------------
+
+// newFlagSource collects the flags of cmds from args and the environment.
+func newFlagSource(args []string, cmds ...*kingpin.CmdClause) (*flagSource, error) {
+
+	s := &flagSource{set: map[string][]string{}, defaults: map[string][]string{}}
+
+	// Flags found in the command line:
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		log.WithField("cmd", "deploy").Error(err)
+		return nil, err
+	}
+
+	for _, e := range ctx.Elements {
+		if f, ok := e.Clause.(*kingpin.FlagClause); ok && e.Value != nil {
+			name := f.Model().Name
+			s.set[name] = append(s.set[name], *e.Value)
+		}
+	}
+
+	// Flags found in the environment, and the defaults:
+	for _, c := range cmds {
+		for _, f := range c.Model().Flags {
+			if _, ok := s.set[f.Name]; !ok && f.Envar != "" && os.Getenv(f.Envar) != "" {
+				s.set[f.Name] = []string{os.Getenv(f.Envar)}
+			}
+			if len(f.Default) > 0 {
+				s.defaults[f.Name] = f.Default
+			}
+		}
+	}
+
+	return s, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: value
+//-----------------------------------------------------------------------------
+
+// value returns the value set by the user or, if the spec left the field
+// empty, the flag default.
+func (s *flagSource) value(name string, empty bool) (string, bool) {
+
+	if v, ok := s.set[name]; ok {
+		return v[len(v)-1], true
+	}
+
+	if d := s.defaults[name]; empty && len(d) > 0 {
+		return d[len(d)-1], true
+	}
+
+	return "", false
+}
+
+//-----------------------------------------------------------------------------
+// func: str
+//-----------------------------------------------------------------------------
+
+func (s *flagSource) str(name string, dst *string) {
+	if v, ok := s.value(name, *dst == ""); ok {
+		*dst = v
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: int
+//-----------------------------------------------------------------------------
+
+func (s *flagSource) int(name string, dst *int) {
+
+	v, ok := s.value(name, *dst == 0)
+	if !ok {
+		return
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		s.fail(name, v)
+		return
+	}
+
+	*dst = i
+}
+
+//-----------------------------------------------------------------------------
+// func: bool
+//-----------------------------------------------------------------------------
+
+func (s *flagSource) bool(name string, dst *bool) {
+
+	v, ok := s.value(name, !*dst)
+	if !ok {
+		return
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		s.fail(name, v)
+		return
+	}
+
+	*dst = b
+}
+
+//-----------------------------------------------------------------------------
+// func: strings
+//-----------------------------------------------------------------------------
+
+// strings replaces a spec list with the repeated flag, if given.
+func (s *flagSource) strings(name string, dst *[]string) {
+	if v, ok := s.set[name]; ok {
+		*dst = v
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: fail
+//-----------------------------------------------------------------------------
+
+func (s *flagSource) fail(name, value string) {
+	if s.err == nil {
+		s.err = errors.New("invalid value for --" + name + ": " + value)
+		log.WithField("cmd", "deploy").Error(s.err)
+	}
+}
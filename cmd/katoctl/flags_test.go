@@ -0,0 +1,147 @@
+package main
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+const testSpec = `version: v1
+provider: ec2
+domain: cell-1.dc-1.kato.lan
+region: eu-west-1
+channel: stable
+keyPair: my-key
+ns1ApiKey: xxx
+master: { count: 3, type: t2.large }
+`
+
+//-----------------------------------------------------------------------------
+// func: TestDeploySpecPrecedence
+//-----------------------------------------------------------------------------
+
+// A flag wins over its variable, which wins over the spec, which wins over
+// the flag default.
+func TestDeploySpecPrecedence(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir, err := ioutil.TempDir("", "katoctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "cluster.yaml")
+	if err := ioutil.WriteFile(file, []byte(testSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		region   string
+		nodeType string
+		plan     bool
+	}{
+		{
+			name:     "spec",
+			args:     []string{"deploy", "-f", file},
+			region:   "eu-west-1",
+			nodeType: "t2.medium",
+		},
+		{
+			name:     "env over spec",
+			args:     []string{"deploy", "-f", file},
+			env:      map[string]string{"KATO_DEPLOY_EC2_REGION": "us-east-1"},
+			region:   "us-east-1",
+			nodeType: "t2.medium",
+		},
+		{
+			name:     "env over default",
+			args:     []string{"deploy", "-f", file, "ec2"},
+			env:      map[string]string{"KATO_DEPLOY_EC2_NODE_TYPE": "m3.large"},
+			region:   "eu-west-1",
+			nodeType: "m3.large",
+		},
+		{
+			name:     "flag over env",
+			args:     []string{"deploy", "-f", file, "ec2", "--region", "ap-south-1"},
+			env:      map[string]string{"KATO_DEPLOY_EC2_REGION": "us-east-1"},
+			region:   "ap-south-1",
+			nodeType: "t2.medium",
+		},
+		{
+			name:     "plan from spec",
+			args:     []string{"deploy", "-f", file, "--plan"},
+			region:   "eu-west-1",
+			nodeType: "t2.medium",
+			plan:     true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+
+			for _, k := range []string{"KATO_DEPLOY_FILE", "KATO_DEPLOY_EC2_REGION", "KATO_DEPLOY_EC2_NODE_TYPE"} {
+				t.Setenv(k, c.env[k])
+			}
+
+			s, src, err := deploySpec("", c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.Region != c.region {
+				t.Errorf("region: got %q, want %q", s.Region, c.region)
+			}
+
+			if s.Node.Type != c.nodeType {
+				t.Errorf("node type: got %q, want %q", s.Node.Type, c.nodeType)
+			}
+
+			// Spec values survive and defaults fill the gaps:
+			if s.Master.Type != "t2.large" || s.Network.VpcCidrBlock != "10.0.0.0/16" || s.EtcdToken != "auto" {
+				t.Errorf("spec or defaults lost: %+v", s)
+			}
+
+			var plan bool
+			src.bool("plan", &plan)
+			if plan != c.plan {
+				t.Errorf("plan: got %v, want %v", plan, c.plan)
+			}
+		})
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestDeploySpecBadEnv
+//-----------------------------------------------------------------------------
+
+func TestDeploySpecBadEnv(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	t.Setenv("KATO_DEPLOY_EC2_NODE_COUNT", "two")
+
+	if _, _, err := deploySpec("ec2", []string{"deploy", "ec2"}); err == nil {
+		t.Error("an invalid KATO_DEPLOY_EC2_NODE_COUNT was accepted")
+	}
+}
@@ -7,12 +7,24 @@ package main
 import (
 
 	// Stdlib:
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	// Local:
+	"github.com/h0tbird/kato/agent"
+	"github.com/h0tbird/kato/discovery"
+	"github.com/h0tbird/kato/dns"
+	"github.com/h0tbird/kato/katool"
+	"github.com/h0tbird/kato/pki"
 	"github.com/h0tbird/kato/providers/ec2"
 	"github.com/h0tbird/kato/providers/pkt"
+	"github.com/h0tbird/kato/secrets"
+	"github.com/h0tbird/kato/spec"
+	"github.com/h0tbird/kato/state"
 	"github.com/h0tbird/kato/udata"
 
 	// Community:
@@ -28,6 +40,7 @@ type cloudProvider interface {
 	Deploy() error
 	Setup() error
 	Run(udata []byte) error
+	Destroy() error
 }
 
 //-----------------------------------------------------------------------------
@@ -42,18 +55,48 @@ var (
 
 	app = kingpin.New("katoctl", "Katoctl defines and deploys Kato's infrastructure.")
 
+	flStateDir = app.Flag("state-dir", "Directory holding the per-cluster state.").
+			Default(state.DefaultDir()).OverrideDefaultFromEnvar("KATO_STATE_DIR").
+			String()
+
 	//---------------------------
 	// deploy: top level command
 	//---------------------------
 
 	cmdDeploy = app.Command("deploy", "Deploy Kato's infrastructure.")
 
+	flDeployFile = cmdDeploy.Flag("file", "Path to a cluster spec file.").
+			PlaceHolder("KATO_DEPLOY_FILE").
+			OverrideDefaultFromEnvar("KATO_DEPLOY_FILE").
+			Short('f').String()
+
+	//-----------------------------
+	// deploy spec: nested command
+	//-----------------------------
+
+	cmdDeploySpec = cmdDeploy.Command("spec", "Deploy on the provider named in the spec file.").
+			Default().Hidden()
+
+	flDeploySpecPlan = cmdDeploySpec.Flag("plan", "Print what would be created and exit.").
+				Default("false").OverrideDefaultFromEnvar("KATO_DEPLOY_SPEC_PLAN").
+				Bool()
+
+	flDeploySpecKeepOnFailure = cmdDeploySpec.Flag("keep-on-failure", "Do not roll back what a failed setup created.").
+					Default("false").OverrideDefaultFromEnvar("KATO_DEPLOY_SPEC_KEEP_ON_FAILURE").
+					Bool()
+
 	//--------------------------
 	// setup: top level command
 	//--------------------------
 
 	cmdSetup = app.Command("setup", "Setup the IaaS provider.")
 
+	//----------------------------
+	// destroy: top level command
+	//----------------------------
+
+	cmdDestroy = app.Command("destroy", "Destroy Kato's infrastructure.")
+
 	//--------------------------
 	// udata: top level command
 	//--------------------------
@@ -74,30 +117,126 @@ var (
 			OverrideDefaultFromEnvar("KATO_UDATA_DOMAIN").
 			Short('d').String()
 
-	flUdataRole = cmdUdata.Flag("role", "Choose one of [ master | node | edge ]").
+	flUdataRole = cmdUdata.Flag("role", "One of [ master | node | edge ] or a role from --roles-file.").
 			Required().PlaceHolder("KATO_UDATA_ROLE").
 			OverrideDefaultFromEnvar("KATO_UDATA_ROLE").
 			Short('r').HintOptions("master", "node", "edge").String()
 
+	flUdataDNSProvider = cmdUdata.Flag("dns-provider", "DNS provider [ ns1 | route53 | rfc2136 | none ]").
+				Default("ns1").OverrideDefaultFromEnvar("KATO_UDATA_DNS_PROVIDER").
+				Enum(dns.Providers...)
+
 	flUdataNs1Apikey = cmdUdata.Flag("ns1-api-key", "NS1 private API key.").
-				Required().PlaceHolder("KATO_UDATA_NS1_API_KEY").
+				PlaceHolder("KATO_UDATA_NS1_API_KEY").
 				OverrideDefaultFromEnvar("KATO_UDATA_NS1_API_KEY").
 				Short('k').String()
 
+	flUdataRfc2136Server = cmdUdata.Flag("rfc2136-server", "RFC2136 DNS server as host[:port].").
+				PlaceHolder("KATO_UDATA_RFC2136_SERVER").
+				OverrideDefaultFromEnvar("KATO_UDATA_RFC2136_SERVER").
+				String()
+
+	flUdataRfc2136TSIGKey = cmdUdata.Flag("rfc2136-tsig-key", "RFC2136 TSIG key as [algorithm:]name:secret").
+				PlaceHolder("KATO_UDATA_RFC2136_TSIG_KEY").
+				OverrideDefaultFromEnvar("KATO_UDATA_RFC2136_TSIG_KEY").
+				String()
+
+	flUdataKatoctlURL = cmdUdata.Flag("katoctl-url", "Where the hosts download katoctl from, this release by default.").
+				PlaceHolder("KATO_UDATA_KATOCTL_URL").
+				OverrideDefaultFromEnvar("KATO_UDATA_KATOCTL_URL").
+				String()
+
+	flUdataKatoctlSHA256 = cmdUdata.Flag("katoctl-sha256", "Checksum the hosts verify katoctl against.").
+				PlaceHolder("KATO_UDATA_KATOCTL_SHA256").
+				OverrideDefaultFromEnvar("KATO_UDATA_KATOCTL_SHA256").
+				String()
+
 	flUdataCaCert = cmdUdata.Flag("ca-cert", "Path to CA certificate.").
 			PlaceHolder("KATO_UDATA_CA_CERT").
 			OverrideDefaultFromEnvar("KATO_UDATA_CA_CERT").
 			Short('c').String()
 
+	flUdataVersionsFile = cmdUdata.Flag("versions-file", "YAML file pinning the component images.").
+				PlaceHolder("KATO_UDATA_VERSIONS_FILE").
+				OverrideDefaultFromEnvar("KATO_UDATA_VERSIONS_FILE").
+				String()
+
+	flUdataSecretsBackend = cmdUdata.Flag("secrets-backend", "Seal the secrets with [ none | file | vault ]").
+				Default("none").OverrideDefaultFromEnvar("KATO_UDATA_SECRETS_BACKEND").
+				Enum(secrets.Backends...)
+
+	flUdataSecretsKeyFile = cmdUdata.Flag("secrets-key-file", "Key of the file backend, derived from the cluster CA if missing.").
+				PlaceHolder("KATO_UDATA_SECRETS_KEY_FILE").
+				OverrideDefaultFromEnvar("KATO_UDATA_SECRETS_KEY_FILE").
+				String()
+
+	flUdataVaultAddr = cmdUdata.Flag("vault-addr", "Vault server of the vault backend.").
+				PlaceHolder("VAULT_ADDR").
+				OverrideDefaultFromEnvar("VAULT_ADDR").
+				String()
+
+	flUdataVaultToken = cmdUdata.Flag("vault-token", "Vault token allowed to encrypt with the transit key.").
+				PlaceHolder("VAULT_TOKEN").
+				OverrideDefaultFromEnvar("VAULT_TOKEN").
+				String()
+
+	flUdataVaultTransitKey = cmdUdata.Flag("vault-transit-key", "Vault transit key.").
+				Default("kato").OverrideDefaultFromEnvar("KATO_UDATA_VAULT_TRANSIT_KEY").
+				String()
+
+	flUdataRolesFile = cmdUdata.Flag("roles-file", "YAML file defining extra or replacement roles.").
+				PlaceHolder("KATO_UDATA_ROLES_FILE").
+				OverrideDefaultFromEnvar("KATO_UDATA_ROLES_FILE").
+				String()
+
+	flUdataTemplatesDir = cmdUdata.Flag("templates-dir", "Directory of <fragment>.tmpl files replacing the built-in ones.").
+				PlaceHolder("KATO_UDATA_TEMPLATES_DIR").
+				OverrideDefaultFromEnvar("KATO_UDATA_TEMPLATES_DIR").
+				String()
+
+	flUdataExtraFiles = cmdUdata.Flag("extra-file", "Extra file to write as src:dest[:mode] (repeatable).").
+				PlaceHolder("SRC:DEST[:MODE]").Strings()
+
+	flUdataExtraUnits = cmdUdata.Flag("extra-unit", "Extra systemd unit file to start (repeatable).").
+				PlaceHolder("PATH").Strings()
+
+	flUdataSSHKeys = cmdUdata.Flag("ssh-key", "SSH public key, or key file, authorized for core (repeatable).").
+			PlaceHolder("KEY").Strings()
+
 	flUdataEtcdToken = cmdUdata.Flag("etcd-token", "Provide an etcd discovery token.").
 				PlaceHolder("KATO_UDATA_ETCD_TOKEN").
 				OverrideDefaultFromEnvar("KATO_UDATA_ETCD_TOKEN").
 				Short('e').String()
 
+	flUdataEtcdDiscoveryURL = cmdUdata.Flag("etcd-discovery-url", "Base URL of the etcd discovery service.").
+				Default(katool.DefaultDiscoveryURL).
+				OverrideDefaultFromEnvar("KATO_UDATA_ETCD_DISCOVERY_URL").
+				String()
+
+	flUdataEtcdTLS = cmdUdata.Flag("etcd-tls", "Secure etcd with the cluster PKI.").
+			Default("false").OverrideDefaultFromEnvar("KATO_UDATA_ETCD_TLS").
+			Bool()
+
 	flUdataGzipUdata = cmdUdata.Flag("gzip-udata", "Enable udata compression.").
 				Default("false").OverrideDefaultFromEnvar("KATO_UDATA_GZIP_UDATA").
 				Short('g').Bool()
 
+	flUdataBase64Udata = cmdUdata.Flag("base64-udata", "Encode udata in base64.").
+				Default("false").OverrideDefaultFromEnvar("KATO_UDATA_BASE64_UDATA").
+				Bool()
+
+	flUdataValidate = cmdUdata.Flag("validate", "Check the rendered cloud-config before writing it.").
+			Default("false").OverrideDefaultFromEnvar("KATO_UDATA_VALIDATE").
+			Bool()
+
+	flUdataFormat = cmdUdata.Flag("format", "Output format [ cloud-config | ignition ]").
+			Default("cloud-config").OverrideDefaultFromEnvar("KATO_UDATA_FORMAT").
+			Enum("cloud-config", "ignition")
+
+	flUdataPlatform = cmdUdata.Flag("platform", "Ignition metadata platform [ ec2 | packet | openstack | gce ]").
+			Default("ec2").OverrideDefaultFromEnvar("KATO_UDATA_PLATFORM").
+			Enum("ec2", "packet", "openstack", "gce")
+
 	flUdataFlannelNetwork = cmdUdata.Flag("flannel-network", "Flannel entire overlay network.").
 				Default("10.128.0.0/21").OverrideDefaultFromEnvar("KATO_UDATA_FLANNEL_NETWORK").
 				Short('n').String()
@@ -128,6 +267,282 @@ var (
 				OverrideDefaultFromEnvar("KATO_UDATA_REXRAY_ENDPOINT_IP").
 				String()
 
+	//------------------------------
+	// discovery: top level command
+	//------------------------------
+
+	cmdDiscovery = app.Command("discovery", "Self-hosted etcd discovery service.")
+
+	//---------------------------------
+	// discovery serve: nested command
+	//---------------------------------
+
+	cmdDiscoveryServe = cmdDiscovery.Command("serve", "Serve the etcd discovery protocol.")
+
+	flDiscoveryServeListen = cmdDiscoveryServe.Flag("listen", "Address to listen on.").
+				Default(":8087").OverrideDefaultFromEnvar("KATO_DISCOVERY_SERVE_LISTEN").
+				Short('l').String()
+
+	flDiscoveryServeURL = cmdDiscoveryServe.Flag("url", "Public base URL returned with new tokens.").
+				PlaceHolder("KATO_DISCOVERY_SERVE_URL").
+				OverrideDefaultFromEnvar("KATO_DISCOVERY_SERVE_URL").
+				Short('u').String()
+
+	flDiscoveryServeBackend = cmdDiscoveryServe.Flag("backend", "Token storage [ file | etcd ]").
+				Default("file").OverrideDefaultFromEnvar("KATO_DISCOVERY_SERVE_BACKEND").
+				Enum("file", "etcd")
+
+	flDiscoveryServeFile = cmdDiscoveryServe.Flag("file", "Token file for the file backend.").
+				PlaceHolder("<state-dir>/discovery.json").
+				OverrideDefaultFromEnvar("KATO_DISCOVERY_SERVE_FILE").
+				String()
+
+	flDiscoveryServeEtcd = cmdDiscoveryServe.Flag("etcd-endpoint", "Etcd endpoint for the etcd backend.").
+				Default("http://127.0.0.1:2379").
+				OverrideDefaultFromEnvar("KATO_DISCOVERY_SERVE_ETCD_ENDPOINT").
+				String()
+
+	//------------------------
+	// pki: top level command
+	//------------------------
+
+	cmdPki = app.Command("pki", "Manage the cluster certificates.")
+
+	flPkiDomain = cmdPki.Flag("domain", "Domain name of the cluster.").
+			Required().PlaceHolder("KATO_PKI_DOMAIN").
+			OverrideDefaultFromEnvar("KATO_PKI_DOMAIN").
+			Short('d').String()
+
+	//--------------------------
+	// pki init: nested command
+	//--------------------------
+
+	cmdPkiInit = cmdPki.Command("init", "Create the cluster CA.")
+
+	flPkiInitCaCert = cmdPkiInit.Flag("ca-cert", "Import this CA certificate instead of creating one.").
+			PlaceHolder("KATO_PKI_INIT_CA_CERT").
+			OverrideDefaultFromEnvar("KATO_PKI_INIT_CA_CERT").
+			String()
+
+	flPkiInitCaKey = cmdPkiInit.Flag("ca-key", "Private key of the imported CA certificate.").
+			PlaceHolder("KATO_PKI_INIT_CA_KEY").
+			OverrideDefaultFromEnvar("KATO_PKI_INIT_CA_KEY").
+			String()
+
+	//---------------------------
+	// pki issue: nested command
+	//---------------------------
+
+	cmdPkiIssue = cmdPki.Command("issue", "Issue host certificates signed by the cluster CA.")
+
+	flPkiIssueRole = cmdPkiIssue.Flag("role", "Choose one of [ master | node | edge ]").
+			Required().PlaceHolder("KATO_PKI_ISSUE_ROLE").
+			OverrideDefaultFromEnvar("KATO_PKI_ISSUE_ROLE").
+			Short('r').HintOptions("master", "node", "edge").String()
+
+	flPkiIssueHostIDs = cmdPkiIssue.Flag("hostid", "Host ID, can be repeated: hostname = <role>-<hostid>").
+				Required().Short('i').Strings()
+
+	//-------------------------
+	// exec: top level command
+	//-------------------------
+
+	cmdExec = app.Command("exec", "Run a command over SSH on the cluster hosts.")
+
+	flExecDomain = cmdExec.Flag("domain", "Domain name of the cluster.").
+			PlaceHolder("KATO_EXEC_DOMAIN").
+			OverrideDefaultFromEnvar("KATO_EXEC_DOMAIN").
+			Short('d').String()
+
+	flExecRole = cmdExec.Flag("role", "Only the hosts with this role.").
+			OverrideDefaultFromEnvar("KATO_EXEC_ROLE").
+			Short('r').String()
+
+	flExecParallel = cmdExec.Flag("parallel", "Hosts running the command at the same time.").
+			Default("10").OverrideDefaultFromEnvar("KATO_EXEC_PARALLEL").
+			Short('p').Int()
+
+	flExecFrom = cmdExec.Flag("from", "Where to find the hosts [ state | fleet ]").
+			Default("state").OverrideDefaultFromEnvar("KATO_EXEC_FROM").
+			Enum("state", "fleet")
+
+	flExecZone = cmdExec.Flag("zone", "DNS zone used to reach the hosts found in the state [ int | ext ]").
+			Default("int").OverrideDefaultFromEnvar("KATO_EXEC_ZONE").
+			Enum("int", "ext")
+
+	flExecUser = cmdExec.Flag("user", "Remote user.").
+			Default("core").OverrideDefaultFromEnvar("KATO_EXEC_USER").
+			String()
+
+	flExecJump = cmdExec.Flag("jump", "Bastion host to jump through, as in ssh -J.").
+			PlaceHolder("KATO_EXEC_JUMP").
+			OverrideDefaultFromEnvar("KATO_EXEC_JUMP").
+			String()
+
+	flExecKnownHosts = cmdExec.Flag("known-hosts", "Where the host keys are pinned.").
+				PlaceHolder("<state-dir>/<domain>/known_hosts").
+				OverrideDefaultFromEnvar("KATO_EXEC_KNOWN_HOSTS").
+				String()
+
+	execEtcd = etcdFlags(cmdExec, " Used with --from fleet.")
+
+	arExecCommand = cmdExec.Arg("command", "Command to run.").
+			Required().Strings()
+
+	//--------------------------
+	// agent: top level command
+	//--------------------------
+
+	cmdAgent = app.Command("agent", "Host side tasks run by the Kato units.")
+
+	flAgentDomain = cmdAgent.Flag("domain", "Domain name, defaults to (hostname -d).").
+			PlaceHolder("KATO_AGENT_DOMAIN").
+			OverrideDefaultFromEnvar("KATO_AGENT_DOMAIN").
+			String()
+
+	flAgentPrivateIP = cmdAgent.Flag("private-ip", "Private IP, defaults to (hostname -i).").
+				PlaceHolder("KATO_AGENT_PRIVATE_IP").
+				OverrideDefaultFromEnvar("KATO_AGENT_PRIVATE_IP").
+				String()
+
+	agentEtcd = etcdFlags(cmdAgent, "")
+
+	//----------------------------------
+	// agent hosts-sync: nested command
+	//----------------------------------
+
+	cmdAgentHostsSync = cmdAgent.Command("hosts-sync", "Share this host in etcd and keep /etc/hosts in sync.")
+
+	flAgentHostsSyncFile = cmdAgentHostsSync.Flag("hosts-file", "The hosts file to write.").
+				Default("/etc/hosts").String()
+
+	flAgentHostsSyncBase = cmdAgentHostsSync.Flag("base-file", "Static entries written on top.").
+				Default("/etc/.hosts").String()
+
+	flAgentHostsSyncTTL = cmdAgentHostsSync.Flag("ttl", "Seconds before a silent host is dropped.").
+				Default(strconv.Itoa(agent.DefaultHostsTTL)).Int()
+
+	flAgentHostsSyncOnce = cmdAgentHostsSync.Flag("once", "Write the hosts file once and exit.").
+				Bool()
+
+	//---------------------------------
+	// agent docker-gc: nested command
+	//---------------------------------
+
+	cmdAgentDockerGC = cmdAgent.Command("docker-gc", "Remove the containers, images and volumes nobody uses.")
+
+	flAgentDockerGCSocket = cmdAgentDockerGC.Flag("docker-socket", "Docker daemon unix socket.").
+				Default(agent.DefaultDockerSocket).String()
+
+	flAgentDockerGCKeep = cmdAgentDockerGC.Flag("keep", "Never remove images matching this regex, can be repeated.").
+				PlaceHolder("REGEX").Strings()
+
+	flAgentDockerGCMinAge = cmdAgentDockerGC.Flag("min-age", "Never remove images seen here for less than this.").
+				Default("1h").Duration()
+
+	flAgentDockerGCStateFile = cmdAgentDockerGC.Flag("state-file", "Where the first sighting of every image is kept.").
+					Default(agent.DefaultGCStateFile).String()
+
+	flAgentDockerGCHighWatermark = cmdAgentDockerGC.Flag("high-watermark", "Remove unused images only above this disk usage (%).").
+					Default("0").Int()
+
+	flAgentDockerGCDockerRoot = cmdAgentDockerGC.Flag("docker-root", "Filesystem checked against the high watermark.").
+					Default("/var/lib/docker").String()
+
+	flAgentDockerGCDryRun = cmdAgentDockerGC.Flag("dry-run", "Report what would be removed.").
+				Bool()
+
+	//--------------------------------
+	// agent exec-all: nested command
+	//--------------------------------
+
+	cmdAgentExecAll = cmdAgent.Command("exec-all", "Run a command over SSH on every fleet machine.")
+
+	arAgentExecAllCommand = cmdAgentExecAll.Arg("command", "Command to run.").
+				Required().Strings()
+
+	//-----------------------------------
+	// agent dns-publish: nested command
+	//-----------------------------------
+
+	cmdAgentDNSPublish = cmdAgent.Command("dns-publish", "Publish the int and ext A records of this host.")
+
+	flAgentDNSPublishProvider = cmdAgentDNSPublish.Flag("provider", "DNS provider [ ns1 | route53 | rfc2136 | none ]").
+					Default("none").OverrideDefaultFromEnvar("KATO_AGENT_DNS_PUBLISH_PROVIDER").
+					Enum(dns.Providers...)
+
+	flAgentDNSPublishPublicIP = cmdAgentDNSPublish.Flag("public-ip", "Public IP, defaults to the one seen by OpenDNS.").
+					PlaceHolder("KATO_AGENT_DNS_PUBLISH_PUBLIC_IP").
+					OverrideDefaultFromEnvar("KATO_AGENT_DNS_PUBLISH_PUBLIC_IP").
+					String()
+
+	flAgentDNSPublishTTL = cmdAgentDNSPublish.Flag("ttl", "TTL of the records.").
+				Default(strconv.Itoa(dns.DefaultTTL)).
+				OverrideDefaultFromEnvar("KATO_AGENT_DNS_PUBLISH_TTL").
+				Int()
+
+	flAgentDNSPublishNs1ApiKey = cmdAgentDNSPublish.Flag("ns1-api-key", "NS1 private API key.").
+					PlaceHolder("KATO_AGENT_DNS_PUBLISH_NS1_API_KEY").
+					OverrideDefaultFromEnvar("KATO_AGENT_DNS_PUBLISH_NS1_API_KEY").
+					String()
+
+	flAgentDNSPublishNs1Endpoint = cmdAgentDNSPublish.Flag("ns1-endpoint", "NS1 API endpoint.").
+					Hidden().OverrideDefaultFromEnvar("KATO_AGENT_DNS_PUBLISH_NS1_ENDPOINT").
+					String()
+
+	flAgentDNSPublishRoute53Endpoint = cmdAgentDNSPublish.Flag("route53-endpoint", "Route53 API endpoint.").
+						Hidden().OverrideDefaultFromEnvar("KATO_AGENT_DNS_PUBLISH_ROUTE53_ENDPOINT").
+						String()
+
+	flAgentDNSPublishRfc2136Server = cmdAgentDNSPublish.Flag("rfc2136-server", "RFC2136 DNS server as host[:port].").
+					PlaceHolder("KATO_AGENT_DNS_PUBLISH_RFC2136_SERVER").
+					OverrideDefaultFromEnvar("KATO_AGENT_DNS_PUBLISH_RFC2136_SERVER").
+					String()
+
+	flAgentDNSPublishRfc2136TSIGKey = cmdAgentDNSPublish.Flag("rfc2136-tsig-key", "RFC2136 TSIG key as [algorithm:]name:secret").
+					PlaceHolder("KATO_AGENT_DNS_PUBLISH_RFC2136_TSIG_KEY").
+					OverrideDefaultFromEnvar("KATO_AGENT_DNS_PUBLISH_RFC2136_TSIG_KEY").
+					String()
+
+	//-------------------------------
+	// agent secrets: nested command
+	//-------------------------------
+
+	cmdAgentSecrets = cmdAgent.Command("secrets", "Open the sealed secrets of this host.")
+
+	flAgentSecretsBackend = cmdAgentSecrets.Flag("backend", "Secrets backend [ file | vault ]").
+				Default("file").OverrideDefaultFromEnvar("KATO_AGENT_SECRETS_BACKEND").
+				Enum("file", "vault")
+
+	flAgentSecretsIn = cmdAgentSecrets.Flag("in", "The sealed bundle.").
+				Default("/etc/kato/secrets.sealed").
+				OverrideDefaultFromEnvar("KATO_AGENT_SECRETS_IN").
+				String()
+
+	flAgentSecretsOut = cmdAgentSecrets.Flag("out", "Environment file to write the secrets to.").
+				Default("/etc/kato/secrets.env").
+				OverrideDefaultFromEnvar("KATO_AGENT_SECRETS_OUT").
+				String()
+
+	flAgentSecretsKeyFile = cmdAgentSecrets.Flag("key-file", "Key of the file backend.").
+				Default("/etc/kato/secrets.key").
+				OverrideDefaultFromEnvar("KATO_AGENT_SECRETS_KEY_FILE").
+				String()
+
+	flAgentSecretsVaultAddr = cmdAgentSecrets.Flag("vault-addr", "Vault server of the vault backend.").
+				PlaceHolder("VAULT_ADDR").
+				OverrideDefaultFromEnvar("VAULT_ADDR").
+				String()
+
+	flAgentSecretsVaultTokenFile = cmdAgentSecrets.Flag("vault-token-file", "File holding a Vault token allowed to decrypt.").
+					Default("/etc/kato/vault-token").
+					OverrideDefaultFromEnvar("KATO_AGENT_SECRETS_VAULT_TOKEN_FILE").
+					String()
+
+	flAgentSecretsVaultTransitKey = cmdAgentSecrets.Flag("vault-transit-key", "Vault transit key.").
+					Default("kato").OverrideDefaultFromEnvar("KATO_AGENT_SECRETS_VAULT_TRANSIT_KEY").
+					String()
+
 	//------------------------
 	// run: top level command
 	//------------------------
@@ -190,6 +605,11 @@ var (
 			Default("hourly").OverrideDefaultFromEnvar("KATO_RUN_PKT_BILLING").
 			Short('b').HintOptions("hourly", "monthly").String()
 
+	flRunPktDomain = cmdRunPacket.Flag("domain", "Record the server in this cluster's state.").
+			PlaceHolder("KATO_RUN_PKT_DOMAIN").
+			OverrideDefaultFromEnvar("KATO_RUN_PKT_DOMAIN").
+			Short('d').String()
+
 	//----------------------------
 	// deploy ec2: nested command
 	//----------------------------
@@ -197,17 +617,17 @@ var (
 	cmdDeployEc2 = cmdDeploy.Command("ec2", "Deploy Kato's infrastructure on Amazon EC2.")
 
 	flDeployEc2MasterCount = cmdDeployEc2.Flag("master-count", "Number of master nodes to deploy [ 1 | 3 | 5 ]").
-				Required().PlaceHolder("KATO_DEPLOY_EC2_MASTER_COUNT").
+				PlaceHolder("KATO_DEPLOY_EC2_MASTER_COUNT").
 				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_MASTER_COUNT").
 				Short('m').HintOptions("1", "3", "5").Int()
 
 	flDeployEc2NodeCount = cmdDeployEc2.Flag("node-count", "Number of worker nodes to deploy.").
-				Required().PlaceHolder("KATO_DEPLOY_EC2_NODE_COUNT").
+				PlaceHolder("KATO_DEPLOY_EC2_NODE_COUNT").
 				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_NODE_COUNT").
 				Short('n').Int()
 
 	flDeployEc2EdgeCount = cmdDeployEc2.Flag("edge-count", "Number of edge nodes to deploy.").
-				Required().PlaceHolder("KATO_DEPLOY_EC2_EDGE_COUNT").
+				PlaceHolder("KATO_DEPLOY_EC2_EDGE_COUNT").
 				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_EDGE_COUNT").
 				Short('e').Int()
 
@@ -224,7 +644,7 @@ var (
 				String()
 
 	flDeployEc2Channel = cmdDeployEc2.Flag("channel", "CoreOS release channel [ stable | beta | alpha ]").
-				Required().PlaceHolder("KATO_DEPLOY_EC2_CHANNEL").
+				PlaceHolder("KATO_DEPLOY_EC2_CHANNEL").
 				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_CHANNEL").
 				HintOptions("stable", "beta", "alpha").String()
 
@@ -232,28 +652,108 @@ var (
 				Default("auto").OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_ETCD_TOKEN").
 				Short('t').HintOptions("auto").String()
 
+	flDeployEc2EtcdDiscoveryURL = cmdDeployEc2.Flag("etcd-discovery-url", "Base URL of the etcd discovery service.").
+					Default(katool.DefaultDiscoveryURL).
+					OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_ETCD_DISCOVERY_URL").
+					String()
+
+	flDeployEc2EtcdTLS = cmdDeployEc2.Flag("etcd-tls", "Secure etcd with the cluster PKI.").
+				Default("false").OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_ETCD_TLS").
+				Bool()
+
+	flDeployEc2DNSProvider = cmdDeployEc2.Flag("dns-provider", "DNS provider [ ns1 | route53 | rfc2136 | none ]").
+				Default("ns1").OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_DNS_PROVIDER").
+				Enum(dns.Providers...)
+
 	flDeployEc2Ns1ApiKey = cmdDeployEc2.Flag("ns1-api-key", "NS1 private API key.").
-				Required().PlaceHolder("KATO_DEPLOY_EC2_NS1_API_KEY").
+				PlaceHolder("KATO_DEPLOY_EC2_NS1_API_KEY").
 				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_NS1_API_KEY").
 				String()
 
+	flDeployEc2Rfc2136Server = cmdDeployEc2.Flag("rfc2136-server", "RFC2136 DNS server as host[:port].").
+					PlaceHolder("KATO_DEPLOY_EC2_RFC2136_SERVER").
+					OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_RFC2136_SERVER").
+					String()
+
+	flDeployEc2Rfc2136TSIGKey = cmdDeployEc2.Flag("rfc2136-tsig-key", "RFC2136 TSIG key as [algorithm:]name:secret").
+					PlaceHolder("KATO_DEPLOY_EC2_RFC2136_TSIG_KEY").
+					OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_RFC2136_TSIG_KEY").
+					String()
+
+	flDeployEc2KatoctlURL = cmdDeployEc2.Flag("katoctl-url", "Where the hosts download katoctl from, this release by default.").
+				PlaceHolder("KATO_DEPLOY_EC2_KATOCTL_URL").
+				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_KATOCTL_URL").
+				String()
+
+	flDeployEc2KatoctlSHA256 = cmdDeployEc2.Flag("katoctl-sha256", "Checksum the hosts verify katoctl against.").
+					PlaceHolder("KATO_DEPLOY_EC2_KATOCTL_SHA256").
+					OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_KATOCTL_SHA256").
+					String()
+
 	flDeployEc2CaCert = cmdDeployEc2.Flag("ca-cert", "Path to CA certificate.").
 				PlaceHolder("KATO_DEPLOY_EC2_CA_CET").
 				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_CA_CET").
 				Short('c').String()
 
+	flDeployEc2VersionsFile = cmdDeployEc2.Flag("versions-file", "YAML file pinning the component images.").
+				PlaceHolder("KATO_DEPLOY_EC2_VERSIONS_FILE").
+				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_VERSIONS_FILE").
+				String()
+
+	flDeployEc2SecretsBackend = cmdDeployEc2.Flag("secrets-backend", "Seal the secrets with [ none | file | vault ]").
+					Default("none").OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_SECRETS_BACKEND").
+					Enum(secrets.Backends...)
+
+	flDeployEc2SecretsKeyFile = cmdDeployEc2.Flag("secrets-key-file", "Key of the file backend, derived from the cluster CA if missing.").
+					PlaceHolder("KATO_DEPLOY_EC2_SECRETS_KEY_FILE").
+					OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_SECRETS_KEY_FILE").
+					String()
+
+	flDeployEc2VaultAddr = cmdDeployEc2.Flag("vault-addr", "Vault server of the vault backend.").
+				PlaceHolder("VAULT_ADDR").
+				OverrideDefaultFromEnvar("VAULT_ADDR").
+				String()
+
+	flDeployEc2VaultToken = cmdDeployEc2.Flag("vault-token", "Vault token allowed to encrypt with the transit key.").
+				PlaceHolder("VAULT_TOKEN").
+				OverrideDefaultFromEnvar("VAULT_TOKEN").
+				String()
+
+	flDeployEc2VaultTransitKey = cmdDeployEc2.Flag("vault-transit-key", "Vault transit key.").
+					Default("kato").OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_VAULT_TRANSIT_KEY").
+					String()
+
+	flDeployEc2RolesFile = cmdDeployEc2.Flag("roles-file", "YAML file redefining the master, node and edge roles.").
+				PlaceHolder("KATO_DEPLOY_EC2_ROLES_FILE").
+				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_ROLES_FILE").
+				String()
+
+	flDeployEc2TemplatesDir = cmdDeployEc2.Flag("templates-dir", "Directory of <fragment>.tmpl files replacing the built-in ones.").
+				PlaceHolder("KATO_DEPLOY_EC2_TEMPLATES_DIR").
+				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_TEMPLATES_DIR").
+				String()
+
+	flDeployEc2ExtraFiles = cmdDeployEc2.Flag("extra-file", "Extra file to write as src:dest[:mode] (repeatable).").
+				PlaceHolder("SRC:DEST[:MODE]").Strings()
+
+	flDeployEc2ExtraUnits = cmdDeployEc2.Flag("extra-unit", "Extra systemd unit file to start (repeatable).").
+				PlaceHolder("PATH").Strings()
+
+	flDeployEc2SSHKeys = cmdDeployEc2.Flag("ssh-key", "SSH public key, or key file, authorized for core (repeatable).").
+				PlaceHolder("KEY").Strings()
+
 	flDeployEc2Region = cmdDeployEc2.Flag("region", "Amazon EC2 region.").
-				Required().PlaceHolder("KATO_DEPLOY_EC2_REGION").
+				PlaceHolder("KATO_DEPLOY_EC2_REGION").
 				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_REGION").
 				Short('r').String()
 
 	flDeployEc2Domain = cmdDeployEc2.Flag("domain", "Used to identify the VPC.").
-				Required().PlaceHolder("KATO_DEPLOY_EC2_DOMAIN").
+				PlaceHolder("KATO_DEPLOY_EC2_DOMAIN").
 				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_DOMAIN").
 				Short('d').String()
 
 	flDeployEc2KeyPair = cmdDeployEc2.Flag("key-pair", "EC2 key pair.").
-				Required().PlaceHolder("KATO_DEPLOY_EC2_KEY_PAIR").
+				PlaceHolder("KATO_DEPLOY_EC2_KEY_PAIR").
 				OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_KEY_PAIR").
 				Short('k').String()
 
@@ -269,6 +769,14 @@ var (
 					Default("10.0.0.0/24").OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_EXTERNAL_SUBNET_CIDR").
 					String()
 
+	flDeployEc2Plan = cmdDeployEc2.Flag("plan", "Print what would be created and exit.").
+			Default("false").OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_PLAN").
+			Bool()
+
+	flDeployEc2KeepOnFailure = cmdDeployEc2.Flag("keep-on-failure", "Do not roll back what a failed setup created.").
+					Default("false").OverrideDefaultFromEnvar("KATO_DEPLOY_EC2_KEEP_ON_FAILURE").
+					Bool()
+
 	flDeployFlannelNetwork = cmdDeploy.Flag("flannel-network", "Flannel entire overlay network.").
 				Default("10.128.0.0/21").OverrideDefaultFromEnvar("KATO_DEPLOY_FLANNEL_NETWORK").
 				String()
@@ -317,6 +825,42 @@ var (
 				Default("10.0.0.0/24").OverrideDefaultFromEnvar("KATO_SETUP_EC2_EXTERNAL_SUBNET_CIDR").
 				Short('e').String()
 
+	flSetupEc2DNSProvider = cmdSetupEc2.Flag("dns-provider", "DNS provider the IAM roles are set up for [ ns1 | route53 | rfc2136 | none ]").
+				Default("ns1").OverrideDefaultFromEnvar("KATO_SETUP_EC2_DNS_PROVIDER").
+				Enum(dns.Providers...)
+
+	flSetupEc2Plan = cmdSetupEc2.Flag("plan", "Print what would be created and exit.").
+			Default("false").OverrideDefaultFromEnvar("KATO_SETUP_EC2_PLAN").
+			Bool()
+
+	flSetupEc2KeepOnFailure = cmdSetupEc2.Flag("keep-on-failure", "Do not roll back what a failed setup created.").
+				Default("false").OverrideDefaultFromEnvar("KATO_SETUP_EC2_KEEP_ON_FAILURE").
+				Bool()
+
+	//-----------------------------
+	// destroy ec2: nested command
+	//-----------------------------
+
+	cmdDestroyEc2 = cmdDestroy.Command("ec2", "Destroy a cluster and all its EC2 components.")
+
+	flDestroyEc2Domain = cmdDestroyEc2.Flag("domain", "Used to identify the VPC.").
+				Required().PlaceHolder("KATO_DESTROY_EC2_DOMAIN").
+				OverrideDefaultFromEnvar("KATO_DESTROY_EC2_DOMAIN").
+				Short('d').String()
+
+	flDestroyEc2Region = cmdDestroyEc2.Flag("region", "EC2 region (defaults to the recorded one).").
+				PlaceHolder("KATO_DESTROY_EC2_REGION").
+				OverrideDefaultFromEnvar("KATO_DESTROY_EC2_REGION").
+				Short('r').String()
+
+	flDestroyEc2DryRun = cmdDestroyEc2.Flag("dry-run", "List what would be removed.").
+				Default("false").OverrideDefaultFromEnvar("KATO_DESTROY_EC2_DRY_RUN").
+				Bool()
+
+	flDestroyEc2DeleteIAM = cmdDestroyEc2.Flag("delete-iam", "Also delete the account-wide IAM roles and policy.").
+				Default("false").OverrideDefaultFromEnvar("KATO_DESTROY_EC2_DELETE_IAM").
+				Bool()
+
 	//-------------------------
 	// run ec2: nested command
 	//-------------------------
@@ -365,6 +909,11 @@ var (
 	flRunEc2IAMRole = cmdRunEc2.Flag("iam-role", "IAM role [ master | node | edge ]").
 			OverrideDefaultFromEnvar("KATO_RUN_EC2_IAM_ROLE").
 			HintOptions("master", "node", "edge").String()
+
+	flRunEc2Domain = cmdRunEc2.Flag("domain", "Record the instance in this cluster's state.").
+			PlaceHolder("KATO_RUN_EC2_DOMAIN").
+			OverrideDefaultFromEnvar("KATO_RUN_EC2_DOMAIN").
+			Short('d').String()
 )
 
 //----------------------------------------------------------------------------
@@ -387,6 +936,9 @@ func init() {
 
 func main() {
 
+	// The release pinned in the user-data:
+	app.Version(katool.Version)
+
 	// Sub-command selector:
 	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
 
@@ -396,15 +948,43 @@ func main() {
 
 	case cmdUdata.FullCommand():
 
+		// Encoding options:
+		var opts []udata.Option
+		if *flUdataGzipUdata {
+			opts = append(opts, udata.Gzip())
+		}
+		if *flUdataBase64Udata {
+			opts = append(opts, udata.Base64())
+		}
+		if *flUdataValidate {
+			opts = append(opts, udata.Validated())
+		}
+		if *flUdataFormat == "ignition" {
+			opts = append(opts, udata.Ignition(*flUdataPlatform))
+		}
+
 		udata := udata.Data{
 			MasterCount:         *flUdataMasterCount,
 			HostID:              *flUdataHostID,
 			Domain:              *flUdataDomain,
 			Role:                *flUdataRole,
+			DNSProvider:         *flUdataDNSProvider,
 			Ns1ApiKey:           *flUdataNs1Apikey,
+			Rfc2136Server:       *flUdataRfc2136Server,
+			Rfc2136TSIGKey:      *flUdataRfc2136TSIGKey,
+			KatoctlURL:          *flUdataKatoctlURL,
+			KatoctlSHA256:       *flUdataKatoctlSHA256,
 			CaCert:              *flUdataCaCert,
+			VersionsFile:        *flUdataVersionsFile,
+			RolesFile:           *flUdataRolesFile,
+			TemplatesDir:        *flUdataTemplatesDir,
+			ExtraFiles:          *flUdataExtraFiles,
+			ExtraUnits:          *flUdataExtraUnits,
+			SSHKeys:             *flUdataSSHKeys,
 			EtcdToken:           *flUdataEtcdToken,
-			GzipUdata:           *flUdataGzipUdata,
+			EtcdDiscoveryURL:    *flUdataEtcdDiscoveryURL,
+			EtcdTLS:             *flUdataEtcdTLS,
+			PKIDir:              pki.Dir(*flStateDir, *flUdataDomain),
 			FlannelNetwork:      *flUdataFlannelNetwork,
 			FlannelSubnetLen:    *flUdataFlannelSubnetLen,
 			FlannelSubnetMin:    *flUdataFlannelSubnetMin,
@@ -412,19 +992,214 @@ func main() {
 			FlannelBackend:      *flUdataFlannelBackend,
 			RexrayStorageDriver: *flUdataRexrayStorageDriver,
 			RexrayEndpointIP:    *flUdataRexrayEndpointIP,
+			Secrets: secrets.Config{
+				Backend:    *flUdataSecretsBackend,
+				KeyFile:    *flUdataSecretsKeyFile,
+				VaultAddr:  *flUdataVaultAddr,
+				VaultToken: *flUdataVaultToken,
+				VaultKey:   *flUdataVaultTransitKey,
+			},
+		}
+
+		err := udata.Render(os.Stdout, opts...)
+		checkError(err)
+
+	//--------------
+	// katoctl exec
+	//--------------
+
+	case cmdExec.FullCommand():
+
+		targets, err := execTargets()
+		checkError(err)
+
+		err = agent.Exec(targets, *arExecCommand, agent.SSH{
+			User:       *flExecUser,
+			Parallel:   *flExecParallel,
+			KnownHosts: *flExecKnownHosts,
+			Jump:       *flExecJump,
+		}, os.Stdout, os.Stderr)
+		checkError(err)
+
+	//---------------------------
+	// katoctl agent dns-publish
+	//---------------------------
+
+	case cmdAgentDNSPublish.FullCommand():
+
+		p, err := dns.New(dns.Config{
+			Provider:        *flAgentDNSPublishProvider,
+			Ns1APIKey:       *flAgentDNSPublishNs1ApiKey,
+			Ns1Endpoint:     *flAgentDNSPublishNs1Endpoint,
+			Route53Endpoint: *flAgentDNSPublishRoute53Endpoint,
+			Rfc2136Server:   *flAgentDNSPublishRfc2136Server,
+			Rfc2136TSIGKey:  *flAgentDNSPublishRfc2136TSIGKey,
+		})
+		checkError(err)
+
+		h, err := agent.LocalHost(agent.Host{
+			Domain:    *flAgentDomain,
+			PrivateIP: *flAgentPrivateIP,
+			PublicIP:  *flAgentDNSPublishPublicIP,
+		})
+		checkError(err)
+
+		err = agent.DNSPublish(p, h, *flAgentDNSPublishTTL)
+		checkError(err)
+
+	//-----------------------
+	// katoctl agent secrets
+	//-----------------------
+
+	case cmdAgentSecrets.FullCommand():
+
+		c := secrets.Config{
+			Backend:   *flAgentSecretsBackend,
+			KeyFile:   *flAgentSecretsKeyFile,
+			VaultAddr: *flAgentSecretsVaultAddr,
+			VaultKey:  *flAgentSecretsVaultTransitKey,
+		}
+
+		// The Vault token is never passed on the command line:
+		if c.Backend == "vault" {
+			c.VaultToken = os.Getenv("VAULT_TOKEN")
+			if c.VaultToken == "" {
+				token, err := ioutil.ReadFile(*flAgentSecretsVaultTokenFile)
+				if err != nil {
+					log.WithField("cmd", "agent:secrets").Error(err)
+				}
+				checkError(err)
+				c.VaultToken = strings.TrimSpace(string(token))
+			}
+		}
+
+		b, err := secrets.New(c)
+		checkError(err)
+
+		err = agent.OpenSecrets(b, *flAgentSecretsIn, *flAgentSecretsOut)
+		checkError(err)
+
+	//--------------------------
+	// katoctl agent hosts-sync
+	//--------------------------
+
+	case cmdAgentHostsSync.FullCommand():
+
+		h, err := agent.LocalHost(agent.Host{
+			Domain:    *flAgentDomain,
+			PrivateIP: *flAgentPrivateIP,
+		})
+		checkError(err)
+
+		err = agent.HostsSync(agentEtcd(), h, agent.Hosts{
+			File: *flAgentHostsSyncFile,
+			Base: *flAgentHostsSyncBase,
+			TTL:  *flAgentHostsSyncTTL,
+			Once: *flAgentHostsSyncOnce,
+		})
+		checkError(err)
+
+	//-------------------------
+	// katoctl agent docker-gc
+	//-------------------------
+
+	case cmdAgentDockerGC.FullCommand():
+
+		h, err := agent.LocalHost(agent.Host{
+			Domain:    *flAgentDomain,
+			PrivateIP: *flAgentPrivateIP,
+		})
+		checkError(err)
+
+		err = agent.DockerGC(agentEtcd(), h, agent.GC{
+			Socket:        *flAgentDockerGCSocket,
+			Keep:          *flAgentDockerGCKeep,
+			MinAge:        *flAgentDockerGCMinAge,
+			StateFile:     *flAgentDockerGCStateFile,
+			HighWatermark: *flAgentDockerGCHighWatermark,
+			DockerRoot:    *flAgentDockerGCDockerRoot,
+			DryRun:        *flAgentDockerGCDryRun,
+		})
+		checkError(err)
+
+	//------------------------
+	// katoctl agent exec-all
+	//------------------------
+
+	case cmdAgentExecAll.FullCommand():
+
+		err := agent.ExecAll(agentEtcd(), *arAgentExecAllCommand, os.Stdout, os.Stderr)
+		checkError(err)
+
+	//-------------------------
+	// katoctl discovery serve
+	//-------------------------
+
+	case cmdDiscoveryServe.FullCommand():
+
+		var store discovery.Store
+
+		switch *flDiscoveryServeBackend {
+		case "file":
+			if *flDiscoveryServeFile == "" {
+				*flDiscoveryServeFile = filepath.Join(*flStateDir, "discovery.json")
+			}
+			fs, err := discovery.NewFileStore(*flDiscoveryServeFile)
+			checkError(err)
+			store = fs
+		case "etcd":
+			store = discovery.NewEtcdStore(*flDiscoveryServeEtcd)
+		}
+
+		srv := &discovery.Server{URL: *flDiscoveryServeURL, Store: store}
+		err := srv.ListenAndServe(*flDiscoveryServeListen)
+		checkError(err)
+
+	//------------------
+	// katoctl pki init
+	//------------------
+
+	case cmdPkiInit.FullCommand():
+
+		dir := pki.Dir(*flStateDir, *flPkiDomain)
+
+		var err error
+		switch {
+		case *flPkiInitCaCert != "" && *flPkiInitCaKey != "":
+			_, err = pki.Import(dir, *flPkiInitCaCert, *flPkiInitCaKey)
+		case *flPkiInitCaCert != "" || *flPkiInitCaKey != "":
+			err = errors.New("--ca-cert and --ca-key go together")
+			log.WithField("cmd", "pki").Error(err)
+		default:
+			_, err = pki.Init(dir, *flPkiDomain)
 		}
 
-		err := udata.Render()
 		checkError(err)
 
+	//-------------------
+	// katoctl pki issue
+	//-------------------
+
+	case cmdPkiIssue.FullCommand():
+
+		ca, err := pki.Open(pki.Dir(*flStateDir, *flPkiDomain))
+		checkError(err)
+
+		for _, id := range *flPkiIssueHostIDs {
+			err = ca.Issue(*flPkiIssueRole+"-"+id,
+				pki.HostNames(*flPkiIssueRole, id, *flPkiDomain)...)
+			checkError(err)
+		}
+
 	//-----------------------
 	// katoctl deploy packet
 	//-----------------------
 
 	case cmdDeployPacket.FullCommand():
 
-		pkt := pkt.Data{}
-		err := pkt.Deploy()
+		c, src, err := deploySpec("packet", os.Args[1:])
+		checkError(err)
+		err = deployPkt(c, src)
 		checkError(err)
 
 	//----------------------
@@ -451,6 +1226,8 @@ func main() {
 			OS:        *flRunPktOS,
 			Facility:  *flRunPktFacility,
 			Billing:   *flRunPktBilling,
+			Domain:    *flRunPktDomain,
+			StateDir:  *flStateDir,
 		}
 
 		udata, err := readUdata()
@@ -464,31 +1241,33 @@ func main() {
 
 	case cmdDeployEc2.FullCommand():
 
-		ec2 := ec2.Data{
-			MasterCount:      *flDeployEc2MasterCount,
-			NodeCount:        *flDeployEc2NodeCount,
-			EdgeCount:        *flDeployEc2EdgeCount,
-			MasterType:       *flDeployEc2MasterType,
-			NodeType:         *flDeployEc2NodeType,
-			EdgeType:         *flDeployEc2EdgeType,
-			Channel:          *flDeployEc2Channel,
-			EtcdToken:        *flDeployEc2EtcdToken,
-			Ns1ApiKey:        *flDeployEc2Ns1ApiKey,
-			CaCert:           *flDeployEc2CaCert,
-			Domain:           *flDeployEc2Domain,
-			Region:           *flDeployEc2Region,
-			KeyPair:          *flDeployEc2KeyPair,
-			VpcCidrBlock:     *flDeployEc2VpcCidrBlock,
-			IntSubnetCidr:    *flDeployEc2IntSubnetCidr,
-			ExtSubnetCidr:    *flDeployEc2ExtSubnetCidr,
-			FlannelNetwork:   *flDeployFlannelNetwork,
-			FlannelSubnetLen: *flDeployFlannelSubnetLen,
-			FlannelSubnetMin: *flDeployFlannelSubnetMin,
-			FlannelSubnetMax: *flDeployFlannelSubnetMax,
-			FlannelBackend:   *flDeployFlannelBackend,
+		c, src, err := deploySpec("ec2", os.Args[1:])
+		checkError(err)
+		err = deployEC2(c, src)
+		checkError(err)
+
+	//---------------------
+	// katoctl deploy spec
+	//---------------------
+
+	case cmdDeploySpec.FullCommand():
+
+		if *flDeployFile == "" {
+			log.WithField("cmd", "deploy").
+				Error("A provider subcommand or a spec --file is required")
+			os.Exit(1)
+		}
+
+		c, src, err := deploySpec("", os.Args[1:])
+		checkError(err)
+
+		switch c.Provider {
+		case "ec2":
+			err = deployEC2(c, src)
+		case "packet":
+			err = deployPkt(c, src)
 		}
 
-		err := ec2.Deploy()
 		checkError(err)
 
 	//-------------------
@@ -500,14 +1279,35 @@ func main() {
 		ec2 := ec2.Data{
 			Domain:        *flSetupEc2Domain,
 			Region:        *flSetupEc2Region,
+			StateDir:      *flStateDir,
 			VpcCidrBlock:  *flSetupEc2VpcCidrBlock,
 			IntSubnetCidr: *flSetupEc2IntSubnetCidr,
 			ExtSubnetCidr: *flSetupEc2ExtSubnetCidr,
+			DNSProvider:   *flSetupEc2DNSProvider,
+			Plan:          *flSetupEc2Plan,
+			KeepOnFailure: *flSetupEc2KeepOnFailure,
 		}
 
 		err := ec2.Setup()
 		checkError(err)
 
+	//---------------------
+	// katoctl destroy ec2
+	//---------------------
+
+	case cmdDestroyEc2.FullCommand():
+
+		ec2 := ec2.Data{
+			Domain:    *flDestroyEc2Domain,
+			Region:    *flDestroyEc2Region,
+			StateDir:  *flStateDir,
+			DryRun:    *flDestroyEc2DryRun,
+			DeleteIAM: *flDestroyEc2DeleteIAM,
+		}
+
+		err := ec2.Destroy()
+		checkError(err)
+
 	//-----------------
 	// katoctl run ec2
 	//-----------------
@@ -524,6 +1324,8 @@ func main() {
 			Hostname:     *flRunEc2Hostname,
 			PublicIP:     *flRunEc2PublicIP,
 			IAMRole:      *flRunEc2IAMRole,
+			Domain:       *flRunEc2Domain,
+			StateDir:     *flStateDir,
 		}
 
 		udata, err := readUdata()
@@ -533,6 +1335,131 @@ func main() {
 	}
 }
 
+//--------------------------------------------------------------------------
+// func: deploySpec
+//--------------------------------------------------------------------------
+
+// deploySpec returns the cluster to deploy: the spec file, if any, overlaid
+// with the flags and KATO_* variables explicitly set by the user. Flag
+// defaults only fill in the values the spec leaves empty.
+func deploySpec(provider string, args []string) (*spec.Cluster, *flagSource, error) {
+
+	var err error
+	c := &spec.Cluster{Version: spec.Version}
+
+	// Collect the flags, parsed or not:
+	src, err := newFlagSource(args, cmdDeploy, cmdDeploySpec, cmdDeployEc2)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Read the spec file:
+	file := ""
+	src.str("file", &file)
+	if file != "" {
+		if c, err = spec.Load(file); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The provider subcommand must agree with the spec:
+	if provider != "" {
+		if c.Provider != "" && c.Provider != provider {
+			err = errors.New("spec provider is " + c.Provider + ", not " + provider)
+			log.WithField("cmd", "deploy").Error(err)
+			return nil, nil, err
+		}
+		c.Provider = provider
+	}
+
+	// Overlay the flags:
+	if c.Provider == "ec2" {
+		src.int("master-count", &c.Master.Count)
+		src.int("node-count", &c.Node.Count)
+		src.int("edge-count", &c.Edge.Count)
+		src.str("master-type", &c.Master.Type)
+		src.str("node-type", &c.Node.Type)
+		src.str("edge-type", &c.Edge.Type)
+		src.str("channel", &c.Channel)
+		src.str("etcd-token", &c.EtcdToken)
+		src.str("etcd-discovery-url", &c.EtcdDiscoveryURL)
+		src.bool("etcd-tls", &c.EtcdTLS)
+		src.str("dns-provider", &c.DNSProvider)
+		src.str("ns1-api-key", &c.Ns1ApiKey)
+		src.str("rfc2136-server", &c.Rfc2136Server)
+		src.str("rfc2136-tsig-key", &c.Rfc2136TSIGKey)
+		src.str("katoctl-url", &c.KatoctlURL)
+		src.str("katoctl-sha256", &c.KatoctlSHA256)
+		src.str("ca-cert", &c.CaCert)
+		src.str("versions-file", &c.VersionsFile)
+		src.str("roles-file", &c.RolesFile)
+		src.str("templates-dir", &c.TemplatesDir)
+		src.strings("extra-file", &c.ExtraFiles)
+		src.strings("extra-unit", &c.ExtraUnits)
+		src.strings("ssh-key", &c.SSHKeys)
+		src.str("secrets-backend", &c.SecretsBackend)
+		src.str("secrets-key-file", &c.SecretsKeyFile)
+		src.str("vault-addr", &c.VaultAddr)
+		src.str("vault-transit-key", &c.VaultTransitKey)
+		src.str("region", &c.Region)
+		src.str("domain", &c.Domain)
+		src.str("key-pair", &c.KeyPair)
+		src.str("vpc-cidr-block", &c.Network.VpcCidrBlock)
+		src.str("internal-subnet-cidr", &c.Network.IntSubnetCidr)
+		src.str("external-subnet-cidr", &c.Network.ExtSubnetCidr)
+		src.str("flannel-network", &c.Flannel.Network)
+		src.str("flannel-subnet-len", &c.Flannel.SubnetLen)
+		src.str("flannel-subnet-min", &c.Flannel.SubnetMin)
+		src.str("flannel-subnet-max", &c.Flannel.SubnetMax)
+		src.str("flannel-backend", &c.Flannel.Backend)
+	}
+
+	if src.err != nil {
+		return nil, nil, src.err
+	}
+
+	// Check for missing values:
+	if err = c.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return c, src, nil
+}
+
+//--------------------------------------------------------------------------
+// func: deployEC2
+//--------------------------------------------------------------------------
+
+func deployEC2(c *spec.Cluster, src *flagSource) error {
+	d := c.EC2()
+	d.StateDir = *flStateDir
+	src.str("vault-token", &d.VaultToken)
+	src.bool("plan", &d.Plan)
+	src.bool("keep-on-failure", &d.KeepOnFailure)
+	if src.err != nil {
+		return src.err
+	}
+	return d.Deploy()
+}
+
+//--------------------------------------------------------------------------
+// func: deployPkt
+//--------------------------------------------------------------------------
+
+func deployPkt(c *spec.Cluster, src *flagSource) error {
+
+	if _, ok := src.set["plan"]; ok {
+		err := errors.New("--plan is only supported on ec2")
+		log.WithField("cmd", "deploy").Error(err)
+		return err
+	}
+
+	d := c.Pkt()
+	d.Domain = c.Domain
+	d.StateDir = *flStateDir
+	return d.Deploy()
+}
+
 //--------------------------------------------------------------------------
 // func: readUdata
 //--------------------------------------------------------------------------
@@ -550,6 +1477,85 @@ func readUdata() ([]byte, error) {
 	return udata, err
 }
 
+//---------------------------------------------------------------------------
+// func: execTargets
+//---------------------------------------------------------------------------
+
+// execTargets finds the hosts for katoctl exec, either in fleet or in the
+// recorded state, where they are reached as <hostname>.<zone>.<domain>.
+func execTargets() ([]agent.Target, error) {
+
+	// Ask fleet:
+	if *flExecFrom == "fleet" {
+		return agent.FleetTargets(execEtcd(), *flExecRole)
+	}
+
+	// Read the state:
+	if *flExecDomain == "" {
+		err := errors.New("--domain is required with --from state")
+		log.WithField("cmd", "exec").Error(err)
+		return nil, err
+	}
+
+	st, err := state.Open(*flStateDir, *flExecDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	if *flExecKnownHosts == "" {
+		*flExecKnownHosts = filepath.Join(st.Dir(), "known_hosts")
+	}
+
+	var targets []agent.Target
+	for _, r := range st.Find(state.Instance, *flExecRole) {
+		name := strings.TrimSuffix(r.Name, "."+*flExecDomain)
+		targets = append(targets, agent.Target{
+			Name:    name,
+			Address: name + "." + *flExecZone + "." + *flExecDomain,
+		})
+	}
+
+	return targets, nil
+}
+
+//---------------------------------------------------------------------------
+// func: etcdFlags
+//---------------------------------------------------------------------------
+
+// etcdFlags adds the etcd client flags to cmd, defaulting to the ETCDCTL_*
+// variables set on the hosts, and returns the resulting etcd config.
+func etcdFlags(cmd *kingpin.CmdClause, note string) func() agent.Etcd {
+
+	endpoint := cmd.Flag("etcd-endpoint", "etcd client URL."+note).
+		Default("http://127.0.0.1:2379").
+		OverrideDefaultFromEnvar("ETCDCTL_ENDPOINT").
+		String()
+
+	caFile := cmd.Flag("etcd-ca-file", "CA used to verify etcd."+note).
+		PlaceHolder("ETCDCTL_CA_FILE").
+		OverrideDefaultFromEnvar("ETCDCTL_CA_FILE").
+		String()
+
+	certFile := cmd.Flag("etcd-cert-file", "Client certificate for etcd."+note).
+		PlaceHolder("ETCDCTL_CERT_FILE").
+		OverrideDefaultFromEnvar("ETCDCTL_CERT_FILE").
+		String()
+
+	keyFile := cmd.Flag("etcd-key-file", "Client key for etcd."+note).
+		PlaceHolder("ETCDCTL_KEY_FILE").
+		OverrideDefaultFromEnvar("ETCDCTL_KEY_FILE").
+		String()
+
+	return func() agent.Etcd {
+		return agent.Etcd{
+			Endpoint: *endpoint,
+			CAFile:   *caFile,
+			CertFile: *certFile,
+			KeyFile:  *keyFile,
+		}
+	}
+}
+
 //---------------------------------------------------------------------------
 // func: checkError
 //---------------------------------------------------------------------------
@@ -0,0 +1,339 @@
+package discovery
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// Error codes of the etcd v2 keys API understood by the etcd discovery
+// client:
+const (
+	codeKeyNotFound  = 100
+	codeNodeExist    = 105
+	codeInvalidField = 209
+)
+
+// DefaultPoll is how often waiting clients look for new members.
+const DefaultPoll = 500 * time.Millisecond
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// Errors returned by the stores:
+var (
+	ErrNotFound = errors.New("discovery token not found")
+	ErrExists   = errors.New("member already registered")
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Node is a key of the etcd v2 keys API as seen by the discovery clients.
+type Node struct {
+	Key           string  `json:"key"`
+	Value         string  `json:"value,omitempty"`
+	Dir           bool    `json:"dir,omitempty"`
+	Nodes         []*Node `json:"nodes,omitempty"`
+	ModifiedIndex uint64  `json:"modifiedIndex,omitempty"`
+	CreatedIndex  uint64  `json:"createdIndex,omitempty"`
+}
+
+// Store persists the discovery tokens and their registered members.
+type Store interface {
+	Create(token string, size int) error
+	Size(token string) (int, error)
+	Register(token, member, value string) (*Node, error)
+	Members(token string) ([]*Node, uint64, error)
+}
+
+// Server implements the etcd discovery protocol on top of a Store.
+type Server struct {
+	URL   string        // Public base URL. Defaults to http://<Host>.
+	Store Store         // Tokens and members.
+	Poll  time.Duration // Watch polling interval.
+}
+
+type response struct {
+	Action string `json:"action"`
+	Node   *Node  `json:"node"`
+}
+
+type apiError struct {
+	ErrorCode int    `json:"errorCode"`
+	Message   string `json:"message"`
+	Cause     string `json:"cause,omitempty"`
+	Index     uint64 `json:"index"`
+}
+
+//-----------------------------------------------------------------------------
+// func: ListenAndServe
+//-----------------------------------------------------------------------------
+
+// ListenAndServe serves the discovery protocol on addr.
+func (s *Server) ListenAndServe(addr string) error {
+
+	log.WithField("cmd", "discovery").Info("- Listening on " + addr)
+
+	if err := http.ListenAndServe(addr, s); err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return err
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: ServeHTTP
+//-----------------------------------------------------------------------------
+
+// ServeHTTP routes /new to the token creation and /<token>[/...] to the
+// subset of the etcd v2 keys API used by the discovery clients.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "new":
+		s.newToken(w, r)
+	case len(parts) == 1 && parts[0] != "" && r.Method == "GET":
+		s.getCluster(w, r, parts[0])
+	case len(parts) == 3 && parts[1] == "_config" && parts[2] == "size" && r.Method == "GET":
+		s.getSize(w, parts[0])
+	case len(parts) == 2 && r.Method == "PUT":
+		s.register(w, r, parts[0], parts[1])
+	case len(parts) == 2 && r.Method == "GET":
+		s.getMember(w, parts[0], parts[1])
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: newToken
+//-----------------------------------------------------------------------------
+
+func (s *Server) newToken(w http.ResponseWriter, r *http.Request) {
+
+	// The cluster size:
+	size := 3
+	if v := r.FormValue("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid size: "+v, http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	// A random token:
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		s.fail(w, err)
+		return
+	}
+	token := hex.EncodeToString(b)
+
+	if err := s.Store.Create(token, size); err != nil {
+		s.fail(w, err)
+		return
+	}
+
+	log.WithFields(log.Fields{"cmd": "discovery", "id": token}).
+		Info("- New token for " + strconv.Itoa(size) + " members")
+
+	// Return the token URL:
+	base := s.URL
+	if base == "" {
+		base = "http://" + r.Host
+	}
+
+	io.WriteString(w, strings.TrimRight(base, "/")+"/"+token)
+}
+
+//-----------------------------------------------------------------------------
+// func: getSize
+//-----------------------------------------------------------------------------
+
+func (s *Server) getSize(w http.ResponseWriter, token string) {
+
+	size, err := s.Store.Size(token)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+
+	s.reply(w, http.StatusOK, 0, &response{Action: "get", Node: &Node{
+		Key:   "/" + token + "/_config/size",
+		Value: strconv.Itoa(size),
+	}})
+}
+
+//-----------------------------------------------------------------------------
+// func: register
+//-----------------------------------------------------------------------------
+
+func (s *Server) register(w http.ResponseWriter, r *http.Request, token, member string) {
+
+	// Only creations are allowed:
+	if v := r.FormValue("prevExist"); v != "" && v != "false" {
+		s.apiError(w, http.StatusBadRequest, codeInvalidField, "prevExist must be false", member)
+		return
+	}
+
+	n, err := s.Store.Register(token, member, r.FormValue("value"))
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+
+	log.WithFields(log.Fields{"cmd": "discovery", "id": token}).
+		Info("- Registered member " + member)
+
+	s.reply(w, http.StatusCreated, n.CreatedIndex, &response{Action: "create", Node: n})
+}
+
+//-----------------------------------------------------------------------------
+// func: getMember
+//-----------------------------------------------------------------------------
+
+func (s *Server) getMember(w http.ResponseWriter, token, member string) {
+
+	nodes, index, err := s.Store.Members(token)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+
+	for _, n := range nodes {
+		if n.Key == "/"+token+"/"+member {
+			s.reply(w, http.StatusOK, index, &response{Action: "get", Node: n})
+			return
+		}
+	}
+
+	s.fail(w, ErrNotFound)
+}
+
+//-----------------------------------------------------------------------------
+// func: getCluster
+//-----------------------------------------------------------------------------
+
+// getCluster lists the registered members or, with wait=true, blocks until a
+// member registers at or after waitIndex.
+func (s *Server) getCluster(w http.ResponseWriter, r *http.Request, token string) {
+
+	nodes, index, err := s.Store.Members(token)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+
+	// Plain listing:
+	if r.FormValue("wait") != "true" {
+		s.reply(w, http.StatusOK, index, &response{Action: "get", Node: &Node{
+			Key: "/" + token, Dir: true, Nodes: nodes,
+		}})
+		return
+	}
+
+	// Watch:
+	var after uint64
+	if v := r.FormValue("waitIndex"); v != "" {
+		if after, err = strconv.ParseUint(v, 10, 64); err != nil {
+			s.apiError(w, http.StatusBadRequest, codeInvalidField, "invalid waitIndex", v)
+			return
+		}
+	}
+
+	poll := s.Poll
+	if poll == 0 {
+		poll = DefaultPoll
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+
+		for _, n := range nodes {
+			if n.CreatedIndex >= after {
+				s.reply(w, http.StatusOK, index, &response{Action: "create", Node: n})
+				return
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		if nodes, index, err = s.Store.Members(token); err != nil {
+			s.fail(w, err)
+			return
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: reply
+//-----------------------------------------------------------------------------
+
+func (s *Server) reply(w http.ResponseWriter, code int, index uint64, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", strconv.FormatUint(index, 10))
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: apiError
+//-----------------------------------------------------------------------------
+
+func (s *Server) apiError(w http.ResponseWriter, code, errorCode int, msg, cause string) {
+	s.reply(w, code, 0, &apiError{ErrorCode: errorCode, Message: msg, Cause: cause})
+}
+
+//-----------------------------------------------------------------------------
+// func: fail
+//-----------------------------------------------------------------------------
+
+// fail maps store errors to their etcd v2 keys API equivalents.
+func (s *Server) fail(w http.ResponseWriter, err error) {
+
+	switch err {
+	case ErrNotFound:
+		s.apiError(w, http.StatusNotFound, codeKeyNotFound, "Key not found", err.Error())
+	case ErrExists:
+		s.apiError(w, http.StatusPreconditionFailed, codeNodeExist, "Key already exists", err.Error())
+	default:
+		log.WithField("cmd", "discovery").Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,210 @@
+package discovery
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// etcdPrefix is where the tokens live in the backing etcd.
+const etcdPrefix = "/v2/keys/_kato/discovery/"
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// EtcdStore keeps the discovery tokens in an existing etcd cluster through
+// its v2 keys API.
+type EtcdStore struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+//-----------------------------------------------------------------------------
+// func: NewEtcdStore
+//-----------------------------------------------------------------------------
+
+// NewEtcdStore returns a store backed by the etcd listening on endpoint.
+func NewEtcdStore(endpoint string) *EtcdStore {
+	return &EtcdStore{
+		Endpoint: strings.TrimRight(endpoint, "/"),
+		Client:   http.DefaultClient,
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: Create
+//-----------------------------------------------------------------------------
+
+// Create adds a new token expecting size members.
+func (s *EtcdStore) Create(token string, size int) error {
+	_, _, err := s.put(token+"/_config/size", strconv.Itoa(size))
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: Size
+//-----------------------------------------------------------------------------
+
+// Size returns the number of members expected by token.
+func (s *EtcdStore) Size(token string) (int, error) {
+
+	n, _, err := s.get(token + "/_config/size")
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(n.Value)
+}
+
+//-----------------------------------------------------------------------------
+// func: Register
+//-----------------------------------------------------------------------------
+
+// Register adds a member to token. Members can only register once.
+func (s *EtcdStore) Register(token, member, value string) (*Node, error) {
+
+	// The token must exist:
+	if _, err := s.Size(token); err != nil {
+		return nil, err
+	}
+
+	n, _, err := s.put(token+"/"+member, value)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Key = "/" + token + "/" + member
+	return n, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Members
+//-----------------------------------------------------------------------------
+
+// Members returns the members of token in registration order and the current
+// etcd index.
+func (s *EtcdStore) Members(token string) ([]*Node, uint64, error) {
+
+	dir, index, err := s.get(token)
+	if err != nil {
+		return nil, index, err
+	}
+
+	var nodes []*Node
+	for _, n := range dir.Nodes {
+		name := n.Key[strings.LastIndex(n.Key, "/")+1:]
+		if name == "_config" {
+			continue
+		}
+		n.Key = "/" + token + "/" + name
+		nodes = append(nodes, n)
+	}
+
+	sort.Sort(byCreatedIndex(nodes))
+	return nodes, index, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: get
+//-----------------------------------------------------------------------------
+
+func (s *EtcdStore) get(key string) (*Node, uint64, error) {
+
+	res, err := s.Client.Get(s.Endpoint + etcdPrefix + key)
+	if err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return nil, 0, err
+	}
+
+	return decode(res)
+}
+
+//-----------------------------------------------------------------------------
+// func: put
+//-----------------------------------------------------------------------------
+
+func (s *EtcdStore) put(key, value string) (*Node, uint64, error) {
+
+	// Forge the request:
+	body := strings.NewReader(url.Values{"value": {value}}.Encode())
+	req, err := http.NewRequest("PUT", s.Endpoint+etcdPrefix+key+"?prevExist=false", body)
+	if err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Send the request:
+	res, err := s.Client.Do(req)
+	if err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return nil, 0, err
+	}
+
+	return decode(res)
+}
+
+//-----------------------------------------------------------------------------
+// func: decode
+//-----------------------------------------------------------------------------
+
+// decode reads an etcd v2 keys API response.
+func decode(res *http.Response) (*Node, uint64, error) {
+
+	defer res.Body.Close()
+	index, _ := strconv.ParseUint(res.Header.Get("X-Etcd-Index"), 10, 64)
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusNotFound:
+		return nil, index, ErrNotFound
+	case http.StatusPreconditionFailed:
+		return nil, index, ErrExists
+	default:
+		err := errors.New("etcd: " + res.Status)
+		log.WithField("cmd", "discovery").Error(err)
+		return nil, index, err
+	}
+
+	r := response{}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return nil, index, err
+	}
+
+	if r.Node == nil {
+		err := errors.New("etcd: empty response")
+		log.WithField("cmd", "discovery").Error(err)
+		return nil, index, err
+	}
+
+	return r.Node, index, nil
+}
+
+//-----------------------------------------------------------------------------
+// Sort by created index:
+//-----------------------------------------------------------------------------
+
+type byCreatedIndex []*Node
+
+func (s byCreatedIndex) Len() int           { return len(s) }
+func (s byCreatedIndex) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCreatedIndex) Less(i, j int) bool { return s[i].CreatedIndex < s[j].CreatedIndex }
@@ -0,0 +1,181 @@
+package discovery
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// FileStore keeps the discovery tokens in a single JSON file.
+type FileStore struct {
+	Index    uint64              `json:"index"`
+	Clusters map[string]*cluster `json:"clusters"`
+
+	path string
+	mu   sync.Mutex
+}
+
+type cluster struct {
+	Size    int     `json:"size"`
+	Members []*Node `json:"members"`
+}
+
+//-----------------------------------------------------------------------------
+// func: NewFileStore
+//-----------------------------------------------------------------------------
+
+// NewFileStore loads, or creates, the store kept in path.
+func NewFileStore(path string) (*FileStore, error) {
+
+	s := &FileStore{Clusters: map[string]*cluster{}, path: path}
+
+	// Read the file:
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return nil, err
+	}
+
+	// Decode it:
+	if err := json.Unmarshal(data, s); err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Create
+//-----------------------------------------------------------------------------
+
+// Create adds a new token expecting size members.
+func (s *FileStore) Create(token string, size int) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Index++
+	s.Clusters[token] = &cluster{Size: size}
+
+	return s.save()
+}
+
+//-----------------------------------------------------------------------------
+// func: Size
+//-----------------------------------------------------------------------------
+
+// Size returns the number of members expected by token.
+func (s *FileStore) Size(token string) (int, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.Clusters[token]
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	return c.Size, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Register
+//-----------------------------------------------------------------------------
+
+// Register adds a member to token. Members can only register once.
+func (s *FileStore) Register(token, member, value string) (*Node, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.Clusters[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	key := "/" + token + "/" + member
+	for _, n := range c.Members {
+		if n.Key == key {
+			return nil, ErrExists
+		}
+	}
+
+	s.Index++
+	n := &Node{Key: key, Value: value, CreatedIndex: s.Index, ModifiedIndex: s.Index}
+	c.Members = append(c.Members, n)
+
+	return n, s.save()
+}
+
+//-----------------------------------------------------------------------------
+// func: Members
+//-----------------------------------------------------------------------------
+
+// Members returns the members of token in registration order and the current
+// store index.
+func (s *FileStore) Members(token string) ([]*Node, uint64, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.Clusters[token]
+	if !ok {
+		return nil, s.Index, ErrNotFound
+	}
+
+	nodes := make([]*Node, len(c.Members))
+	copy(nodes, c.Members)
+
+	return nodes, s.Index, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: save
+//-----------------------------------------------------------------------------
+
+// save atomically replaces the file. The caller holds the lock.
+func (s *FileStore) save() error {
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return err
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		log.WithField("cmd", "discovery").Error(err)
+		return err
+	}
+
+	return nil
+}
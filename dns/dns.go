@@ -0,0 +1,115 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// DefaultTTL is the TTL of the published records.
+const DefaultTTL = 60
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// Providers lists the supported DNS providers.
+var Providers = []string{"ns1", "route53", "rfc2136", "none"}
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Record is an A record and the zone it belongs to.
+type Record struct {
+	Zone    string
+	Name    string
+	Answers []string
+	TTL     int
+}
+
+// Publisher creates or replaces records in a DNS provider.
+type Publisher interface {
+	Publish(r Record) error
+}
+
+// Config holds the settings of every provider. Endpoints are only set to
+// talk to a stand-in server.
+type Config struct {
+	Provider string
+
+	Ns1APIKey   string
+	Ns1Endpoint string
+
+	Route53Endpoint string
+
+	Rfc2136Server  string
+	Rfc2136TSIGKey string
+}
+
+type none struct{}
+
+//-----------------------------------------------------------------------------
+// func: New
+//-----------------------------------------------------------------------------
+
+// New returns the publisher of the configured provider.
+func New(c Config) (Publisher, error) {
+
+	var err error
+
+	switch c.Provider {
+	case "ns1":
+		if c.Ns1APIKey == "" {
+			err = errors.New("the ns1 provider needs an API key")
+			break
+		}
+		return newNs1(c.Ns1APIKey, c.Ns1Endpoint), nil
+	case "route53":
+		return newRoute53(c.Route53Endpoint), nil
+	case "rfc2136":
+		if c.Rfc2136Server == "" {
+			err = errors.New("the rfc2136 provider needs a server")
+			break
+		}
+		return newRfc2136(c.Rfc2136Server, c.Rfc2136TSIGKey)
+	case "none", "":
+		return none{}, nil
+	default:
+		err = errors.New("unknown DNS provider: " + c.Provider)
+	}
+
+	log.WithField("cmd", "dns").Error(err)
+	return nil, err
+}
+
+//-----------------------------------------------------------------------------
+// func: Publish
+//-----------------------------------------------------------------------------
+
+func (none) Publish(r Record) error {
+	log.WithFields(log.Fields{"cmd": "dns", "id": r.Name}).
+		Info("- Not publishing, no DNS provider")
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: fqdn
+//-----------------------------------------------------------------------------
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
@@ -0,0 +1,140 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// ns1Endpoint is the NS1 REST API.
+const ns1Endpoint = "https://api.nsone.net/v1"
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+type ns1 struct {
+	apiKey   string
+	endpoint string
+	client   *http.Client
+}
+
+type ns1Record struct {
+	Zone    string      `json:"zone"`
+	Domain  string      `json:"domain"`
+	Type    string      `json:"type"`
+	TTL     int         `json:"ttl,omitempty"`
+	Answers []ns1Answer `json:"answers"`
+}
+
+type ns1Answer struct {
+	Answer []string `json:"answer"`
+}
+
+//-----------------------------------------------------------------------------
+// func: newNs1
+//-----------------------------------------------------------------------------
+
+func newNs1(apiKey, endpoint string) *ns1 {
+
+	if endpoint == "" {
+		endpoint = ns1Endpoint
+	}
+
+	return &ns1{
+		apiKey:   apiKey,
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   http.DefaultClient,
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: Publish
+//-----------------------------------------------------------------------------
+
+// Publish creates the record with PUT, or updates it with POST if it already
+// exists.
+func (p *ns1) Publish(r Record) error {
+
+	url := p.endpoint + "/zones/" + r.Zone + "/" + r.Name + "/A"
+
+	// Does the record exist?
+	code, err := p.do("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	method := "POST"
+	if code == http.StatusNotFound {
+		method = "PUT"
+	}
+
+	// Forge the record:
+	rec := ns1Record{Zone: r.Zone, Domain: r.Name, Type: "A", TTL: r.TTL}
+	for _, a := range r.Answers {
+		rec.Answers = append(rec.Answers, ns1Answer{Answer: []string{a}})
+	}
+
+	body, err := json.Marshal(rec)
+	if err != nil {
+		log.WithField("cmd", "dns:ns1").Error(err)
+		return err
+	}
+
+	// Send it:
+	code, err = p.do(method, url, body)
+	if err != nil {
+		return err
+	}
+
+	if code != http.StatusOK {
+		err := errors.New("ns1: " + method + " " + r.Name + ": " + http.StatusText(code))
+		log.WithField("cmd", "dns:ns1").Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "dns:ns1", "id": r.Name}).
+		Info("- Published " + strings.Join(r.Answers, ","))
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: do
+//-----------------------------------------------------------------------------
+
+func (p *ns1) do(method, url string, body []byte) (int, error) {
+
+	// Forge the request:
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		log.WithField("cmd", "dns:ns1").Error(err)
+		return 0, err
+	}
+	req.Header.Set("X-NSONE-Key", p.apiKey)
+
+	// Send the request:
+	res, err := p.client.Do(req)
+	if err != nil {
+		log.WithField("cmd", "dns:ns1").Error(err)
+		return 0, err
+	}
+	res.Body.Close()
+
+	return res.StatusCode, nil
+}
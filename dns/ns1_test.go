@@ -0,0 +1,106 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// func: TestNs1Publish
+//-----------------------------------------------------------------------------
+
+// A missing record is created with PUT and an existing one updated with
+// POST, against a stand-in NS1 API.
+func TestNs1Publish(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	const path = "/v1/zones/int.example.com/master-1.int.example.com/A"
+
+	var mu sync.Mutex
+	var methods []string
+	records := map[string]ns1Record{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		defer mu.Unlock()
+		methods = append(methods, r.Method)
+
+		if r.Header.Get("X-NSONE-Key") != "ns1-test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != path {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, exists := records[r.URL.Path]
+
+		switch r.Method {
+		case "GET":
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case "PUT", "POST":
+			if exists != (r.Method == "POST") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			var rec ns1Record
+			if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			records[r.URL.Path] = rec
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	p := newNs1("ns1-test-key", srv.URL+"/v1/")
+
+	// Create, then update:
+	for i := 0; i < 2; i++ {
+		if err := p.Publish(testRecord); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := []string{"GET", "PUT", "GET", "POST"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("methods: got %v, want %v", methods, want)
+	}
+
+	want := ns1Record{
+		Zone: "int.example.com", Domain: "master-1.int.example.com", Type: "A", TTL: DefaultTTL,
+		Answers: []ns1Answer{{Answer: []string{"10.0.0.11"}}, {Answer: []string{"10.0.0.12"}}},
+	}
+
+	if got := records[path]; !reflect.DeepEqual(got, want) {
+		t.Errorf("record: got %+v, want %+v", got, want)
+	}
+
+	// A rejected key is an error:
+	if err := newNs1("wrong", srv.URL+"/v1").Publish(testRecord); err == nil {
+		t.Error("published with a rejected API key")
+	}
+}
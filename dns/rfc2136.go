@@ -0,0 +1,341 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// Wire values from RFC 1035, RFC 2136 and RFC 8945:
+const (
+	opUpdate   = 5
+	typeA      = 1
+	typeSOA    = 6
+	typeTSIG   = 250
+	classIN    = 1
+	classANY   = 255
+	tsigFudge  = 300
+	rfcTimeout = 5 * time.Second
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// tsigAlgorithms maps the nsupdate algorithm names to their wire names and
+// hashes.
+var tsigAlgorithms = map[string]struct {
+	name string
+	hash func() hash.Hash
+}{
+	"hmac-md5":    {"hmac-md5.sig-alg.reg.int.", md5.New},
+	"hmac-sha1":   {"hmac-sha1.", sha1.New},
+	"hmac-sha256": {"hmac-sha256.", sha256.New},
+	"hmac-sha512": {"hmac-sha512.", sha512.New},
+}
+
+// rcodes are the response codes an update can fail with.
+var rcodes = map[int]string{
+	1: "FORMERR", 2: "SERVFAIL", 3: "NXDOMAIN", 4: "NOTIMP", 5: "REFUSED",
+	6: "YXDOMAIN", 7: "YXRRSET", 8: "NXRRSET", 9: "NOTAUTH", 10: "NOTZONE",
+}
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+type rfc2136 struct {
+	server  string
+	keyName string
+	secret  []byte
+	alg     string
+}
+
+//-----------------------------------------------------------------------------
+// func: newRfc2136
+//-----------------------------------------------------------------------------
+
+// newRfc2136 takes the server as host[:port] and an optional TSIG key in the
+// nsupdate -y format: [algorithm:]name:base64-secret.
+func newRfc2136(server, tsigKey string) (*rfc2136, error) {
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	p := &rfc2136{server: server}
+
+	if tsigKey == "" {
+		return p, nil
+	}
+
+	// Parse the key:
+	parts := strings.Split(tsigKey, ":")
+	if len(parts) == 2 {
+		parts = append([]string{"hmac-sha256"}, parts...)
+	}
+
+	if len(parts) != 3 {
+		err := errors.New("rfc2136: TSIG key must be [algorithm:]name:secret")
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return nil, err
+	}
+
+	if _, ok := tsigAlgorithms[parts[0]]; !ok {
+		err := errors.New("rfc2136: unsupported TSIG algorithm: " + parts[0])
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return nil, err
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return nil, err
+	}
+
+	p.alg, p.keyName, p.secret = parts[0], fqdn(strings.ToLower(parts[1])), secret
+	return p, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Publish
+//-----------------------------------------------------------------------------
+
+// Publish replaces the A records of r.Name with a single dynamic update.
+func (p *rfc2136) Publish(r Record) error {
+
+	// Forge the update:
+	id := make([]byte, 2)
+	if _, err := rand.Read(id); err != nil {
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return err
+	}
+
+	msg, err := p.update(r, id, time.Now().Unix())
+	if err != nil {
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return err
+	}
+
+	// Send it over UDP:
+	conn, err := net.DialTimeout("udp", p.server, rfcTimeout)
+	if err != nil {
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(rfcTimeout))
+
+	if _, err := conn.Write(msg); err != nil {
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return err
+	}
+
+	// Read the response:
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return err
+	}
+
+	if n < 12 || binary.BigEndian.Uint16(buf) != binary.BigEndian.Uint16(msg) {
+		err := errors.New("rfc2136: unexpected response from " + p.server)
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return err
+	}
+
+	if rcode := int(buf[3] & 0x0f); rcode != 0 {
+		name, ok := rcodes[rcode]
+		if !ok {
+			name = "RCODE " + strconv.Itoa(rcode)
+		}
+		err := errors.New("rfc2136: update of " + r.Name + " failed: " + name)
+		log.WithField("cmd", "dns:rfc2136").Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "dns:rfc2136", "id": r.Name}).
+		Info("- Published " + strings.Join(r.Answers, ","))
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: update
+//-----------------------------------------------------------------------------
+
+// update encodes an UPDATE message deleting the A RRset of r.Name and adding
+// one A record per answer, signed with TSIG at time now if a key is set.
+func (p *rfc2136) update(r Record, id []byte, now int64) ([]byte, error) {
+
+	// Header: ID, opcode, ZOCOUNT, PRCOUNT, UPCOUNT, ADCOUNT.
+	msg := append([]byte{}, id...)
+	msg = appendUint16(msg, opUpdate<<11)
+	msg = appendUint16(msg, 1)
+	msg = appendUint16(msg, 0)
+	msg = appendUint16(msg, uint16(1+len(r.Answers)))
+	msg = appendUint16(msg, 0)
+
+	// Zone section:
+	var err error
+	if msg, err = appendName(msg, r.Zone); err != nil {
+		return nil, err
+	}
+	msg = appendUint16(msg, typeSOA)
+	msg = appendUint16(msg, classIN)
+
+	// Delete the RRset:
+	if msg, err = appendName(msg, r.Name); err != nil {
+		return nil, err
+	}
+	msg = appendUint16(msg, typeA)
+	msg = appendUint16(msg, classANY)
+	msg = appendUint32(msg, 0)
+	msg = appendUint16(msg, 0)
+
+	// Add the records:
+	for _, a := range r.Answers {
+		ip := net.ParseIP(a).To4()
+		if ip == nil {
+			return nil, errors.New("rfc2136: not an IPv4 address: " + a)
+		}
+		if msg, err = appendName(msg, r.Name); err != nil {
+			return nil, err
+		}
+		msg = appendUint16(msg, typeA)
+		msg = appendUint16(msg, classIN)
+		msg = appendUint32(msg, uint32(r.TTL))
+		msg = appendUint16(msg, 4)
+		msg = append(msg, ip...)
+	}
+
+	if p.keyName == "" {
+		return msg, nil
+	}
+
+	return p.sign(msg, now)
+}
+
+//-----------------------------------------------------------------------------
+// func: sign
+//-----------------------------------------------------------------------------
+
+// sign appends a TSIG record to msg as described in RFC 8945 section 4.
+func (p *rfc2136) sign(msg []byte, now int64) ([]byte, error) {
+
+	alg := tsigAlgorithms[p.alg]
+
+	keyName, err := appendName(nil, p.keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	algName, err := appendName(nil, alg.name)
+	if err != nil {
+		return nil, err
+	}
+
+	timeSigned := []byte{
+		byte(now >> 40), byte(now >> 32), byte(now >> 24),
+		byte(now >> 16), byte(now >> 8), byte(now),
+	}
+
+	// The digest covers the message and the TSIG variables:
+	vars := append([]byte{}, keyName...)
+	vars = appendUint16(vars, classANY)
+	vars = appendUint32(vars, 0)
+	vars = append(vars, algName...)
+	vars = append(vars, timeSigned...)
+	vars = appendUint16(vars, tsigFudge)
+	vars = appendUint16(vars, 0)
+	vars = appendUint16(vars, 0)
+
+	mac := hmac.New(alg.hash, p.secret)
+	mac.Write(msg)
+	mac.Write(vars)
+	sum := mac.Sum(nil)
+
+	// The TSIG RDATA:
+	rdata := append([]byte{}, algName...)
+	rdata = append(rdata, timeSigned...)
+	rdata = appendUint16(rdata, tsigFudge)
+	rdata = appendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0:2]...)
+	rdata = appendUint16(rdata, 0)
+	rdata = appendUint16(rdata, 0)
+
+	// The TSIG record:
+	signed := append([]byte{}, msg...)
+	signed = append(signed, keyName...)
+	signed = appendUint16(signed, typeTSIG)
+	signed = appendUint16(signed, classANY)
+	signed = appendUint32(signed, 0)
+	signed = appendUint16(signed, uint16(len(rdata)))
+	signed = append(signed, rdata...)
+
+	// One more additional record:
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(msg[10:])+1)
+
+	return signed, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: appendName
+//-----------------------------------------------------------------------------
+
+// appendName appends a domain name in uncompressed wire format.
+func appendName(b []byte, name string) ([]byte, error) {
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errors.New("invalid domain name: " + name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+
+	return append(b, 0), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: appendUint16
+//-----------------------------------------------------------------------------
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+//-----------------------------------------------------------------------------
+// func: appendUint32
+//-----------------------------------------------------------------------------
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,345 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// Run 'go test ./dns -update' to accept a change of the wire format.
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// testRecord is published by every test.
+var testRecord = Record{
+	Zone:    "int.example.com",
+	Name:    "master-1.int.example.com",
+	Answers: []string{"10.0.0.11", "10.0.0.12"},
+	TTL:     DefaultTTL,
+}
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// tsig is the TSIG record found at the end of a signed message.
+type tsig struct {
+	keyName []byte
+	algName []byte
+	time    []byte
+	fudge   []byte
+	mac     []byte
+}
+
+//-----------------------------------------------------------------------------
+// func: TestUpdateWire
+//-----------------------------------------------------------------------------
+
+// The UPDATE messages must match the packets in testdata byte for byte.
+func TestUpdateWire(t *testing.T) {
+
+	cases := []struct {
+		name string
+		key  string
+	}{
+		{name: "update-unsigned"},
+		{name: "update-hmac-sha256", key: "kato:c2VjcmV0"},
+		{name: "update-hmac-md5", key: "hmac-md5:Kato.Example:c2VjcmV0"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+
+			p, err := newRfc2136("127.0.0.1", c.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := p.update(testRecord, []byte{0x12, 0x34}, 1500000000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []byte(hex.Dump(msg))
+			golden := filepath.Join("testdata", c.name+".golden")
+
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("packet differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestUpdateBadAnswer
+//-----------------------------------------------------------------------------
+
+func TestUpdateBadAnswer(t *testing.T) {
+
+	p, _ := newRfc2136("127.0.0.1", "")
+	r := testRecord
+	r.Answers = []string{"fd00::1"}
+
+	if _, err := p.update(r, []byte{0, 1}, 0); err == nil {
+		t.Error("an IPv6 answer was encoded as an A record")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestRfc2136Publish
+//-----------------------------------------------------------------------------
+
+// Updates are sent to a stand-in server which checks the TSIG signature the
+// way a name server does.
+func TestRfc2136Publish(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	secret, _ := base64.StdEncoding.DecodeString("c2VjcmV0")
+	server := standInDNS(t, secret)
+
+	cases := []struct {
+		name string
+		key  string
+		fail string
+	}{
+		{name: "signed", key: "kato:c2VjcmV0"},
+		{name: "sha512", key: "hmac-sha512:kato:c2VjcmV0"},
+		{name: "wrong secret", key: "kato:b3RoZXI=", fail: "NOTAUTH"},
+		{name: "unsigned", fail: "REFUSED"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+
+			p, err := newRfc2136(server, c.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = p.Publish(testRecord)
+			switch {
+			case c.fail == "" && err != nil:
+				t.Error(err)
+			case c.fail != "" && (err == nil || !strings.Contains(err.Error(), c.fail)):
+				t.Errorf("got %v, want %s", err, c.fail)
+			}
+		})
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestNewRfc2136
+//-----------------------------------------------------------------------------
+
+func TestNewRfc2136(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	p, err := newRfc2136("ns1.example.com", "kato:c2VjcmV0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.server != "ns1.example.com:53" || p.alg != "hmac-sha256" || p.keyName != "kato." {
+		t.Errorf("unexpected defaults: %+v", p)
+	}
+
+	for _, key := range []string{"kato", "hmac-sha3:kato:c2VjcmV0", "kato:not base64"} {
+		if _, err := newRfc2136("ns1.example.com", key); err == nil {
+			t.Errorf("accepted the TSIG key %q", key)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: standInDNS
+//-----------------------------------------------------------------------------
+
+// standInDNS serves dynamic updates on a local UDP port. Unsigned updates are
+// refused and updates not signed with secret fail with NOTAUTH.
+func standInDNS(t *testing.T, secret []byte) string {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(answer(buf[:n], secret), addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+//-----------------------------------------------------------------------------
+// func: answer
+//-----------------------------------------------------------------------------
+
+// answer returns the response header to an UPDATE request.
+func answer(req, secret []byte) []byte {
+
+	res := append([]byte{}, req[:12]...)
+	res[2] |= 0x80
+	for i := 4; i < 12; i++ {
+		res[i] = 0
+	}
+
+	rcode := byte(0)
+	switch unsigned, sig, err := splitTSIG(req); {
+	case err != nil || req[2]>>3&0x0f != opUpdate:
+		rcode = 1 // FORMERR
+	case sig == nil:
+		rcode = 5 // REFUSED
+	case !hmac.Equal(sig.mac, expectedMAC(unsigned, sig, secret)):
+		rcode = 9 // NOTAUTH
+	}
+
+	res[3] = res[3]&0xf0 | rcode
+	return res
+}
+
+//-----------------------------------------------------------------------------
+// func: splitTSIG
+//-----------------------------------------------------------------------------
+
+// splitTSIG walks the sections of msg and, if its last record is a TSIG one,
+// returns the message as it was before signing and the TSIG fields.
+func splitTSIG(msg []byte) ([]byte, *tsig, error) {
+
+	if len(msg) < 12 {
+		return nil, nil, errors.New("short header")
+	}
+
+	// Skip the zone section:
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		if off = skipName(msg, off) + 4; off > len(msg) {
+			return nil, nil, errors.New("short zone")
+		}
+	}
+
+	records := 0
+	for _, c := range []int{6, 8, 10} {
+		records += int(binary.BigEndian.Uint16(msg[c:]))
+	}
+
+	// Walk the records, keeping the start of the last one:
+	last := off
+	for i := 0; i < records; i++ {
+		last = off
+		if off = skipName(msg, off) + 10; off > len(msg) {
+			return nil, nil, errors.New("short record")
+		}
+		off += int(binary.BigEndian.Uint16(msg[off-2:]))
+	}
+
+	if off != len(msg) {
+		return nil, nil, errors.New("trailing bytes")
+	}
+
+	// Not signed:
+	name := skipName(msg, last)
+	if binary.BigEndian.Uint16(msg[10:]) == 0 || binary.BigEndian.Uint16(msg[name:]) != typeTSIG {
+		return msg, nil, nil
+	}
+
+	// The TSIG RDATA:
+	sig := &tsig{keyName: msg[last:name]}
+	rdata := msg[name+10:]
+	alg := skipName(rdata, 0)
+	sig.algName, sig.time, sig.fudge = rdata[:alg], rdata[alg:alg+6], rdata[alg+6:alg+8]
+	size := int(binary.BigEndian.Uint16(rdata[alg+8:]))
+	sig.mac = rdata[alg+10 : alg+10+size]
+
+	// The message before signing:
+	unsigned := append([]byte{}, msg[:last]...)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(msg[10:])-1)
+
+	return unsigned, sig, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: skipName
+//-----------------------------------------------------------------------------
+
+// skipName returns the offset following the uncompressed name at off.
+func skipName(msg []byte, off int) int {
+	for off < len(msg) && msg[off] != 0 {
+		off += int(msg[off]) + 1
+	}
+	return off + 1
+}
+
+//-----------------------------------------------------------------------------
+// func: expectedMAC
+//-----------------------------------------------------------------------------
+
+// expectedMAC computes the MAC a name server expects for an unsigned message.
+func expectedMAC(unsigned []byte, sig *tsig, secret []byte) []byte {
+
+	for _, alg := range tsigAlgorithms {
+
+		name, _ := appendName(nil, alg.name)
+		if !bytes.Equal(name, sig.algName) {
+			continue
+		}
+
+		vars := append([]byte{}, sig.keyName...)
+		vars = append(vars, 0x00, 0xff, 0, 0, 0, 0)
+		vars = append(vars, sig.algName...)
+		vars = append(vars, sig.time...)
+		vars = append(vars, sig.fudge...)
+		vars = append(vars, 0, 0, 0, 0)
+
+		mac := hmac.New(alg.hash, secret)
+		mac.Write(unsigned)
+		mac.Write(vars)
+		return mac.Sum(nil)
+	}
+
+	return nil
+}
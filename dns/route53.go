@@ -0,0 +1,130 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"strings"
+	"sync"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+type r53 struct {
+	svc   *route53.Route53
+	mu    sync.Mutex
+	zones map[string]string
+}
+
+//-----------------------------------------------------------------------------
+// func: newRoute53
+//-----------------------------------------------------------------------------
+
+// newRoute53 uses the default AWS credentials chain, which on EC2 resolves
+// to the instance IAM role.
+func newRoute53(endpoint string) *r53 {
+
+	cfg := &aws.Config{Region: aws.String("us-east-1")}
+	if endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+	}
+
+	return &r53{
+		svc:   route53.New(session.New(cfg)),
+		zones: map[string]string{},
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: Publish
+//-----------------------------------------------------------------------------
+
+// Publish upserts the record in the hosted zone named after r.Zone.
+func (p *r53) Publish(r Record) error {
+
+	zoneID, err := p.zoneID(r.Zone)
+	if err != nil {
+		return err
+	}
+
+	// Forge the change:
+	var values []*route53.ResourceRecord
+	for _, a := range r.Answers {
+		values = append(values, &route53.ResourceRecord{Value: aws.String(a)})
+	}
+
+	params := &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Changes: []*route53.Change{{
+				Action: aws.String("UPSERT"),
+				ResourceRecordSet: &route53.ResourceRecordSet{
+					Name:            aws.String(fqdn(r.Name)),
+					Type:            aws.String("A"),
+					TTL:             aws.Int64(int64(r.TTL)),
+					ResourceRecords: values,
+				},
+			}},
+		},
+	}
+
+	// Send the change:
+	if _, err := p.svc.ChangeResourceRecordSets(params); err != nil {
+		log.WithField("cmd", "dns:route53").Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "dns:route53", "id": r.Name}).
+		Info("- Published " + strings.Join(r.Answers, ","))
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: zoneID
+//-----------------------------------------------------------------------------
+
+// zoneID finds, and caches, the ID of the hosted zone called name.
+func (p *r53) zoneID(name string) (string, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id, ok := p.zones[name]; ok {
+		return id, nil
+	}
+
+	// Send the request:
+	resp, err := p.svc.ListHostedZonesByName(&route53.ListHostedZonesByNameInput{
+		DNSName:  aws.String(fqdn(name)),
+		MaxItems: aws.String("1"),
+	})
+	if err != nil {
+		log.WithField("cmd", "dns:route53").Error(err)
+		return "", err
+	}
+
+	// The first zone is the closest match:
+	if len(resp.HostedZones) == 0 || *resp.HostedZones[0].Name != fqdn(name) {
+		err := errors.New("route53: no hosted zone " + name)
+		log.WithField("cmd", "dns:route53").Error(err)
+		return "", err
+	}
+
+	id := strings.TrimPrefix(*resp.HostedZones[0].Id, "/hostedzone/")
+	p.zones[name] = id
+
+	return id, nil
+}
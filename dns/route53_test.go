@@ -0,0 +1,133 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+const (
+	r53Zones = `<?xml version="1.0" encoding="UTF-8"?>
+<ListHostedZonesByNameResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+  <HostedZones>
+    <HostedZone>
+      <Id>/hostedzone/Z0123456789</Id>
+      <Name>int.example.com.</Name>
+      <CallerReference>kato</CallerReference>
+    </HostedZone>
+  </HostedZones>
+  <IsTruncated>false</IsTruncated>
+  <MaxItems>1</MaxItems>
+</ListHostedZonesByNameResponse>`
+
+	r53Change = `<?xml version="1.0" encoding="UTF-8"?>
+<ChangeResourceRecordSetsResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+  <ChangeInfo>
+    <Id>/change/C0123456789</Id>
+    <Status>PENDING</Status>
+    <SubmittedAt>2017-07-14T02:40:00Z</SubmittedAt>
+  </ChangeInfo>
+</ChangeResourceRecordSetsResponse>`
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// r53ChangeRequest is the part of a ChangeResourceRecordSets request checked
+// by the stand-in server.
+type r53ChangeRequest struct {
+	Action string   `xml:"ChangeBatch>Changes>Change>Action"`
+	Name   string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>Name"`
+	Type   string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>Type"`
+	TTL    int      `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>TTL"`
+	Values []string `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+}
+
+//-----------------------------------------------------------------------------
+// func: TestRoute53Publish
+//-----------------------------------------------------------------------------
+
+// Records are upserted in the hosted zone found by name, which is looked up
+// once, against a stand-in Route 53 API.
+func TestRoute53Publish(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var mu sync.Mutex
+	var lookups []string
+	var changes []r53ChangeRequest
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/2013-04-01/hostedzonesbyname":
+			lookups = append(lookups, r.URL.Query().Get("dnsname"))
+			w.Write([]byte(r53Zones))
+		case "/2013-04-01/hostedzone/Z0123456789/rrset/":
+			var c r53ChangeRequest
+			if err := xml.NewDecoder(r.Body).Decode(&c); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			changes = append(changes, c)
+			w.Write([]byte(r53Change))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	p := newRoute53(srv.URL)
+
+	for i := 0; i < 2; i++ {
+		if err := p.Publish(testRecord); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := []string{"int.example.com."}; !reflect.DeepEqual(lookups, want) {
+		t.Errorf("zone lookups: got %v, want %v", lookups, want)
+	}
+
+	want := r53ChangeRequest{
+		Action: "UPSERT", Name: "master-1.int.example.com.", Type: "A", TTL: DefaultTTL,
+		Values: []string{"10.0.0.11", "10.0.0.12"},
+	}
+
+	if len(changes) != 2 || !reflect.DeepEqual(changes[1], want) {
+		t.Errorf("changes: got %+v, want 2 x %+v", changes, want)
+	}
+
+	// The closest zone is not the one asked for:
+	r := testRecord
+	r.Zone = "ext.example.com"
+	if err := p.Publish(r); err == nil {
+		t.Error("published into the wrong hosted zone")
+	}
+}
@@ -7,6 +7,7 @@ package katool
 import (
 
 	// Stdlib:
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -15,6 +16,21 @@ import (
 	"strings"
 )
 
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// DefaultDiscoveryURL is the public etcd discovery service.
+const DefaultDiscoveryURL = "https://discovery.etcd.io"
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// Version is the katoctl release, set when building one with:
+// -ldflags "-X github.com/h0tbird/kato/katool.Version=v0.1.0"
+var Version = "dev"
+
 //-----------------------------------------------------------------------------
 // func: ExecutePipeline
 //-----------------------------------------------------------------------------
@@ -54,15 +70,27 @@ func ExecutePipeline(cmd1, cmd2 *exec.Cmd) error {
 // func: EtcdToken
 //-----------------------------------------------------------------------------
 
-// EtcdToken takes masterCount and returns a valid etcd bootstrap token:
-func EtcdToken(masterCount int) (string, error) {
+// EtcdToken takes masterCount and returns a valid etcd bootstrap token from
+// the discovery service at discoveryURL, or DefaultDiscoveryURL if empty:
+func EtcdToken(discoveryURL string, masterCount int) (string, error) {
+
+	if discoveryURL == "" {
+		discoveryURL = DefaultDiscoveryURL
+	}
 
 	// Request an etcd bootstrap token:
-	res, err := http.Get("https://discovery.etcd.io/new?size=" + strconv.Itoa(masterCount))
+	res, err := http.Get(strings.TrimRight(discoveryURL, "/") +
+		"/new?size=" + strconv.Itoa(masterCount))
 	if err != nil {
 		return "", err
 	}
 
+	// Check the status:
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		res.Body.Close()
+		return "", errors.New("etcd discovery: " + discoveryURL + ": " + res.Status)
+	}
+
 	// Retrieve the token URL:
 	tokenURL, err := ioutil.ReadAll(res.Body)
 	res.Body.Close()
@@ -71,6 +99,6 @@ func EtcdToken(masterCount int) (string, error) {
 	}
 
 	// Return the token ID:
-	slice := strings.Split(string(tokenURL), "/")
+	slice := strings.Split(strings.TrimSpace(string(tokenURL)), "/")
 	return slice[len(slice)-1], nil
 }
@@ -0,0 +1,414 @@
+package pki
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// CA is the file name, without extension, of the cluster CA.
+const CA = "ca"
+
+const (
+	keyBits      = 2048
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 5 * 365 * 24 * time.Hour
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Authority is a cluster CA able to issue host certificates.
+type Authority struct {
+	dir  string
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+//-----------------------------------------------------------------------------
+// func: Dir
+//-----------------------------------------------------------------------------
+
+// Dir returns the PKI directory of a cluster, next to its state.
+func Dir(stateDir, domain string) string {
+	return filepath.Join(stateDir, domain, "pki")
+}
+
+//-----------------------------------------------------------------------------
+// func: Paths
+//-----------------------------------------------------------------------------
+
+// Paths returns the certificate and key files of name in dir.
+func Paths(dir, name string) (crt, key string) {
+	return filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+}
+
+//-----------------------------------------------------------------------------
+// func: Exists
+//-----------------------------------------------------------------------------
+
+// Exists reports whether dir holds a cluster CA.
+func Exists(dir string) bool {
+	crt, _ := Paths(dir, CA)
+	_, err := os.Stat(crt)
+	return err == nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Init
+//-----------------------------------------------------------------------------
+
+// Init creates the CA of the cluster identified by domain in dir. An
+// existing CA is kept and returned.
+func Init(dir, domain string) (*Authority, error) {
+
+	// Reuse the existing CA:
+	if Exists(dir) {
+		log.WithField("cmd", "pki").Info("- Using existing CA in " + dir)
+		return Open(dir)
+	}
+
+	// Create the directory:
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	// Generate the key:
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	// Self-sign the certificate:
+	tmpl, err := template(domain+" CA", caValidity)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	if err := write(dir, CA, der, key); err != nil {
+		return nil, err
+	}
+
+	log.WithField("cmd", "pki").Info("- New CA created in " + dir)
+	return Open(dir)
+}
+
+//-----------------------------------------------------------------------------
+// func: Import
+//-----------------------------------------------------------------------------
+
+// Import copies an existing CA certificate and its PKCS#1 key into dir, to be
+// used instead of a generated one.
+func Import(dir, crtFile, keyFile string) (*Authority, error) {
+
+	// Refuse to replace the current CA:
+	if Exists(dir) {
+		err := errors.New("a CA already exists in " + dir)
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	// Load the supplied CA:
+	a, err := load(crtFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.cert.IsCA {
+		err := errors.New(crtFile + " is not a CA certificate")
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	// Store it:
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	if err := write(dir, CA, a.cert.Raw, a.key); err != nil {
+		return nil, err
+	}
+
+	log.WithField("cmd", "pki").Info("- CA imported into " + dir)
+	return Open(dir)
+}
+
+//-----------------------------------------------------------------------------
+// func: Open
+//-----------------------------------------------------------------------------
+
+// Open loads the CA kept in dir.
+func Open(dir string) (*Authority, error) {
+
+	crtFile, keyFile := Paths(dir, CA)
+
+	a, err := load(crtFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	a.dir = dir
+	return a, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: load
+//-----------------------------------------------------------------------------
+
+func load(crtFile, keyFile string) (*Authority, error) {
+
+	// Read the certificate:
+	cert, err := readCert(crtFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the key:
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err := errors.New("no PEM data in " + keyFile)
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	return &Authority{cert: cert, key: key}, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Issue
+//-----------------------------------------------------------------------------
+
+// Issue signs a new certificate for name, valid both as server and client,
+// with hosts as its DNS and IP subject alternative names. Any previous
+// certificate for name is replaced.
+func (a *Authority) Issue(name string, hosts ...string) error {
+
+	// Generate the key:
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return err
+	}
+
+	// Forge the certificate:
+	tmpl, err := template(name, certValidity)
+	if err != nil {
+		return err
+	}
+
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	// Sign it:
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return err
+	}
+
+	if err := write(a.dir, name, der, key); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "pki", "id": name}).Info("- New certificate issued")
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Ensure
+//-----------------------------------------------------------------------------
+
+// Ensure issues a certificate for name unless a valid one for the same hosts
+// already exists.
+func (a *Authority) Ensure(name string, hosts ...string) error {
+
+	crt, _ := Paths(a.dir, name)
+	if _, err := os.Stat(crt); err == nil {
+		if cert, err := readCert(crt); err == nil && time.Now().Before(cert.NotAfter) {
+			if sameHosts(cert, hosts) {
+				return nil
+			}
+			log.WithFields(log.Fields{"cmd": "pki", "id": name}).
+				Info("- Host names changed, re-issuing the certificate")
+		}
+	}
+
+	return a.Issue(name, hosts...)
+}
+
+//-----------------------------------------------------------------------------
+// func: sameHosts
+//-----------------------------------------------------------------------------
+
+// sameHosts tells whether the subject alternative names of cert are hosts.
+func sameHosts(cert *x509.Certificate, hosts []string) bool {
+
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	want := append([]string(nil), hosts...)
+	for i, h := range want {
+		if ip := net.ParseIP(h); ip != nil {
+			want[i] = ip.String()
+		}
+	}
+
+	if len(sans) != len(want) {
+		return false
+	}
+
+	sort.Strings(sans)
+	sort.Strings(want)
+	for i := range sans {
+		if sans[i] != want[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+//-----------------------------------------------------------------------------
+// func: HostNames
+//-----------------------------------------------------------------------------
+
+// HostNames returns the names a host certificate is valid for, including the
+// internal name etcd advertises.
+func HostNames(role, hostID, domain string) []string {
+	short := role + "-" + hostID
+	return []string{short + ".int." + domain, short + "." + domain, short, "localhost", "127.0.0.1"}
+}
+
+//-----------------------------------------------------------------------------
+// func: template
+//-----------------------------------------------------------------------------
+
+func template(cn string, validity time.Duration) (*x509.Certificate, error) {
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"kato"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: readCert
+//-----------------------------------------------------------------------------
+
+func readCert(path string) (*x509.Certificate, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err := errors.New("no PEM data in " + path)
+		log.WithField("cmd", "pki").Error(err)
+		return nil, err
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+//-----------------------------------------------------------------------------
+// func: write
+//-----------------------------------------------------------------------------
+
+// write stores a PEM encoded certificate and its private key.
+func write(dir, name string, der []byte, key *rsa.PrivateKey) error {
+
+	crt, keyFile := Paths(dir, name)
+
+	// The private key first, only readable by the owner:
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return err
+	}
+
+	// Then the certificate:
+	crtPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	if err := ioutil.WriteFile(crt, crtPEM, 0644); err != nil {
+		log.WithField("cmd", "pki").Error(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package pki
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// func: TestEnsure
+//-----------------------------------------------------------------------------
+
+// Host certificates must verify for the names etcd advertises, and follow
+// host name changes.
+func TestEnsure(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir, err := ioutil.TempDir("", "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := Init(dir, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	verify := func(name string) error {
+		crt, _ := Paths(dir, "master-1")
+		cert, err := readCert(crt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+		return err
+	}
+
+	// Issue once:
+	if err := ca.Ensure("master-1", HostNames("master", "1", "example.com")...); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"master-1.int.example.com", "master-1.example.com", "master-1", "127.0.0.1"} {
+		if err := verify(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// A new domain means new names:
+	if err := ca.Ensure("master-1", HostNames("master", "1", "example.org")...); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify("master-1.int.example.org"); err != nil {
+		t.Errorf("certificate not re-issued for new names: %v", err)
+	}
+}
@@ -0,0 +1,849 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/h0tbird/kato/state"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// inventory holds every resource that belongs to a cluster.
+type inventory struct {
+	instances        []string
+	natGateways      []string
+	allocations      []string
+	internetGateways []string
+	routeTables      []string
+	subnets          []string
+	securityGroups   []string
+}
+
+//-----------------------------------------------------------------------------
+// func: Destroy
+//-----------------------------------------------------------------------------
+
+// Destroy deletes, in dependency order, every resource of the cluster.
+func (d *Data) Destroy() error {
+
+	// Set current command:
+	d.command = "destroy"
+
+	// Load the cluster state:
+	if err := d.openState(); err != nil {
+		return err
+	}
+
+	// Fall back to the recorded region:
+	if d.Region == "" {
+		d.Region = d.state.Region
+	}
+
+	// Connect and authenticate to the API endpoints:
+	log.WithField("cmd", d.command+":ec2").
+		Info("- Connecting to region " + d.Region)
+	d.svcEC2 = ec2.New(session.New(&aws.Config{Region: aws.String(d.Region)}))
+	d.svcIAM = iam.New(session.New())
+
+	// Locate the VPC:
+	if err := d.findVpcByDomain(); err != nil {
+		return err
+	}
+
+	// Collect everything attached to it:
+	inv, err := d.discover()
+	if err != nil {
+		return err
+	}
+
+	// Terminate the instances:
+	if err := d.terminateInstances(inv.instances); err != nil {
+		return err
+	}
+
+	// Delete the NAT gateways:
+	if err := d.deleteNatGateways(inv.natGateways); err != nil {
+		return err
+	}
+
+	// Release the elastic IPs:
+	if err := d.releaseElasticIPs(inv.allocations); err != nil {
+		return err
+	}
+
+	// Detach and delete the internet gateways:
+	if err := d.deleteInternetGateways(inv.internetGateways); err != nil {
+		return err
+	}
+
+	// Delete the route tables:
+	if err := d.deleteRouteTables(inv.routeTables); err != nil {
+		return err
+	}
+
+	// Delete the subnets:
+	if err := d.deleteSubnets(inv.subnets); err != nil {
+		return err
+	}
+
+	// Delete the security groups:
+	if err := d.deleteSecurityGroups(inv.securityGroups); err != nil {
+		return err
+	}
+
+	// Delete the VPC:
+	if err := d.deleteVpc(); err != nil {
+		return err
+	}
+
+	// Delete the IAM profiles, roles and policy:
+	if d.DeleteIAM {
+		if err := d.deleteIAM(); err != nil {
+			return err
+		}
+	}
+
+	// Forget the cluster:
+	if !d.DryRun {
+		return d.state.Remove()
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: findVpcByDomain
+//-----------------------------------------------------------------------------
+
+func (d *Data) findVpcByDomain() error {
+
+	// Forge the description request:
+	params := &ec2.DescribeVpcsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:Name"),
+				Values: []*string{aws.String(d.Domain)},
+			},
+		},
+	}
+
+	// Send the description request:
+	resp, err := d.svcEC2.DescribeVpcs(params)
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	// Prefer the tagged VPC over the recorded one:
+	if len(resp.Vpcs) > 0 {
+		d.vpcID = *resp.Vpcs[0].VpcId
+	} else {
+		d.vpcID = d.state.Lookup("vpc", "")
+	}
+
+	if d.vpcID == "" {
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.Domain}).
+			Warn("- No VPC found for this domain")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: discover
+//-----------------------------------------------------------------------------
+
+func (d *Data) discover() (*inventory, error) {
+
+	inv := &inventory{}
+
+	// Recorded elastic IPs:
+	for _, r := range d.state.Find("elastic-ip", "") {
+		inv.allocations = appendUnique(inv.allocations, r.ID)
+	}
+
+	// Without a VPC only the recorded instances are left:
+	if d.vpcID == "" {
+		for _, r := range d.state.Find(state.Instance, "") {
+			inv.instances = appendUnique(inv.instances, r.ID)
+		}
+		return inv, nil
+	}
+
+	vpcFilter := []*ec2.Filter{
+		{
+			Name:   aws.String("vpc-id"),
+			Values: []*string{aws.String(d.vpcID)},
+		},
+	}
+
+	// Instances:
+	insts, err := d.svcEC2.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: append(vpcFilter, &ec2.Filter{
+			Name: aws.String("instance-state-name"),
+			Values: aws.StringSlice([]string{
+				"pending", "running", "stopping", "stopped"}),
+		}),
+	})
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return nil, err
+	}
+
+	for _, r := range insts.Reservations {
+		for _, i := range r.Instances {
+			inv.instances = appendUnique(inv.instances, *i.InstanceId)
+		}
+	}
+
+	// Elastic IPs associated to the instances:
+	if len(inv.instances) > 0 {
+		addrs, err := d.svcEC2.DescribeAddresses(&ec2.DescribeAddressesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("instance-id"),
+					Values: aws.StringSlice(inv.instances),
+				},
+			},
+		})
+		if err != nil {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return nil, err
+		}
+
+		for _, a := range addrs.Addresses {
+			inv.allocations = appendUnique(inv.allocations, *a.AllocationId)
+		}
+	}
+
+	// NAT gateways and their elastic IPs:
+	nats, err := d.svcEC2.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{
+		Filter: append(vpcFilter, &ec2.Filter{
+			Name:   aws.String("state"),
+			Values: aws.StringSlice([]string{"pending", "available"}),
+		}),
+	})
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return nil, err
+	}
+
+	for _, n := range nats.NatGateways {
+		inv.natGateways = append(inv.natGateways, *n.NatGatewayId)
+		for _, a := range n.NatGatewayAddresses {
+			inv.allocations = appendUnique(inv.allocations, *a.AllocationId)
+		}
+	}
+
+	// Internet gateways, attached or left detached by a failed setup:
+	for _, f := range d.internetGatewayFilters() {
+
+		igws, err := d.svcEC2.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{
+			Filters: []*ec2.Filter{f},
+		})
+		if err != nil {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return nil, err
+		}
+
+		for _, g := range igws.InternetGateways {
+			inv.internetGateways = appendUnique(inv.internetGateways, *g.InternetGatewayId)
+		}
+	}
+
+	// Route tables other than the main one:
+	rts, err := d.svcEC2.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		Filters: vpcFilter,
+	})
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return nil, err
+	}
+
+	for _, t := range rts.RouteTables {
+		if !isMainRouteTable(t) {
+			inv.routeTables = append(inv.routeTables, *t.RouteTableId)
+		}
+	}
+
+	// Subnets:
+	subs, err := d.svcEC2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		Filters: vpcFilter,
+	})
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return nil, err
+	}
+
+	for _, s := range subs.Subnets {
+		inv.subnets = append(inv.subnets, *s.SubnetId)
+	}
+
+	// Security groups other than the default one:
+	sgs, err := d.svcEC2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: vpcFilter,
+	})
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return nil, err
+	}
+
+	for _, g := range sgs.SecurityGroups {
+		if *g.GroupName != "default" {
+			inv.securityGroups = append(inv.securityGroups, *g.GroupId)
+		}
+	}
+
+	return inv, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: terminateInstances
+//-----------------------------------------------------------------------------
+
+func (d *Data) terminateInstances(ids []string) error {
+
+	if len(ids) == 0 || d.dryRun("instance", ids...) {
+		return nil
+	}
+
+	// Send the termination request:
+	if _, err := d.svcEC2.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: aws.StringSlice(ids),
+	}); err != nil && !isNotFound(err) {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	// Wait until the instances are gone:
+	log.WithField("cmd", d.command+":ec2").
+		Info("- Waiting until instances are terminated")
+	if err := d.svcEC2.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{
+		InstanceIds: aws.StringSlice(ids),
+	}); err != nil && !isNotFound(err) {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	return d.forget(state.Instance, ids...)
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteNatGateways
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteNatGateways(ids []string) error {
+
+	if len(ids) == 0 || d.dryRun("nat-gateway", ids...) {
+		return nil
+	}
+
+	// Send the deletion requests:
+	for _, id := range ids {
+		if _, err := d.svcEC2.DeleteNatGateway(&ec2.DeleteNatGatewayInput{
+			NatGatewayId: aws.String(id),
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+	}
+
+	// Wait until the NAT gateways are deleted:
+	log.WithField("cmd", d.command+":ec2").
+		Info("- Waiting until NAT gateways are deleted")
+	if err := d.svcEC2.WaitUntilNatGatewayDeleted(&ec2.DescribeNatGatewaysInput{
+		NatGatewayIds: aws.StringSlice(ids),
+	}); err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	return d.forget("nat-gateway", ids...)
+}
+
+//-----------------------------------------------------------------------------
+// func: releaseElasticIPs
+//-----------------------------------------------------------------------------
+
+func (d *Data) releaseElasticIPs(ids []string) error {
+
+	if len(ids) == 0 || d.dryRun("elastic-ip", ids...) {
+		return nil
+	}
+
+	// Send the release requests:
+	for _, id := range ids {
+		if _, err := d.svcEC2.ReleaseAddress(&ec2.ReleaseAddressInput{
+			AllocationId: aws.String(id),
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+	}
+
+	return d.forget("elastic-ip", ids...)
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteInternetGateways
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteInternetGateways(ids []string) error {
+
+	if len(ids) == 0 || d.dryRun("internet-gateway", ids...) {
+		return nil
+	}
+
+	for _, id := range ids {
+
+		// Send the detachment request:
+		if _, err := d.svcEC2.DetachInternetGateway(&ec2.DetachInternetGatewayInput{
+			InternetGatewayId: aws.String(id),
+			VpcId:             aws.String(d.vpcID),
+		}); err != nil && !isNotFound(err) && !isNotAttached(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+
+		// Send the deletion request:
+		if _, err := d.svcEC2.DeleteInternetGateway(&ec2.DeleteInternetGatewayInput{
+			InternetGatewayId: aws.String(id),
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+	}
+
+	return d.forget("internet-gateway", ids...)
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteRouteTables
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteRouteTables(ids []string) error {
+
+	if len(ids) == 0 || d.dryRun("route-table", ids...) {
+		return nil
+	}
+
+	// Describe the route table associations:
+	resp, err := d.svcEC2.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		RouteTableIds: aws.StringSlice(ids),
+	})
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	for _, t := range resp.RouteTables {
+
+		// Send the disassociation requests:
+		for _, a := range t.Associations {
+			if _, err := d.svcEC2.DisassociateRouteTable(&ec2.DisassociateRouteTableInput{
+				AssociationId: a.RouteTableAssociationId,
+			}); err != nil && !isNotFound(err) {
+				log.WithField("cmd", d.command+":ec2").Error(err)
+				return err
+			}
+		}
+
+		// Send the deletion request:
+		if _, err := d.svcEC2.DeleteRouteTable(&ec2.DeleteRouteTableInput{
+			RouteTableId: t.RouteTableId,
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+	}
+
+	return d.forget("route-table", ids...)
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteSubnets
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteSubnets(ids []string) error {
+
+	if len(ids) == 0 || d.dryRun("subnet", ids...) {
+		return nil
+	}
+
+	// Send the deletion requests:
+	for _, id := range ids {
+		if err := retryOnDependency(func() error {
+			_, err := d.svcEC2.DeleteSubnet(&ec2.DeleteSubnetInput{
+				SubnetId: aws.String(id),
+			})
+			return err
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+	}
+
+	return d.forget("subnet", ids...)
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteSecurityGroups
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteSecurityGroups(ids []string) error {
+
+	if len(ids) == 0 || d.dryRun("security-group", ids...) {
+		return nil
+	}
+
+	// The groups reference each other, drop all the rules first:
+	resp, err := d.svcEC2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: aws.StringSlice(ids),
+	})
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	for _, g := range resp.SecurityGroups {
+		if len(g.IpPermissions) == 0 {
+			continue
+		}
+		if _, err := d.svcEC2.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       g.GroupId,
+			IpPermissions: g.IpPermissions,
+		}); err != nil {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+	}
+
+	// Send the deletion requests:
+	for _, id := range ids {
+		if err := retryOnDependency(func() error {
+			_, err := d.svcEC2.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
+				GroupId: aws.String(id),
+			})
+			return err
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+	}
+
+	return d.forget("security-group", ids...)
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteVpc
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteVpc() error {
+
+	if d.vpcID == "" || d.dryRun("vpc", d.vpcID) {
+		return nil
+	}
+
+	// Send the deletion request:
+	if err := retryOnDependency(func() error {
+		_, err := d.svcEC2.DeleteVpc(&ec2.DeleteVpcInput{
+			VpcId: aws.String(d.vpcID),
+		})
+		return err
+	}); err != nil && !isNotFound(err) {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	return d.forget("vpc", d.vpcID)
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteIAM
+//-----------------------------------------------------------------------------
+
+// deleteIAM removes the instance profiles, roles and REX-Ray policy. These
+// are shared by every cluster in the account, so only --delete-iam does it.
+func (d *Data) deleteIAM() error {
+
+	roles := []string{"master", "node", "edge"}
+
+	d.dryRun("instance-profile", roles...)
+	d.dryRun("iam-role", roles...)
+	if d.dryRun("iam-policy", "REX-Ray") {
+		return nil
+	}
+
+	// Locate the REX-Ray policy:
+	if err := d.findRexrayPolicy(); err != nil {
+		return err
+	}
+
+	// Delete the instance profiles:
+	if err := d.deleteInstanceProfiles(roles); err != nil {
+		return err
+	}
+
+	// Delete the roles:
+	if err := d.deleteIAMRoles(roles); err != nil {
+		return err
+	}
+
+	// Delete the REX-Ray policy:
+	return d.deleteRexrayPolicy()
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteInstanceProfiles
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteInstanceProfiles(names []string) error {
+
+	for _, name := range names {
+
+		// Remove the role from the instance profile:
+		if _, err := d.svcIAM.RemoveRoleFromInstanceProfile(&iam.RemoveRoleFromInstanceProfileInput{
+			InstanceProfileName: aws.String(name),
+			RoleName:            aws.String(name),
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+
+		// Delete the instance profile:
+		if _, err := d.svcIAM.DeleteInstanceProfile(&iam.DeleteInstanceProfileInput{
+			InstanceProfileName: aws.String(name),
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": name}).
+			Info("- Instance profile deleted")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteIAMRoles
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteIAMRoles(names []string) error {
+
+	for _, name := range names {
+
+		// Remove the role from its instance profile:
+		if _, err := d.svcIAM.RemoveRoleFromInstanceProfile(&iam.RemoveRoleFromInstanceProfileInput{
+			InstanceProfileName: aws.String(name),
+			RoleName:            aws.String(name),
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+
+		// Detach the REX-Ray policy:
+		if name == "node" && d.rexrayPolicyARN != "" {
+			if _, err := d.svcIAM.DetachRolePolicy(&iam.DetachRolePolicyInput{
+				PolicyArn: aws.String(d.rexrayPolicyARN),
+				RoleName:  aws.String(name),
+			}); err != nil && !isNotFound(err) {
+				log.WithField("cmd", d.command+":ec2").Error(err)
+				return err
+			}
+		}
+
+		// Delete the Route 53 inline policy:
+		if _, err := d.svcIAM.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
+			PolicyName: aws.String(route53Policy),
+			RoleName:   aws.String(name),
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+
+		// Delete the role:
+		if _, err := d.svcIAM.DeleteRole(&iam.DeleteRoleInput{
+			RoleName: aws.String(name),
+		}); err != nil && !isNotFound(err) {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": name}).
+			Info("- IAM role deleted")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteRexrayPolicy
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteRexrayPolicy() error {
+
+	if d.rexrayPolicyARN == "" {
+		return nil
+	}
+
+	// Detach the policy from the node role:
+	if _, err := d.svcIAM.DetachRolePolicy(&iam.DetachRolePolicyInput{
+		PolicyArn: aws.String(d.rexrayPolicyARN),
+		RoleName:  aws.String("node"),
+	}); err != nil && !isNotFound(err) {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	// Send the deletion request:
+	if _, err := d.svcIAM.DeletePolicy(&iam.DeletePolicyInput{
+		PolicyArn: aws.String(d.rexrayPolicyARN),
+	}); err != nil && !isNotFound(err) {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.rexrayPolicyARN}).
+		Info("- REX-Ray policy deleted")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: findRexrayPolicy
+//-----------------------------------------------------------------------------
+
+func (d *Data) findRexrayPolicy() error {
+
+	// Store the policy ARN:
+	arn, err := d.lookupRexrayPolicy()
+	if err != nil {
+		return err
+	}
+
+	d.rexrayPolicyARN = arn
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: dryRun
+//-----------------------------------------------------------------------------
+
+// dryRun logs what is about to be deleted and reports whether the deletion
+// must be skipped.
+func (d *Data) dryRun(kind string, ids ...string) bool {
+
+	msg := "- Deleting " + kind
+	if d.DryRun {
+		msg = "- Would delete " + kind
+	}
+
+	for _, id := range ids {
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": id}).Info(msg)
+	}
+
+	return d.DryRun
+}
+
+//-----------------------------------------------------------------------------
+// func: forget
+//-----------------------------------------------------------------------------
+
+func (d *Data) forget(kind string, ids ...string) error {
+
+	for _, id := range ids {
+		if err := d.state.Forget(kind, id); err != nil {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: isMainRouteTable
+//-----------------------------------------------------------------------------
+
+func isMainRouteTable(t *ec2.RouteTable) bool {
+	for _, a := range t.Associations {
+		if a.Main != nil && *a.Main {
+			return true
+		}
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: isNotFound
+//-----------------------------------------------------------------------------
+
+func isNotFound(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return strings.HasSuffix(awsErr.Code(), ".NotFound") ||
+			awsErr.Code() == "NoSuchEntity"
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: isNotAttached
+//-----------------------------------------------------------------------------
+
+func isNotAttached(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == "Gateway.NotAttached"
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: retryOnDependency
+//-----------------------------------------------------------------------------
+
+// retryOnDependency retries fn while AWS reports that the resource is still
+// in use, which happens for a while after its dependents are deleted.
+func retryOnDependency(fn func() error) error {
+
+	var err error
+
+	for i := 0; i < 30; i++ {
+		err = fn()
+		if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "DependencyViolation" {
+			return err
+		}
+		time.Sleep(10 * time.Second)
+	}
+
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: appendUnique
+//-----------------------------------------------------------------------------
+
+func appendUnique(slice []string, s string) []string {
+	for _, v := range slice {
+		if v == s {
+			return slice
+		}
+	}
+	return append(slice, s)
+}
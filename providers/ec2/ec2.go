@@ -13,8 +13,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"strconv"
+	"strings"
 	"sync"
 
 	// Community:
@@ -25,8 +25,19 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/h0tbird/kato/katool"
+	"github.com/h0tbird/kato/pki"
+	"github.com/h0tbird/kato/secrets"
+	"github.com/h0tbird/kato/state"
+	"github.com/h0tbird/kato/udata"
 )
 
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// route53Policy is the inline role policy of the route53 DNS provider.
+const route53Policy = "Route53"
+
 //-----------------------------------------------------------------------------
 // Typedefs:
 //-----------------------------------------------------------------------------
@@ -38,52 +49,79 @@ type Data struct {
 	svcEC2 *ec2.EC2
 	svcIAM *iam.IAM
 
-	MasterCount       int    //  deploy:ec2 |           |       |
-	NodeCount         int    //  deploy:ec2 |           |       |
-	EdgeCount         int    //  deploy:ec2 |           |       |
-	MasterType        string //  deploy:ec2 |           |       |
-	NodeType          string //  deploy:ec2 |           |       |
-	EdgeType          string //  deploy:ec2 |           |       |
-	Channel           string //  deploy:ec2 |           |       |
-	EtcdToken         string //  deploy:ec2 |           | udata |
-	Ns1ApiKey         string //  deploy:ec2 |           | udata |
-	CaCert            string //  deploy:ec2 |           | udata |
-	FlannelNetwork    string //  deploy:ec2 |           | udata |
-	FlannelSubnetLen  string //  deploy:ec2 |           | udata |
-	FlannelSubnetMin  string //  deploy:ec2 |           | udata |
-	FlannelSubnetMax  string //  deploy:ec2 |           | udata |
-	FlannelBackend    string //  deploy:ec2 |           | udata |
-	Domain            string //  deploy:ec2 | setup:ec2 | udata |
-	Region            string //  deploy:ec2 | setup:ec2 |       | run:ec2
-	command           string //  deploy:ec2 | setup:ec2 |       | run:ec2
-	VpcCidrBlock      string //  deploy:ec2 | setup:ec2 |       |
-	IntSubnetCidr     string //  deploy:ec2 | setup:ec2 |       |
-	ExtSubnetCidr     string //  deploy:ec2 | setup:ec2 |       |
-	vpcID             string //             | setup:ec2 |       |
-	mainRouteTableID  string //             | setup:ec2 |       |
-	internetGatewayID string //             | setup:ec2 |       |
-	natGatewayID      string //             | setup:ec2 |       |
-	routeTableID      string //             | setup:ec2 |       |
-	masterRoleID      string //             | setup:ec2 |       |
-	nodeRoleID        string //             | setup:ec2 |       |
-	edgeRoleID        string //             | setup:ec2 |       |
-	rexrayPolicyARN   string //             | setup:ec2 |       |
-	masterSecGrp      string //             | setup:ec2 |       |
-	nodeSecGrp        string //             | setup:ec2 |       |
-	edgeSecGrp        string //             | setup:ec2 |       |
-	IntSubnetID       string //             | setup:ec2 |       |
-	ExtSubnetID       string //             | setup:ec2 |       |
-	allocationID      string //             | setup:ec2 |       | run:ec2
-	instanceID        string //             |           |       | run:ec2
-	SubnetID          string //             |           |       | run:ec2
-	SecGrpID          string //             |           |       | run:ec2
-	ImageID           string //             |           |       | run:ec2
-	KeyPair           string //             |           |       | run:ec2
-	InstanceType      string //             |           |       | run:ec2
-	Hostname          string //             |           |       | run:ec2
-	PublicIP          string //             |           |       | run:ec2
-	IAMRole           string //             |           |       | run:ec2
-	interfaceID       string //             |           |       | run:ec2
+	// Cluster state and setup journal:
+	state   *state.Cluster
+	journal *journal
+
+	MasterCount       int      //  deploy:ec2 |           |       |
+	NodeCount         int      //  deploy:ec2 |           |       |
+	EdgeCount         int      //  deploy:ec2 |           |       |
+	MasterType        string   //  deploy:ec2 |           |       |
+	NodeType          string   //  deploy:ec2 |           |       |
+	EdgeType          string   //  deploy:ec2 |           |       |
+	Channel           string   //  deploy:ec2 |           |       |
+	EtcdToken         string   //  deploy:ec2 |           | udata |
+	EtcdDiscoveryURL  string   //  deploy:ec2 |           | udata |
+	EtcdTLS           bool     //  deploy:ec2 |           | udata |
+	DNSProvider       string   //  deploy:ec2 | setup:ec2 | udata |
+	Ns1ApiKey         string   //  deploy:ec2 |           | udata |
+	Rfc2136Server     string   //  deploy:ec2 |           | udata |
+	Rfc2136TSIGKey    string   //  deploy:ec2 |           | udata |
+	KatoctlURL        string   //  deploy:ec2 |           | udata |
+	KatoctlSHA256     string   //  deploy:ec2 |           | udata |
+	CaCert            string   //  deploy:ec2 |           | udata |
+	VersionsFile      string   //  deploy:ec2 |           | udata |
+	RolesFile         string   //  deploy:ec2 |           | udata |
+	TemplatesDir      string   //  deploy:ec2 |           | udata |
+	SecretsBackend    string   //  deploy:ec2 |           | udata |
+	SecretsKeyFile    string   //  deploy:ec2 |           | udata |
+	VaultAddr         string   //  deploy:ec2 |           | udata |
+	VaultToken        string   //  deploy:ec2 |           | udata |
+	VaultTransitKey   string   //  deploy:ec2 |           | udata |
+	ExtraFiles        []string //  deploy:ec2 |           | udata |
+	ExtraUnits        []string //  deploy:ec2 |           | udata |
+	SSHKeys           []string //  deploy:ec2 |           | udata |
+	FlannelNetwork    string   //  deploy:ec2 |           | udata |
+	FlannelSubnetLen  string   //  deploy:ec2 |           | udata |
+	FlannelSubnetMin  string   //  deploy:ec2 |           | udata |
+	FlannelSubnetMax  string   //  deploy:ec2 |           | udata |
+	FlannelBackend    string   //  deploy:ec2 |           | udata |
+	Domain            string   //  deploy:ec2 | setup:ec2 | udata |         | destroy:ec2
+	Region            string   //  deploy:ec2 | setup:ec2 |       | run:ec2 | destroy:ec2
+	StateDir          string   //  deploy:ec2 | setup:ec2 |       | run:ec2 | destroy:ec2
+	DryRun            bool     //             |           |       |         | destroy:ec2
+	DeleteIAM         bool     //             |           |       |         | destroy:ec2
+	Plan              bool     //  deploy:ec2 | setup:ec2 |       |         |
+	KeepOnFailure     bool     //  deploy:ec2 | setup:ec2 |       |         |
+	command           string   //  deploy:ec2 | setup:ec2 |       | run:ec2
+	VpcCidrBlock      string   //  deploy:ec2 | setup:ec2 |       |
+	IntSubnetCidr     string   //  deploy:ec2 | setup:ec2 |       |
+	ExtSubnetCidr     string   //  deploy:ec2 | setup:ec2 |       |
+	vpcID             string   //             | setup:ec2 |       |
+	mainRouteTableID  string   //             | setup:ec2 |       |
+	internetGatewayID string   //             | setup:ec2 |       |
+	natGatewayID      string   //             | setup:ec2 |       |
+	routeTableID      string   //             | setup:ec2 |       |
+	masterRoleID      string   //             | setup:ec2 |       |
+	nodeRoleID        string   //             | setup:ec2 |       |
+	edgeRoleID        string   //             | setup:ec2 |       |
+	rexrayPolicyARN   string   //             | setup:ec2 |       |
+	masterSecGrp      string   //             | setup:ec2 |       |
+	nodeSecGrp        string   //             | setup:ec2 |       |
+	edgeSecGrp        string   //             | setup:ec2 |       |
+	IntSubnetID       string   //             | setup:ec2 |       |
+	ExtSubnetID       string   //             | setup:ec2 |       |
+	allocationID      string   //             | setup:ec2 |       | run:ec2
+	instanceID        string   //             |           |       | run:ec2
+	SubnetID          string   //             |           |       | run:ec2
+	SecGrpID          string   //             |           |       | run:ec2
+	ImageID           string   //             |           |       | run:ec2
+	KeyPair           string   //             |           |       | run:ec2
+	InstanceType      string   //             |           |       | run:ec2
+	Hostname          string   //             |           |       | run:ec2
+	PublicIP          string   //             |           |       | run:ec2
+	IAMRole           string   //             |           |       | run:ec2
+	interfaceID       string   //             |           |       | run:ec2
 }
 
 //-----------------------------------------------------------------------------
@@ -96,6 +134,11 @@ func (d *Data) Deploy() error {
 	// Set command to deploy:
 	d.command = "deploy"
 
+	// Print the plan and leave:
+	if d.Plan {
+		return d.plan(os.Stdout)
+	}
+
 	// Setup the EC2 environment:
 	if err := d.environmentSetup(); err != nil {
 		return err
@@ -111,18 +154,15 @@ func (d *Data) Deploy() error {
 		return err
 	}
 
-	// Setup a wait group:
-	var wg sync.WaitGroup
-	wg.Add(3)
+	// Create the cluster CA:
+	if d.EtcdTLS {
+		if _, err := pki.Init(pki.Dir(d.StateDir, d.Domain), d.Domain); err != nil {
+			return err
+		}
+	}
 
 	// Deploy all the nodes:
-	go d.deployMasterNodes(&wg)
-	go d.deployWorkerNodes(&wg)
-	go d.deployEdgeNodes(&wg)
-
-	// Wait and return:
-	wg.Wait()
-	return nil
+	return d.deployNodes()
 }
 
 //-----------------------------------------------------------------------------
@@ -138,6 +178,13 @@ func (d *Data) Run(udata []byte) error {
 	// Connect and authenticate to the API endpoint:
 	d.svcEC2 = ec2.New(session.New(&aws.Config{Region: aws.String(d.Region)}))
 
+	// Load the cluster state:
+	if d.Domain != "" {
+		if err := d.openState(); err != nil {
+			return err
+		}
+	}
+
 	// Run the EC2 instance:
 	if err := d.runInstance(udata); err != nil {
 		return err
@@ -169,88 +216,146 @@ func (d *Data) Setup() error {
 	// Set current command:
 	d.command = "setup"
 
+	// Print the plan and leave:
+	if d.Plan {
+		return d.plan(os.Stdout)
+	}
+
+	// Setup all the components:
+	if err := d.setup(); err != nil {
+		return err
+	}
+
+	// Dump context to stdout:
+	return d.exposeIdentifiers()
+}
+
+//-----------------------------------------------------------------------------
+// func: setup
+//-----------------------------------------------------------------------------
+
+func (d *Data) setup() error {
+
 	// Connect and authenticate to the API endpoints:
 	log.WithField("cmd", d.command+":ec2").
 		Info("- Connecting to region " + d.Region)
 	d.svcEC2 = ec2.New(session.New(&aws.Config{Region: aws.String(d.Region)}))
 	d.svcIAM = iam.New(session.New())
 
-	// Create the VPC:
-	if err := d.createVpc(); err != nil {
+	// Load the cluster state:
+	if err := d.openState(); err != nil {
 		return err
 	}
 
-	// Setup a wait group:
-	var wg sync.WaitGroup
-	wg.Add(3)
+	// Journal what gets created and trap Ctrl-C:
+	d.journal = &journal{}
+	defer d.journal.trap()()
 
-	// Setup VPC, IAM and EC2:
-	go d.setupVPCNetwork(&wg)
-	go d.setupIAMSecurity(&wg)
-	go d.setupEC2Firewall(&wg)
+	// Create or reuse all the components:
+	if err := d.setupComponents(); err != nil {
 
-	// Wait to proceed:
-	wg.Wait()
+		// Keep the pieces for inspection:
+		if d.KeepOnFailure {
+			log.WithField("cmd", d.command+":ec2").
+				Warn("Keeping the resources created by this run")
+			return err
+		}
+
+		// Or roll them back:
+		if rbErr := d.rollback(); rbErr != nil {
+			log.WithField("cmd", d.command+":ec2").
+				Error("Rollback failed, run katoctl destroy ec2 to clean up")
+		}
 
-	// Dump context to stdout:
-	if err := d.exposeIdentifiers(); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+//-----------------------------------------------------------------------------
+// func: setupComponents
+//-----------------------------------------------------------------------------
+
+func (d *Data) setupComponents() error {
+
+	// Create the VPC:
+	if err := d.createVpc(); err != nil {
+		return err
+	}
+
+	// Setup VPC, IAM and EC2 concurrently:
+	steps := []func() error{d.setupVPCNetwork, d.setupIAMSecurity, d.setupEC2Firewall}
+	errc := make(chan error, len(steps))
+
+	for _, step := range steps {
+		go func(step func() error) {
+			errc <- step()
+		}(step)
+	}
+
+	// Wait for all of them and keep the first error:
+	var err error
+	for range steps {
+		if e := <-errc; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
 //-----------------------------------------------------------------------------
 // func: environmentSetup
 //-----------------------------------------------------------------------------
 
 func (d *Data) environmentSetup() error {
 
-	// Forge the setup command:
 	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.Domain}).
 		Info("Setup the EC2 environment")
 
-	cmdSetup := exec.Command("katoctl", "setup", "ec2",
-		"--domain", d.Domain,
-		"--region", d.Region,
-		"--vpc-cidr-block", d.VpcCidrBlock,
-		"--internal-subnet-cidr", d.IntSubnetCidr,
-		"--external-subnet-cidr", d.ExtSubnetCidr)
-
-	// Execute the setup command:
-	cmdSetup.Stderr = os.Stderr
-	out, err := cmdSetup.Output()
-	if err != nil {
-		log.WithField("cmd", d.command+":ec2").Error(err)
-		return err
-	}
-
-	// Decode JSON data returned from katoctl-setup:
-	var dat map[string]interface{}
-	if err := json.Unmarshal(out, &dat); err != nil {
-		log.WithField("cmd", d.command+":ec2").Error(err)
+	// Setup the environment:
+	e := d.setupData()
+	if err := e.setup(); err != nil {
 		return err
 	}
 
 	// Store the values:
-	d.VpcCidrBlock = dat["VpcCidrBlock"].(string)
-	d.IntSubnetCidr = dat["IntSubnetCidr"].(string)
-	d.ExtSubnetCidr = dat["ExtSubnetCidr"].(string)
-	d.vpcID = dat["VpcID"].(string)
-	d.mainRouteTableID = dat["MainRouteTableID"].(string)
-	d.IntSubnetID = dat["InternalSubnetID"].(string)
-	d.ExtSubnetID = dat["ExternalSubnetID"].(string)
-	d.internetGatewayID = dat["InternetGatewayID"].(string)
-	d.allocationID = dat["AllocationID"].(string)
-	d.natGatewayID = dat["NatGatewayID"].(string)
-	d.routeTableID = dat["RouteTableID"].(string)
-	d.masterSecGrp = dat["MasterSecGrp"].(string)
-	d.nodeSecGrp = dat["NodeSecGrp"].(string)
-	d.edgeSecGrp = dat["EdgeSecGrp"].(string)
+	d.state = e.state
+	d.vpcID = e.vpcID
+	d.mainRouteTableID = e.mainRouteTableID
+	d.IntSubnetID = e.IntSubnetID
+	d.ExtSubnetID = e.ExtSubnetID
+	d.internetGatewayID = e.internetGatewayID
+	d.allocationID = e.allocationID
+	d.natGatewayID = e.natGatewayID
+	d.routeTableID = e.routeTableID
+	d.masterSecGrp = e.masterSecGrp
+	d.nodeSecGrp = e.nodeSecGrp
+	d.edgeSecGrp = e.edgeSecGrp
 
 	return nil
 }
 
+//-----------------------------------------------------------------------------
+// func: setupData
+//-----------------------------------------------------------------------------
+
+// setupData returns the setup:ec2 part of a deploy.
+func (d *Data) setupData() *Data {
+	return &Data{
+		command:       "setup",
+		Domain:        d.Domain,
+		Region:        d.Region,
+		StateDir:      d.StateDir,
+		DNSProvider:   d.DNSProvider,
+		VpcCidrBlock:  d.VpcCidrBlock,
+		IntSubnetCidr: d.IntSubnetCidr,
+		ExtSubnetCidr: d.ExtSubnetCidr,
+		KeepOnFailure: d.KeepOnFailure,
+	}
+}
+
 //-----------------------------------------------------------------------------
 // func: retrieveEtcdToken
 //-----------------------------------------------------------------------------
@@ -260,7 +365,7 @@ func (d *Data) retrieveEtcdToken() error {
 	var err error
 
 	if d.EtcdToken == "auto" {
-		if d.EtcdToken, err = katool.EtcdToken(d.MasterCount); err != nil {
+		if d.EtcdToken, err = katool.EtcdToken(d.EtcdDiscoveryURL, d.MasterCount); err != nil {
 			log.WithField("cmd", d.command+":ec2").Error(err)
 			return err
 		}
@@ -316,179 +421,178 @@ func (d *Data) retrieveCoreosAmiID() error {
 }
 
 //-----------------------------------------------------------------------------
-// func: deployMasterNodes
+// func: roles
 //-----------------------------------------------------------------------------
 
-func (d *Data) deployMasterNodes(wg *sync.WaitGroup) {
+// roleSpec describes how the instances of a role are launched.
+type roleSpec struct {
+	name, instanceType, subnetID, subnet, secGrpID, publicIP string
+	count                                                    int
+}
+
+func (d *Data) roles() []roleSpec {
+	return []roleSpec{
+		{"master", d.MasterType, d.IntSubnetID, "internal", d.masterSecGrp, "false", d.MasterCount},
+		{"node", d.NodeType, d.ExtSubnetID, "external", d.nodeSecGrp, "true", d.NodeCount},
+		{"edge", d.EdgeType, d.ExtSubnetID, "external", d.edgeSecGrp, "true", d.EdgeCount},
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: deployNodes
+//-----------------------------------------------------------------------------
 
-	// Decrement:
-	defer wg.Done()
-	var wgInt sync.WaitGroup
+func (d *Data) deployNodes() error {
 
-	log.WithField("cmd", d.command+":ec2").
-		Info("Deploying " + strconv.Itoa(d.MasterCount) + " master nodes")
-
-	for i := 1; i <= d.MasterCount; i++ {
-
-		// Increment:
-		wgInt.Add(1)
-
-		go func(id int) {
-
-			// Decrement:
-			defer wgInt.Done()
-
-			// Forge the udata command:
-			cmdUdata := exec.Command("katoctl", "udata",
-				"--role", "master",
-				"--master-count", strconv.Itoa(d.MasterCount),
-				"--hostid", strconv.Itoa(id),
-				"--domain", d.Domain,
-				"--ns1-api-key", d.Ns1ApiKey,
-				"--ca-cert", d.CaCert,
-				"--etcd-token", d.EtcdToken,
-				"--gzip-udata")
-
-			// Forge the run command:
-			cmdRun := exec.Command("katoctl", "run", "ec2",
-				"--hostname", "master-"+strconv.Itoa(id)+"."+d.Domain,
-				"--region", d.Region,
-				"--image-id", d.ImageID,
-				"--instance-type", d.MasterType,
-				"--key-pair", d.KeyPair,
-				"--subnet-id", d.IntSubnetID,
-				"--security-group-id", d.masterSecGrp,
-				"--iam-role", "master")
-
-			// Execute the pipeline:
-			if err := katool.ExecutePipeline(cmdUdata, cmdRun); err != nil {
-				log.WithField("cmd", d.command+":ec2").Error(err)
-			}
-		}(i)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var total, failed int
+
+	for _, r := range d.roles() {
+
+		log.WithField("cmd", d.command+":ec2").
+			Info("Deploying " + strconv.Itoa(r.count) + " " + r.name + " nodes")
+
+		for i := 1; i <= r.count; i++ {
+
+			// Increment:
+			wg.Add(1)
+			total++
+
+			go func(r roleSpec, id int) {
+
+				// Decrement:
+				defer wg.Done()
+
+				if err := d.deployNode(r, id); err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}(r, i)
+		}
 	}
 
 	// Wait:
-	wgInt.Wait()
+	wg.Wait()
+
+	if failed > 0 {
+		err := fmt.Errorf("%d of %d instances failed to deploy", failed, total)
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
-// func: deployWorkerNodes
+// func: deployNode
 //-----------------------------------------------------------------------------
 
-func (d *Data) deployWorkerNodes(wg *sync.WaitGroup) {
+func (d *Data) deployNode(r roleSpec, id int) error {
 
-	// Decrement:
-	defer wg.Done()
-	var wgInt sync.WaitGroup
+	hostname := r.name + "-" + strconv.Itoa(id) + "." + d.Domain
 
-	log.WithField("cmd", d.command+":ec2").
-		Info("Deploying " + strconv.Itoa(d.NodeCount) + " worker nodes")
-
-	for i := 1; i <= d.NodeCount; i++ {
-
-		// Increment:
-		wgInt.Add(1)
-
-		go func(id int) {
-
-			// Decrement:
-			defer wgInt.Done()
-
-			// Forge the udata command:
-			cmdUdata := exec.Command("katoctl", "udata",
-				"--role", "node",
-				"--master-count", strconv.Itoa(d.MasterCount),
-				"--hostid", strconv.Itoa(id),
-				"--domain", d.Domain,
-				"--ns1-api-key", d.Ns1ApiKey,
-				"--ca-cert", d.CaCert,
-				"--etcd-token", d.EtcdToken,
-				"--gzip-udata",
-				"--flannel-network", d.FlannelNetwork,
-				"--flannel-subnet-len", d.FlannelSubnetLen,
-				"--flannel-subnet-min", d.FlannelSubnetMin,
-				"--flannel-subnet-max", d.FlannelSubnetMax,
-				"--flannel-backend", d.FlannelBackend,
-				"--rexray-storage-driver", "ec2")
-
-			// Forge the run command:
-			cmdRun := exec.Command("katoctl", "run", "ec2",
-				"--hostname", "node-"+strconv.Itoa(id)+"."+d.Domain,
-				"--region", d.Region,
-				"--image-id", d.ImageID,
-				"--instance-type", d.NodeType,
-				"--key-pair", d.KeyPair,
-				"--subnet-id", d.ExtSubnetID,
-				"--security-group-id", d.nodeSecGrp,
-				"--iam-role", "node",
-				"--public-ip", "true")
-
-			// Execute the pipeline:
-			if err := katool.ExecutePipeline(cmdUdata, cmdRun); err != nil {
-				log.WithField("cmd", d.command+":ec2").Error(err)
-			}
-		}(i)
+	// Issue the host certificate:
+	if err := d.hostCert(r.name, id); err != nil {
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": hostname}).Error(err)
+		return err
 	}
 
-	// Wait:
-	wgInt.Wait()
+	// Render the user-data:
+	userData, err := d.udataFor(r.name, id).Bytes(udata.Validated(), udata.Gzip())
+	if err != nil {
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": hostname}).Error(err)
+		return err
+	}
+
+	// Run the instance:
+	i := &Data{
+		Domain:       d.Domain,
+		StateDir:     d.StateDir,
+		Hostname:     hostname,
+		Region:       d.Region,
+		ImageID:      d.ImageID,
+		InstanceType: r.instanceType,
+		KeyPair:      d.KeyPair,
+		SubnetID:     r.subnetID,
+		SecGrpID:     r.secGrpID,
+		IAMRole:      r.name,
+		PublicIP:     r.publicIP,
+	}
+
+	return i.Run(userData)
 }
 
 //-----------------------------------------------------------------------------
-// func: deployEdgeNodes
+// func: hostCert
 //-----------------------------------------------------------------------------
 
-func (d *Data) deployEdgeNodes(wg *sync.WaitGroup) {
+// hostCert issues the certificate of a host when the cluster has a CA.
+func (d *Data) hostCert(role string, id int) error {
 
-	// Decrement:
-	defer wg.Done()
-	var wgInt sync.WaitGroup
+	dir := pki.Dir(d.StateDir, d.Domain)
+	if !pki.Exists(dir) {
+		return nil
+	}
 
-	log.WithField("cmd", d.command+":ec2").
-		Info("Deploying " + strconv.Itoa(d.EdgeCount) + " edge nodes")
-
-	for i := 1; i <= d.EdgeCount; i++ {
-
-		// Increment:
-		wgInt.Add(1)
-
-		go func(id int) {
-
-			// Decrement:
-			defer wgInt.Done()
-
-			// Forge the udata command:
-			cmdUdata := exec.Command("katoctl", "udata",
-				"--role", "edge",
-				"--master-count", strconv.Itoa(d.MasterCount),
-				"--hostid", strconv.Itoa(id),
-				"--domain", d.Domain,
-				"--ns1-api-key", d.Ns1ApiKey,
-				"--ca-cert", d.CaCert,
-				"--etcd-token", d.EtcdToken,
-				"--gzip-udata")
-
-			// Forge the run command:
-			cmdRun := exec.Command("katoctl", "run", "ec2",
-				"--hostname", "edge-"+strconv.Itoa(id)+"."+d.Domain,
-				"--region", d.Region,
-				"--image-id", d.ImageID,
-				"--instance-type", d.EdgeType,
-				"--key-pair", d.KeyPair,
-				"--subnet-id", d.ExtSubnetID,
-				"--security-group-id", d.edgeSecGrp,
-				"--iam-role", "edge",
-				"--public-ip", "true")
-
-			// Execute the pipeline:
-			if err := katool.ExecutePipeline(cmdUdata, cmdRun); err != nil {
-				log.WithField("cmd", d.command+":ec2").Error(err)
-			}
-		}(i)
+	ca, err := pki.Open(dir)
+	if err != nil {
+		return err
 	}
 
-	// Wait:
-	wgInt.Wait()
+	hostID := strconv.Itoa(id)
+	return ca.Ensure(role+"-"+hostID, pki.HostNames(role, hostID, d.Domain)...)
+}
+
+//-----------------------------------------------------------------------------
+// func: udataFor
+//-----------------------------------------------------------------------------
+
+func (d *Data) udataFor(role string, id int) *udata.Data {
+
+	u := &udata.Data{
+		Role:             role,
+		MasterCount:      d.MasterCount,
+		HostID:           strconv.Itoa(id),
+		Domain:           d.Domain,
+		DNSProvider:      d.DNSProvider,
+		Ns1ApiKey:        d.Ns1ApiKey,
+		Rfc2136Server:    d.Rfc2136Server,
+		Rfc2136TSIGKey:   d.Rfc2136TSIGKey,
+		KatoctlURL:       d.KatoctlURL,
+		KatoctlSHA256:    d.KatoctlSHA256,
+		CaCert:           d.CaCert,
+		VersionsFile:     d.VersionsFile,
+		RolesFile:        d.RolesFile,
+		TemplatesDir:     d.TemplatesDir,
+		ExtraFiles:       d.ExtraFiles,
+		ExtraUnits:       d.ExtraUnits,
+		SSHKeys:          d.SSHKeys,
+		EtcdToken:        d.EtcdToken,
+		EtcdDiscoveryURL: d.EtcdDiscoveryURL,
+		EtcdTLS:          d.EtcdTLS,
+		PKIDir:           pki.Dir(d.StateDir, d.Domain),
+		Secrets: secrets.Config{
+			Backend:    d.SecretsBackend,
+			KeyFile:    d.SecretsKeyFile,
+			VaultAddr:  d.VaultAddr,
+			VaultToken: d.VaultToken,
+			VaultKey:   d.VaultTransitKey,
+		},
+	}
+
+	// Worker nodes run flannel and REX-Ray:
+	if role == "node" {
+		u.FlannelNetwork = d.FlannelNetwork
+		u.FlannelSubnetLen = d.FlannelSubnetLen
+		u.FlannelSubnetMin = d.FlannelSubnetMin
+		u.FlannelSubnetMax = d.FlannelSubnetMax
+		u.FlannelBackend = d.FlannelBackend
+		u.RexrayStorageDriver = "ec2"
+	}
+
+	return u
 }
 
 //-----------------------------------------------------------------------------
@@ -562,130 +666,150 @@ func (d *Data) runInstance(udata []byte) error {
 	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.Hostname}).
 		Info("- New EC2 instance tagged")
 
-	return nil
+	// Record the instance:
+	return d.record(state.Instance, d.IAMRole, d.instanceID, d.Hostname)
 }
 
 //-----------------------------------------------------------------------------
 // func: setupVPCNetwork
 //-----------------------------------------------------------------------------
 
-func (d *Data) setupVPCNetwork(wg *sync.WaitGroup) {
-
-	// Decrement:
-	defer wg.Done()
+func (d *Data) setupVPCNetwork() error {
 
 	// Retrieve the main route table ID:
 	if err := d.retrieveMainRouteTableID(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Create the external and internal subnets:
 	if err := d.createSubnets(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Create a route table (ext):
 	if err := d.createRouteTable(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Associate the route table to the external subnet:
 	if err := d.associateRouteTable(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Create the internet gateway:
 	if err := d.createInternetGateway(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Attach internet gateway to VPC:
 	if err := d.attachInternetGateway(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Create a default route via internet GW (ext):
 	if err := d.createInternetGatewayRoute(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
-	// Allocate a new elastic IP:
-	if err := d.allocateElasticIP(); err != nil {
-		os.Exit(1)
+	// Look for an existing NAT gateway:
+	if err := d.lookupNatGateway(); err != nil {
+		return err
+	}
+
+	if d.natGatewayID == "" {
+
+		// Allocate a new elastic IP:
+		if err := d.allocateElasticIP(); err != nil {
+			return err
+		}
+
+		// Create a NAT gateway:
+		if err := d.createNatGateway(); err != nil {
+			return err
+		}
 	}
 
-	// Create a NAT gateway:
-	if err := d.createNatGateway(); err != nil {
-		os.Exit(1)
+	// Wait until the NAT gateway is available:
+	if err := d.waitNatGateway(); err != nil {
+		return err
 	}
 
 	// Create a default route via NAT GW (int):
 	if err := d.createNatGatewayRoute(); err != nil {
-		os.Exit(1)
+		return err
 	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
 // func: setupIAMSecurity
 //-----------------------------------------------------------------------------
 
-func (d *Data) setupIAMSecurity(wg *sync.WaitGroup) {
-
-	// Decrement:
-	defer wg.Done()
+func (d *Data) setupIAMSecurity() error {
 
 	// Create REX-Ray policy:
 	if err := d.createRexrayPolicy(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Create IAM roles:
 	if err := d.createIAMRoles(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Create instance profiles:
-	d.createInstanceProfiles()
+	if err := d.createInstanceProfiles(); err != nil {
+		return err
+	}
 
 	// Attach REX-Ray policy to IAM role:
 	if err := d.attachRexrayPolicy(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Add IAM roles to instance profiles:
 	if err := d.addIAMRolesToInstanceProfiles(); err != nil {
-		os.Exit(1)
+		return err
+	}
+
+	// Let the hosts publish their records in Route 53:
+	if d.DNSProvider == "route53" {
+		if err := d.putRoute53Policy(); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
 // func: setupEC2Firewall
 //-----------------------------------------------------------------------------
 
-func (d *Data) setupEC2Firewall(wg *sync.WaitGroup) {
-
-	// Decrement:
-	defer wg.Done()
+func (d *Data) setupEC2Firewall() error {
 
 	// Create EC2 security groups:
 	if err := d.createSecurityGroups(); err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	// Setup master nodes firewall:
-	if err := d.masterFirewall(); err != nil {
-		os.Exit(1)
+	if err := d.firewall("master", d.masterSecGrp); err != nil {
+		return err
 	}
 
 	// Setup worker nodes firewall:
-	if err := d.nodeFirewall(); err != nil {
-		os.Exit(1)
+	if err := d.firewall("node", d.nodeSecGrp); err != nil {
+		return err
 	}
 
 	// Setup edge nodes firewall:
-	if err := d.edgeFirewall(); err != nil {
-		os.Exit(1)
+	if err := d.firewall("edge", d.edgeSecGrp); err != nil {
+		return err
 	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
@@ -694,6 +818,19 @@ func (d *Data) setupEC2Firewall(wg *sync.WaitGroup) {
 
 func (d *Data) createVpc() error {
 
+	// Reuse the VPC tagged with this domain:
+	id, err := d.lookupVpc()
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		d.vpcID = id
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.vpcID}).
+			Info("- Using existing EC2 VPC")
+		return d.record("vpc", "", d.vpcID, d.Domain)
+	}
+
 	// Forge the VPC request:
 	params := &ec2.CreateVpcInput{
 		CidrBlock:       aws.String(d.VpcCidrBlock),
@@ -713,12 +850,13 @@ func (d *Data) createVpc() error {
 	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.vpcID}).
 		Info("- New EC2 VPC created")
 
-	// Tag the VPC:
-	if err = d.tag(d.vpcID, "Name", d.Domain); err != nil {
+	// Record the VPC before anything else can fail:
+	if err = d.created("vpc", "", d.vpcID, d.Domain); err != nil {
 		return err
 	}
 
-	return nil
+	// Tag the VPC:
+	return d.tag(d.vpcID, "Name", d.Domain)
 }
 
 //-----------------------------------------------------------------------------
@@ -757,9 +895,10 @@ func (d *Data) retrieveMainRouteTableID() error {
 	d.mainRouteTableID = *resp.RouteTables[0].RouteTableId
 	log.WithFields(log.Fields{
 		"cmd": d.command + ":ec2", "id": d.mainRouteTableID}).
-		Info("- New main route table added")
+		Info("- Using main route table")
 
-	return nil
+	// Record the main route table:
+	return d.record("route-table", "main", d.mainRouteTableID, "")
 }
 
 //-----------------------------------------------------------------------------
@@ -779,6 +918,22 @@ func (d *Data) createSubnets() error {
 	// For each subnet:
 	for k, v := range nets {
 
+		// Reuse the subnet if it already exists:
+		id, err := d.lookupSubnet(v["SubnetCidr"])
+		if err != nil {
+			return err
+		}
+
+		if id != "" {
+			v["SubnetID"] = id
+			log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": id}).
+				Info("- Using existing " + k + " subnet")
+			if err = d.record("subnet", k, id, v["SubnetCidr"]); err != nil {
+				return err
+			}
+			continue
+		}
+
 		// Forge the subnet request:
 		params := &ec2.CreateSubnetInput{
 			CidrBlock: aws.String(v["SubnetCidr"]),
@@ -798,6 +953,11 @@ func (d *Data) createSubnets() error {
 		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": v["SubnetID"]}).
 			Info("- New " + k + " subnet")
 
+		// Record the subnet before anything else can fail:
+		if err = d.created("subnet", k, v["SubnetID"], v["SubnetCidr"]); err != nil {
+			return err
+		}
+
 		// Tag the subnet:
 		if err = d.tag(v["SubnetID"], "Name", k); err != nil {
 			return err
@@ -817,6 +977,19 @@ func (d *Data) createSubnets() error {
 
 func (d *Data) createRouteTable() error {
 
+	// Reuse the table tagged or associated as external:
+	id, err := d.lookupRouteTable()
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		d.routeTableID = id
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.routeTableID}).
+			Info("- Using existing route table")
+		return d.record("route-table", "external", d.routeTableID, "")
+	}
+
 	// Forge the route table request:
 	params := &ec2.CreateRouteTableInput{
 		VpcId:  aws.String(d.vpcID),
@@ -835,7 +1008,13 @@ func (d *Data) createRouteTable() error {
 	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.routeTableID}).
 		Info("- New route table added")
 
-	return nil
+	// Record the route table before anything else can fail:
+	if err = d.created("route-table", "external", d.routeTableID, ""); err != nil {
+		return err
+	}
+
+	// Tag the route table:
+	return d.tag(d.routeTableID, "Name", "external")
 }
 
 //-----------------------------------------------------------------------------
@@ -844,6 +1023,35 @@ func (d *Data) createRouteTable() error {
 
 func (d *Data) associateRouteTable() error {
 
+	// Forge the description request:
+	descPrms := &ec2.DescribeRouteTablesInput{
+		DryRun: aws.Bool(false),
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("route-table-id"),
+				Values: []*string{aws.String(d.routeTableID)},
+			},
+			{
+				Name:   aws.String("association.subnet-id"),
+				Values: []*string{aws.String(d.ExtSubnetID)},
+			},
+		},
+	}
+
+	// Send the description request:
+	descRsp, err := d.svcEC2.DescribeRouteTables(descPrms)
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	// Already associated:
+	if len(descRsp.RouteTables) > 0 {
+		log.WithField("cmd", d.command+":ec2").
+			Info("- Route table already associated")
+		return nil
+	}
+
 	// Forge the association request:
 	params := &ec2.AssociateRouteTableInput{
 		RouteTableId: aws.String(d.routeTableID),
@@ -871,6 +1079,20 @@ func (d *Data) associateRouteTable() error {
 
 func (d *Data) createInternetGateway() error {
 
+	// Reuse the internet gateway of this domain:
+	id, err := d.lookupInternetGateway()
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		d.internetGatewayID = id
+		log.WithFields(log.Fields{
+			"cmd": d.command + ":ec2", "id": d.internetGatewayID}).
+			Info("- Using existing internet gateway")
+		return d.record("internet-gateway", "", d.internetGatewayID, "")
+	}
+
 	// Forge the internet gateway request:
 	params := &ec2.CreateInternetGatewayInput{
 		DryRun: aws.Bool(false),
@@ -889,7 +1111,13 @@ func (d *Data) createInternetGateway() error {
 		"cmd": d.command + ":ec2", "id": d.internetGatewayID}).
 		Info("- New internet gateway")
 
-	return nil
+	// Record the internet gateway before anything else can fail:
+	if err = d.created("internet-gateway", "", d.internetGatewayID, ""); err != nil {
+		return err
+	}
+
+	// Tag the internet gateway:
+	return d.tag(d.internetGatewayID, "Name", d.Domain)
 }
 
 //-----------------------------------------------------------------------------
@@ -907,6 +1135,9 @@ func (d *Data) attachInternetGateway() error {
 
 	// Send the attachement request:
 	if _, err := d.svcEC2.AttachInternetGateway(params); err != nil {
+		if awsCode(err) == "Resource.AlreadyAssociated" {
+			return nil
+		}
 		log.WithField("cmd", d.command+":ec2").Error(err)
 		return err
 	}
@@ -932,8 +1163,7 @@ func (d *Data) createInternetGatewayRoute() error {
 	}
 
 	// Send the route request:
-	if _, err := d.svcEC2.CreateRoute(params); err != nil {
-		log.WithField("cmd", d.command+":ec2").Error(err)
+	if err := d.createRoute(params); err != nil {
 		return err
 	}
 
@@ -967,7 +1197,12 @@ func (d *Data) allocateElasticIP() error {
 	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.allocationID}).
 		Info("- New elastic IP allocated")
 
-	return nil
+	// Record the elastic IP:
+	if d.command == "setup" {
+		return d.created("elastic-ip", "nat", d.allocationID, "")
+	}
+
+	return d.record("elastic-ip", d.IAMRole, d.allocationID, d.Hostname)
 }
 
 //-----------------------------------------------------------------------------
@@ -1016,7 +1251,7 @@ func (d *Data) createNatGateway() error {
 	params := &ec2.CreateNatGatewayInput{
 		AllocationId: aws.String(d.allocationID),
 		SubnetId:     aws.String(d.ExtSubnetID),
-		ClientToken:  aws.String(d.Domain),
+		ClientToken:  aws.String(d.Domain + "-" + d.allocationID),
 	}
 
 	// Send the NAT gateway request:
@@ -1031,74 +1266,141 @@ func (d *Data) createNatGateway() error {
 	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.natGatewayID}).
 		Info("- New NAT gateway requested")
 
-	// Wait until the NAT gateway is available:
-	log.WithField("cmd", d.command+":ec2").
-		Info("- Waiting until NAT gateway is available")
-	if err := d.svcEC2.WaitUntilNatGatewayAvailable(&ec2.DescribeNatGatewaysInput{
-		NatGatewayIds: []*string{aws.String(d.natGatewayID)},
-	}); err != nil {
-		log.WithField("cmd", d.command+":ec2").Error(err)
-		return err
-	}
-
-	return nil
+	// Record the NAT gateway:
+	return d.created("nat-gateway", "", d.natGatewayID, "")
 }
 
 //-----------------------------------------------------------------------------
-// func: createNatGatewayRoute
+// func: lookupNatGateway
 //-----------------------------------------------------------------------------
 
-func (d *Data) createNatGatewayRoute() error {
-
-	// Forge the route request:
-	params := &ec2.CreateRouteInput{
-		DestinationCidrBlock: aws.String("0.0.0.0/0"),
-		RouteTableId:         aws.String(d.mainRouteTableID),
-		DryRun:               aws.Bool(false),
-		NatGatewayId:         aws.String(d.natGatewayID),
-	}
+func (d *Data) lookupNatGateway() error {
 
-	// Send the route request:
-	if _, err := d.svcEC2.CreateRoute(params); err != nil {
-		log.WithField("cmd", d.command+":ec2").Error(err)
+	// Nothing to reuse:
+	gw, err := d.describeNatGateway()
+	if err != nil || gw == nil {
 		return err
 	}
 
-	log.WithField("cmd", d.command+":ec2").
-		Info("- New default route added via NAT gateway")
+	// Store the NAT gateway ID:
+	d.natGatewayID = *gw.NatGatewayId
+	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": d.natGatewayID}).
+		Info("- Using existing NAT gateway")
+
+	// Record the NAT gateway and its elastic IP:
+	for _, v := range gw.NatGatewayAddresses {
+		if v.AllocationId != nil {
+			d.allocationID = *v.AllocationId
+			if err := d.record("elastic-ip", "nat", d.allocationID, ""); err != nil {
+				return err
+			}
+		}
+	}
 
-	return nil
+	return d.record("nat-gateway", "", d.natGatewayID, "")
 }
 
 //-----------------------------------------------------------------------------
-// func: createRexrayPolicy
+// func: describeNatGateway
 //-----------------------------------------------------------------------------
 
-func (d *Data) createRexrayPolicy() error {
+// describeNatGateway returns the live NAT gateway of the external subnet, if
+// any.
+func (d *Data) describeNatGateway() (*ec2.NatGateway, error) {
 
-	// Forge the listing request:
-	listPrms := &iam.ListPoliciesInput{
-		MaxItems:     aws.Int64(100),
-		OnlyAttached: aws.Bool(false),
-		PathPrefix:   aws.String("/kato/"),
-		Scope:        aws.String("Local"),
+	// Forge the description request:
+	params := &ec2.DescribeNatGatewaysInput{
+		Filter: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(d.vpcID)},
+			},
+			{
+				Name:   aws.String("subnet-id"),
+				Values: []*string{aws.String(d.ExtSubnetID)},
+			},
+			{
+				Name:   aws.String("state"),
+				Values: aws.StringSlice([]string{"pending", "available"}),
+			},
+		},
 	}
 
-	// Send the listing request:
-	listRsp, err := d.svcIAM.ListPolicies(listPrms)
+	// Send the description request:
+	resp, err := d.svcEC2.DescribeNatGateways(params)
 	if err != nil {
 		log.WithField("cmd", d.command+":ec2").Error(err)
+		return nil, err
+	}
+
+	if len(resp.NatGateways) == 0 {
+		return nil, nil
+	}
+
+	return resp.NatGateways[0], nil
+}
+
+//-----------------------------------------------------------------------------
+// func: waitNatGateway
+//-----------------------------------------------------------------------------
+
+func (d *Data) waitNatGateway() error {
+
+	// Wait until the NAT gateway is available:
+	log.WithField("cmd", d.command+":ec2").
+		Info("- Waiting until NAT gateway is available")
+	if err := d.svcEC2.WaitUntilNatGatewayAvailable(&ec2.DescribeNatGatewaysInput{
+		NatGatewayIds: []*string{aws.String(d.natGatewayID)},
+	}); err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: createNatGatewayRoute
+//-----------------------------------------------------------------------------
+
+func (d *Data) createNatGatewayRoute() error {
+
+	// Forge the route request:
+	params := &ec2.CreateRouteInput{
+		DestinationCidrBlock: aws.String("0.0.0.0/0"),
+		RouteTableId:         aws.String(d.mainRouteTableID),
+		DryRun:               aws.Bool(false),
+		NatGatewayId:         aws.String(d.natGatewayID),
+	}
+
+	// Send the route request:
+	if err := d.createRoute(params); err != nil {
 		return err
 	}
 
+	log.WithField("cmd", d.command+":ec2").
+		Info("- New default route added via NAT gateway")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: createRexrayPolicy
+//-----------------------------------------------------------------------------
+
+func (d *Data) createRexrayPolicy() error {
+
 	// Check whether the policy exists:
-	for _, v := range listRsp.Policies {
-		if *v.PolicyName == "REX-Ray" {
-			d.rexrayPolicyARN = *listRsp.Policies[0].Arn
-			log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": *listRsp.
-				Policies[0].PolicyId}).Info("- Using existing REX-Ray security policy")
-			return nil
-		}
+	arn, err := d.lookupRexrayPolicy()
+	if err != nil {
+		return err
+	}
+
+	if arn != "" {
+		d.rexrayPolicyARN = arn
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": arn}).
+			Info("- Using existing REX-Ray security policy")
+		return d.record("iam-policy", "rexray", d.rexrayPolicyARN, "REX-Ray")
 	}
 
 	// REX-Ray IAM policy:
@@ -1155,7 +1457,8 @@ func (d *Data) createRexrayPolicy() error {
 	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": *policyRsp.Policy.
 		PolicyId}).Info("- Setup REX-Ray security policy")
 
-	return nil
+	// Record the policy:
+	return d.created("iam-policy", "rexray", d.rexrayPolicyARN, "REX-Ray")
 }
 
 //-----------------------------------------------------------------------------
@@ -1183,6 +1486,54 @@ func (d *Data) attachRexrayPolicy() error {
 	return nil
 }
 
+//-----------------------------------------------------------------------------
+// func: putRoute53Policy
+//-----------------------------------------------------------------------------
+
+// putRoute53Policy adds the inline policy needed by 'katoctl agent dns-publish'
+// to every role. It goes away together with the roles.
+func (d *Data) putRoute53Policy() error {
+
+	// Route 53 IAM policy:
+	policy := `{
+    "Version": "2012-10-17",
+    "Statement": [
+        {
+            "Sid": "KatoDNSPublish",
+            "Effect": "Allow",
+            "Action": [
+                "route53:ListHostedZonesByName",
+                "route53:ChangeResourceRecordSets"
+            ],
+            "Resource": [
+                "*"
+            ]
+        }
+    ]
+	}`
+
+	for _, role := range []string{"master", "node", "edge"} {
+
+		// Forge the policy request:
+		params := &iam.PutRolePolicyInput{
+			PolicyDocument: aws.String(policy),
+			PolicyName:     aws.String(route53Policy),
+			RoleName:       aws.String(role),
+		}
+
+		// Send the policy request:
+		if _, err := d.svcIAM.PutRolePolicy(params); err != nil {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return err
+		}
+
+		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": role}).
+			Info("- Route 53 policy added to role")
+	}
+
+	return nil
+}
+
 //-----------------------------------------------------------------------------
 // func: createIAMRoles
 //-----------------------------------------------------------------------------
@@ -1219,21 +1570,37 @@ func (d *Data) createIAMRoles() error {
 		}
 
 		// Send the role request:
+		record := d.created
 		resp, err := d.svcIAM.CreateRole(params)
 		if err != nil {
-			if reqErr, ok := err.(awserr.RequestFailure); ok {
-				if reqErr.StatusCode() == 409 {
-					continue
-				}
+			if reqErr, ok := err.(awserr.RequestFailure); !ok || reqErr.StatusCode() != 409 {
+				log.WithField("cmd", d.command+":ec2").Error(err)
+				return err
 			}
-			log.WithField("cmd", d.command+":ec2").Error(err)
-			return err
+
+			// Reuse the existing role:
+			getRsp, err := d.svcIAM.GetRole(&iam.GetRoleInput{RoleName: aws.String(k)})
+			if err != nil {
+				log.WithField("cmd", d.command+":ec2").Error(err)
+				return err
+			}
+
+			resp = &iam.CreateRoleOutput{Role: getRsp.Role}
+			record = d.record
+			log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": *resp.Role.RoleId}).
+				Info("- Using existing " + k + " IAM role")
+		} else {
+			log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": *resp.Role.RoleId}).
+				Info("- New " + k + " IAM role")
 		}
 
 		// Locally store the role ID:
 		v["roleID"] = *resp.Role.RoleId
-		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": v["roleID"]}).
-			Info("- New " + k + " IAM role")
+
+		// Record the role:
+		if err = record("iam-role", k, v["roleID"], k); err != nil {
+			return err
+		}
 	}
 
 	// Store security role IDs:
@@ -1254,10 +1621,11 @@ func (d *Data) createIAMRoles() error {
 // func: createInstanceProfiles
 //-----------------------------------------------------------------------------
 
-func (d *Data) createInstanceProfiles() {
+func (d *Data) createInstanceProfiles() error {
 
-	// Setup a wait group:
+	// Setup a wait group and an error channel:
 	var wg sync.WaitGroup
+	errc := make(chan error, 3)
 
 	// For each instance profile:
 	for _, v := range [3]string{"master", "node", "edge"} {
@@ -1277,15 +1645,35 @@ func (d *Data) createInstanceProfiles() {
 			}
 
 			// Send the profile request:
+			record := d.created
 			resp, err := d.svcIAM.CreateInstanceProfile(params)
 			if err != nil {
-				if reqErr, ok := err.(awserr.RequestFailure); ok {
-					if reqErr.StatusCode() == 409 {
-						return
-					}
+				if reqErr, ok := err.(awserr.RequestFailure); !ok || reqErr.StatusCode() != 409 {
+					log.WithField("cmd", d.command+":ec2").Error(err)
+					errc <- err
+					return
 				}
-				log.WithField("cmd", d.command+":ec2").Error(err)
-				os.Exit(1)
+
+				// Reuse the existing profile:
+				getRsp, err := d.svcIAM.GetInstanceProfile(&iam.GetInstanceProfileInput{
+					InstanceProfileName: aws.String(role),
+				})
+				if err != nil {
+					log.WithField("cmd", d.command+":ec2").Error(err)
+					errc <- err
+					return
+				}
+
+				resp = &iam.CreateInstanceProfileOutput{
+					InstanceProfile: getRsp.InstanceProfile}
+				record = d.record
+			}
+
+			// Record the instance profile:
+			if err := record("instance-profile", role,
+				*resp.InstanceProfile.InstanceProfileId, role); err != nil {
+				errc <- err
+				return
 			}
 
 			// Wait until the instance profile exists:
@@ -1296,13 +1684,16 @@ func (d *Data) createInstanceProfiles() {
 				InstanceProfileName: aws.String(role),
 			}); err != nil {
 				log.WithField("cmd", d.command+":ec2").Error(err)
-				os.Exit(1)
+				errc <- err
+				return
 			}
 		}(v)
 	}
 
-	// Wait:
+	// Wait and return the first error, if any:
 	wg.Wait()
+	close(errc)
+	return <-errc
 }
 
 //-----------------------------------------------------------------------------
@@ -1355,6 +1746,22 @@ func (d *Data) createSecurityGroups() error {
 	// For each security group:
 	for k, v := range grps {
 
+		// Reuse the group if it already exists:
+		id, err := d.lookupSecurityGroup(k)
+		if err != nil {
+			return err
+		}
+
+		if id != "" {
+			v["secGrpID"] = id
+			log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": id}).
+				Info("- Using existing EC2 " + k + " security group")
+			if err = d.record("security-group", k, id, k); err != nil {
+				return err
+			}
+			continue
+		}
+
 		// Forge the group request:
 		params := &ec2.CreateSecurityGroupInput{
 			Description: aws.String(d.Domain + " " + k),
@@ -1375,6 +1782,11 @@ func (d *Data) createSecurityGroups() error {
 		log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": v["secGrpID"]}).
 			Info("- New EC2 " + k + " security group")
 
+		// Record the group before anything else can fail:
+		if err = d.created("security-group", k, v["secGrpID"], k); err != nil {
+			return err
+		}
+
 		// Tag the group:
 		if err = d.tag(v["secGrpID"], "Name", d.Domain+" "+k); err != nil {
 			return err
@@ -1390,183 +1802,87 @@ func (d *Data) createSecurityGroups() error {
 }
 
 //-----------------------------------------------------------------------------
-// func: masterFirewall
+// func: firewall
 //-----------------------------------------------------------------------------
 
-func (d *Data) masterFirewall() error {
+func (d *Data) firewall(role, secGrpID string) error {
 
 	// Forge the rule request:
 	params := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: aws.String(d.masterSecGrp),
-		IpPermissions: []*ec2.IpPermission{
-			{
-				IpProtocol: aws.String("-1"),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: aws.String(d.masterSecGrp),
-					},
-					{
-						GroupId: aws.String(d.nodeSecGrp),
-					},
-					{
-						GroupId: aws.String(d.edgeSecGrp),
-					},
-				},
-			},
-		},
+		GroupId:       aws.String(secGrpID),
+		IpPermissions: d.ingressRules(role),
 	}
 
 	// Send the rule request:
-	_, err := d.svcEC2.AuthorizeSecurityGroupIngress(params)
-	if err != nil {
-		log.WithField("cmd", d.command+":ec2").Error(err)
+	if err := d.authorizeIngress(params); err != nil {
 		return err
 	}
 
-	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": "master"}).
+	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": role}).
 		Info("- New firewall rules defined")
 
 	return nil
 }
 
 //-----------------------------------------------------------------------------
-// func: nodeFirewall
+// func: ingressRules
 //-----------------------------------------------------------------------------
 
-func (d *Data) nodeFirewall() error {
+// ingressRules returns the inbound rules of the given role's security group.
+// Every group accepts all the traffic coming from the cluster itself.
+func (d *Data) ingressRules(role string) []*ec2.IpPermission {
 
-	// Forge the rule request:
-	params := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: aws.String(d.nodeSecGrp),
-		IpPermissions: []*ec2.IpPermission{
-			{
-				IpProtocol: aws.String("-1"),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: aws.String(d.masterSecGrp),
-					},
-					{
-						GroupId: aws.String(d.nodeSecGrp),
-					},
-					{
-						GroupId: aws.String(d.edgeSecGrp),
-					},
+	rules := []*ec2.IpPermission{
+		{
+			IpProtocol: aws.String("-1"),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{
+				{
+					GroupId: aws.String(d.masterSecGrp),
 				},
-			},
-			{
-				FromPort:   aws.Int64(80),
-				ToPort:     aws.Int64(80),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
+				{
+					GroupId: aws.String(d.nodeSecGrp),
 				},
-			},
-			{
-				FromPort:   aws.Int64(443),
-				ToPort:     aws.Int64(443),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
+				{
+					GroupId: aws.String(d.edgeSecGrp),
 				},
 			},
 		},
 	}
 
-	// Send the rule request:
-	_, err := d.svcEC2.AuthorizeSecurityGroupIngress(params)
-	if err != nil {
-		log.WithField("cmd", d.command+":ec2").Error(err)
-		return err
+	// Public ports:
+	var ports []string
+	switch role {
+	case "node":
+		ports = []string{"tcp/80", "tcp/443"}
+	case "edge":
+		ports = []string{"tcp/22", "tcp/80", "tcp/443", "udp/18443"}
+	}
+
+	for _, v := range ports {
+		proto, port := splitPort(v)
+		rules = append(rules, &ec2.IpPermission{
+			FromPort:   aws.Int64(port),
+			ToPort:     aws.Int64(port),
+			IpProtocol: aws.String(proto),
+			IpRanges: []*ec2.IpRange{
+				{
+					CidrIp: aws.String("0.0.0.0/0"),
+				},
+			},
+		})
 	}
 
-	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": "node"}).
-		Info("- New firewall rules defined")
-
-	return nil
+	return rules
 }
 
 //-----------------------------------------------------------------------------
-// func: edgeFirewall
+// func: splitPort
 //-----------------------------------------------------------------------------
 
-func (d *Data) edgeFirewall() error {
-
-	// Forge the rule request:
-	params := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: aws.String(d.edgeSecGrp),
-		IpPermissions: []*ec2.IpPermission{
-			{
-				IpProtocol: aws.String("-1"),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: aws.String(d.masterSecGrp),
-					},
-					{
-						GroupId: aws.String(d.nodeSecGrp),
-					},
-					{
-						GroupId: aws.String(d.edgeSecGrp),
-					},
-				},
-			},
-			{
-				FromPort:   aws.Int64(22),
-				ToPort:     aws.Int64(22),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-			{
-				FromPort:   aws.Int64(80),
-				ToPort:     aws.Int64(80),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-			{
-				FromPort:   aws.Int64(443),
-				ToPort:     aws.Int64(443),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-			{
-				FromPort:   aws.Int64(18443),
-				ToPort:     aws.Int64(18443),
-				IpProtocol: aws.String("udp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-		},
-	}
-
-	// Send the rule request:
-	_, err := d.svcEC2.AuthorizeSecurityGroupIngress(params)
-	if err != nil {
-		log.WithField("cmd", d.command+":ec2").Error(err)
-		return err
-	}
-
-	log.WithFields(log.Fields{"cmd": d.command + ":ec2", "id": "edge"}).
-		Info("- New firewall rules defined")
-
-	return nil
+func splitPort(s string) (string, int64) {
+	i := strings.Index(s, "/")
+	port, _ := strconv.ParseInt(s[i+1:], 10, 64)
+	return s[:i], port
 }
 
 //-----------------------------------------------------------------------------
@@ -1649,3 +1965,444 @@ func (d *Data) tag(resource, key, value string) error {
 
 	return nil
 }
+
+//-----------------------------------------------------------------------------
+// func: lookupSubnet
+//-----------------------------------------------------------------------------
+
+func (d *Data) lookupSubnet(cidr string) (string, error) {
+
+	// Forge the description request:
+	params := &ec2.DescribeSubnetsInput{
+		DryRun: aws.Bool(false),
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(d.vpcID)},
+			},
+			{
+				Name:   aws.String("cidr-block"),
+				Values: []*string{aws.String(cidr)},
+			},
+		},
+	}
+
+	// Send the description request:
+	resp, err := d.svcEC2.DescribeSubnets(params)
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return "", err
+	}
+
+	if len(resp.Subnets) == 0 {
+		return "", nil
+	}
+
+	return *resp.Subnets[0].SubnetId, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: lookupSecurityGroup
+//-----------------------------------------------------------------------------
+
+func (d *Data) lookupSecurityGroup(name string) (string, error) {
+
+	// Forge the description request:
+	params := &ec2.DescribeSecurityGroupsInput{
+		DryRun: aws.Bool(false),
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(d.vpcID)},
+			},
+			{
+				Name:   aws.String("group-name"),
+				Values: []*string{aws.String(name)},
+			},
+		},
+	}
+
+	// Send the description request:
+	resp, err := d.svcEC2.DescribeSecurityGroups(params)
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return "", err
+	}
+
+	if len(resp.SecurityGroups) == 0 {
+		return "", nil
+	}
+
+	return *resp.SecurityGroups[0].GroupId, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: lookupVpc
+//-----------------------------------------------------------------------------
+
+func (d *Data) lookupVpc() (string, error) {
+
+	// Forge the description request:
+	params := &ec2.DescribeVpcsInput{
+		DryRun: aws.Bool(false),
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:Name"),
+				Values: []*string{aws.String(d.Domain)},
+			},
+		},
+	}
+
+	// Send the description request:
+	resp, err := d.svcEC2.DescribeVpcs(params)
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return "", err
+	}
+
+	if len(resp.Vpcs) == 0 {
+		return "", nil
+	}
+
+	return *resp.Vpcs[0].VpcId, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: lookupRouteTable
+//-----------------------------------------------------------------------------
+
+// lookupRouteTable finds the external route table of the VPC.
+func (d *Data) lookupRouteTable() (string, error) {
+
+	// Forge the description request:
+	params := &ec2.DescribeRouteTablesInput{
+		DryRun: aws.Bool(false),
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(d.vpcID)},
+			},
+		},
+	}
+
+	// Send the description request:
+	resp, err := d.svcEC2.DescribeRouteTables(params)
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return "", err
+	}
+
+	for _, rt := range resp.RouteTables {
+		if isExternalRouteTable(rt, d.ExtSubnetID) {
+			return *rt.RouteTableId, nil
+		}
+	}
+
+	return "", nil
+}
+
+//-----------------------------------------------------------------------------
+// func: lookupInternetGateway
+//-----------------------------------------------------------------------------
+
+// lookupInternetGateway finds the internet gateway tagged with the domain,
+// attached or not. Failing that, it falls back to the one attached to the
+// VPC, as set up before gateways were tagged.
+func (d *Data) lookupInternetGateway() (string, error) {
+
+	for _, f := range d.internetGatewayFilters() {
+
+		// Send the description request:
+		resp, err := d.svcEC2.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{
+			DryRun:  aws.Bool(false),
+			Filters: []*ec2.Filter{f},
+		})
+		if err != nil {
+			log.WithField("cmd", d.command+":ec2").Error(err)
+			return "", err
+		}
+
+		if len(resp.InternetGateways) > 0 {
+			return *resp.InternetGateways[0].InternetGatewayId, nil
+		}
+	}
+
+	return "", nil
+}
+
+//-----------------------------------------------------------------------------
+// func: internetGatewayFilters
+//-----------------------------------------------------------------------------
+
+// internetGatewayFilters returns the ways to find the internet gateway of the
+// domain, the most reliable first.
+func (d *Data) internetGatewayFilters() []*ec2.Filter {
+	return []*ec2.Filter{
+		{
+			Name:   aws.String("tag:Name"),
+			Values: []*string{aws.String(d.Domain)},
+		},
+		{
+			Name:   aws.String("attachment.vpc-id"),
+			Values: []*string{aws.String(d.vpcID)},
+		},
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: lookupRexrayPolicy
+//-----------------------------------------------------------------------------
+
+// lookupRexrayPolicy returns the ARN of the REX-Ray policy.
+func (d *Data) lookupRexrayPolicy() (string, error) {
+
+	// Forge the listing request:
+	params := &iam.ListPoliciesInput{
+		MaxItems:     aws.Int64(100),
+		OnlyAttached: aws.Bool(false),
+		PathPrefix:   aws.String("/kato/"),
+		Scope:        aws.String("Local"),
+	}
+
+	// Send the listing request:
+	resp, err := d.svcIAM.ListPolicies(params)
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return "", err
+	}
+
+	for _, v := range resp.Policies {
+		if *v.PolicyName == "REX-Ray" {
+			return *v.Arn, nil
+		}
+	}
+
+	return "", nil
+}
+
+//-----------------------------------------------------------------------------
+// func: lookupIAMRole
+//-----------------------------------------------------------------------------
+
+func (d *Data) lookupIAMRole(name string) (string, error) {
+
+	// Send the description request:
+	resp, err := d.svcIAM.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+	if isNotFound(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return "", err
+	}
+
+	return *resp.Role.RoleId, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: lookupInstanceProfile
+//-----------------------------------------------------------------------------
+
+func (d *Data) lookupInstanceProfile(name string) (string, error) {
+
+	// Send the description request:
+	resp, err := d.svcIAM.GetInstanceProfile(&iam.GetInstanceProfileInput{
+		InstanceProfileName: aws.String(name),
+	})
+	if isNotFound(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return "", err
+	}
+
+	return *resp.InstanceProfile.InstanceProfileId, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: isExternalRouteTable
+//-----------------------------------------------------------------------------
+
+func isExternalRouteTable(rt *ec2.RouteTable, subnetID string) bool {
+
+	// Associated to the external subnet:
+	for _, a := range rt.Associations {
+		if a.SubnetId != nil && *a.SubnetId == subnetID {
+			return true
+		}
+	}
+
+	// Tagged as external:
+	for _, t := range rt.Tags {
+		if *t.Key == "Name" && *t.Value == "external" {
+			return true
+		}
+	}
+
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: createRoute
+//-----------------------------------------------------------------------------
+
+func (d *Data) createRoute(params *ec2.CreateRouteInput) error {
+
+	// Send the route request:
+	_, err := d.svcEC2.CreateRoute(params)
+	if err == nil {
+		return nil
+	}
+
+	if awsCode(err) != "RouteAlreadyExists" {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	// Point the existing route to the desired target:
+	if _, err = d.svcEC2.ReplaceRoute(&ec2.ReplaceRouteInput{
+		DestinationCidrBlock: params.DestinationCidrBlock,
+		RouteTableId:         params.RouteTableId,
+		DryRun:               params.DryRun,
+		GatewayId:            params.GatewayId,
+		NatGatewayId:         params.NatGatewayId,
+	}); err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: authorizeIngress
+//-----------------------------------------------------------------------------
+
+func (d *Data) authorizeIngress(params *ec2.AuthorizeSecurityGroupIngressInput) error {
+
+	// Send the rule request:
+	_, err := d.svcEC2.AuthorizeSecurityGroupIngress(params)
+	if err == nil {
+		return nil
+	}
+
+	if awsCode(err) != "InvalidPermission.Duplicate" {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	// Some rules exist already, add the missing ones one by one:
+	for _, p := range params.IpPermissions {
+		for _, rule := range splitPermission(p) {
+			if _, err := d.svcEC2.AuthorizeSecurityGroupIngress(
+				&ec2.AuthorizeSecurityGroupIngressInput{
+					GroupId:       params.GroupId,
+					IpPermissions: []*ec2.IpPermission{rule},
+				}); err != nil && awsCode(err) != "InvalidPermission.Duplicate" {
+				log.WithField("cmd", d.command+":ec2").Error(err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: splitPermission
+//-----------------------------------------------------------------------------
+
+// splitPermission returns one permission per source group or CIDR.
+func splitPermission(p *ec2.IpPermission) []*ec2.IpPermission {
+
+	var rules []*ec2.IpPermission
+
+	for _, v := range p.UserIdGroupPairs {
+		rules = append(rules, &ec2.IpPermission{
+			FromPort: p.FromPort, ToPort: p.ToPort, IpProtocol: p.IpProtocol,
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{v},
+		})
+	}
+
+	for _, v := range p.IpRanges {
+		rules = append(rules, &ec2.IpPermission{
+			FromPort: p.FromPort, ToPort: p.ToPort, IpProtocol: p.IpProtocol,
+			IpRanges: []*ec2.IpRange{v},
+		})
+	}
+
+	return rules
+}
+
+//-----------------------------------------------------------------------------
+// func: awsCode
+//-----------------------------------------------------------------------------
+
+// awsCode returns the AWS error code of err, if any.
+func awsCode(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code()
+	}
+	return ""
+}
+
+//-----------------------------------------------------------------------------
+// func: openState
+//-----------------------------------------------------------------------------
+
+func (d *Data) openState() error {
+
+	var err error
+
+	// Load the cluster state:
+	if d.state, err = state.Open(d.StateDir, d.Domain); err != nil {
+		return err
+	}
+
+	// Record where the cluster lives:
+	if d.state.Provider == "" {
+		if err = d.state.SetProvider("ec2", d.Region); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: created
+//-----------------------------------------------------------------------------
+
+// created journals a resource created by the current run, so that it can be
+// rolled back, and records it.
+func (d *Data) created(kind, role, id, name string) error {
+	d.journal.add(state.Resource{Kind: kind, Role: role, ID: id, Name: name})
+	return d.record(kind, role, id, name)
+}
+
+//-----------------------------------------------------------------------------
+// func: record
+//-----------------------------------------------------------------------------
+
+// record persists a resource in the cluster state. Every setup step goes
+// through here, which makes it the checkpoint where an interrupted run stops.
+func (d *Data) record(kind, role, id, name string) error {
+
+	// Nowhere to record:
+	if d.state == nil {
+		return nil
+	}
+
+	// Record the resource:
+	if err := d.state.Record(state.Resource{
+		Kind: kind, Role: role, ID: id, Name: name,
+	}); err != nil {
+		log.WithField("cmd", d.command+":ec2").Error(err)
+		return err
+	}
+
+	return d.journal.check()
+}
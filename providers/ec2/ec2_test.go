@@ -0,0 +1,126 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// iamResponses are the answers of the stand-in IAM API, by action.
+var iamResponses = map[string]string{
+	"ListPolicies": `<ListPoliciesResponse><ListPoliciesResult><Policies><member>
+<PolicyName>REX-Ray</PolicyName><Arn>arn:aws:iam::123456789012:policy/kato/REX-Ray</Arn>
+</member></Policies><IsTruncated>false</IsTruncated></ListPoliciesResult></ListPoliciesResponse>`,
+	"CreateRole": `<CreateRoleResponse><CreateRoleResult><Role>
+<RoleId>AROATEST</RoleId><RoleName>role</RoleName></Role></CreateRoleResult></CreateRoleResponse>`,
+	"CreateInstanceProfile": `<CreateInstanceProfileResponse><CreateInstanceProfileResult><InstanceProfile>
+<InstanceProfileId>AIPATEST</InstanceProfileId></InstanceProfile></CreateInstanceProfileResult></CreateInstanceProfileResponse>`,
+	"GetInstanceProfile": `<GetInstanceProfileResponse><GetInstanceProfileResult><InstanceProfile>
+<InstanceProfileId>AIPATEST</InstanceProfileId></InstanceProfile></GetInstanceProfileResult></GetInstanceProfileResponse>`,
+	"AttachRolePolicy":         `<AttachRolePolicyResponse></AttachRolePolicyResponse>`,
+	"AddRoleToInstanceProfile": `<AddRoleToInstanceProfileResponse></AddRoleToInstanceProfileResponse>`,
+	"PutRolePolicy":            `<PutRolePolicyResponse></PutRolePolicyResponse>`,
+}
+
+//-----------------------------------------------------------------------------
+// func: TestSetupRoute53Policy
+//-----------------------------------------------------------------------------
+
+// The instance roles of a deploy with the route53 DNS provider get the policy
+// the hosts need to publish their records, and only then.
+func TestSetupRoute53Policy(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, provider := range []string{"route53", "ns1"} {
+		t.Run(provider, func(t *testing.T) {
+
+			deploy := &Data{Domain: "cell-1.dc-1.example.com", DNSProvider: provider}
+			e := deploy.setupData()
+
+			var puts []string
+			e.svcIAM = standInIAM(t, &puts)
+
+			if err := e.setupIAMSecurity(); err != nil {
+				t.Fatal(err)
+			}
+
+			var want []string
+			if provider == "route53" {
+				want = []string{"edge", "master", "node"}
+			}
+
+			sort.Strings(puts)
+			if !reflect.DeepEqual(puts, want) {
+				t.Errorf("%s policy put on %v, want %v", route53Policy, puts, want)
+			}
+		})
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: standInIAM
+//-----------------------------------------------------------------------------
+
+// standInIAM returns an IAM client talking to a local server which answers
+// every setup call and keeps the roles given the Route 53 policy in puts.
+func standInIAM(t *testing.T, puts *[]string) *iam.IAM {
+
+	var mu sync.Mutex
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		action := r.Form.Get("Action")
+		res, ok := iamResponses[action]
+		if !ok {
+			t.Errorf("unexpected IAM call: %s", action)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if action == "PutRolePolicy" && r.Form.Get("PolicyName") == route53Policy {
+			*puts = append(*puts, r.Form.Get("RoleName"))
+		}
+
+		w.Write([]byte(res))
+	}))
+	t.Cleanup(srv.Close)
+
+	return iam.New(session.New(&aws.Config{
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKIDTEST", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+}
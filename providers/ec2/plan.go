@@ -0,0 +1,277 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	// Community:
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/h0tbird/kato/pki"
+	"github.com/h0tbird/kato/udata"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// maxUserData is the EC2 limit for raw user-data.
+const maxUserData = 16384
+
+//-----------------------------------------------------------------------------
+// func: plan
+//-----------------------------------------------------------------------------
+
+// plan prints everything the current command would create or reuse, calling
+// only read-only APIs. Setup prints the VPC, IAM and firewall resources;
+// deploy adds the instances and the size of their user-data.
+func (d *Data) plan(out io.Writer) error {
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	// Header:
+	fmt.Fprintf(w, "Plan for %s:ec2 %s in %s\n\n", d.command, d.Domain, d.Region)
+
+	// Read-only lookups:
+	if d.svcEC2 == nil {
+		d.svcEC2 = ec2.New(session.New(&aws.Config{Region: aws.String(d.Region)}))
+		d.svcIAM = iam.New(session.New())
+	}
+
+	// Setup resources:
+	fmt.Fprintln(w, "RESOURCE\tNAME\tACTION\tDETAILS")
+	if err := d.planSetup(w); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil || d.command != "deploy" {
+		return err
+	}
+
+	// Retrieve the CoreOS AMI ID:
+	if err := d.retrieveCoreosAmiID(); err != nil {
+		return err
+	}
+
+	// Instances:
+	fmt.Fprintln(w, "\nHOSTNAME\tTYPE\tIAM-ROLE\tSUBNET\tSECURITY-GROUP\tPUBLIC-IP\tIMAGE\tUSER-DATA")
+	if err := d.planInstances(w); err != nil {
+		return err
+	}
+
+	// Etcd TLS:
+	if d.EtcdTLS {
+		fmt.Fprintln(w, "etcd traffic uses TLS; missing host certificates are issued at deploy time.")
+	}
+
+	// Etcd token:
+	if d.EtcdToken == "auto" {
+		fmt.Fprintln(w, "\nA new etcd bootstrap token will be requested at deploy time.")
+	}
+
+	return w.Flush()
+}
+
+//-----------------------------------------------------------------------------
+// func: planSetup
+//-----------------------------------------------------------------------------
+
+// planSetup runs the lookups of setup and tells, for every resource, whether
+// it would be created or reused.
+func (d *Data) planSetup(w io.Writer) error {
+
+	var err error
+
+	// The VPC and what it already holds:
+	var vpc, intNet, extNet, extTable, igw, eip, nat string
+	if vpc, err = d.lookupVpc(); err != nil {
+		return err
+	}
+
+	mainTable := "create"
+	if d.vpcID = vpc; vpc != "" {
+
+		mainTable = "reuse"
+
+		if intNet, err = d.lookupSubnet(d.IntSubnetCidr); err != nil {
+			return err
+		}
+
+		if extNet, err = d.lookupSubnet(d.ExtSubnetCidr); err != nil {
+			return err
+		}
+
+		d.ExtSubnetID = extNet
+		if extTable, err = d.lookupRouteTable(); err != nil {
+			return err
+		}
+
+		if igw, err = d.lookupInternetGateway(); err != nil {
+			return err
+		}
+
+		gw, err := d.describeNatGateway()
+		if err != nil {
+			return err
+		}
+
+		if gw != nil {
+			nat = *gw.NatGatewayId
+			for _, v := range gw.NatGatewayAddresses {
+				if v.AllocationId != nil {
+					eip = *v.AllocationId
+				}
+			}
+		}
+	}
+
+	// Network:
+	fmt.Fprintf(w, "vpc\t%s\t%s\tcidr=%s\n", d.Domain, action(vpc), d.VpcCidrBlock)
+	fmt.Fprintf(w, "subnet\tinternal\t%s\tcidr=%s\n", action(intNet), d.IntSubnetCidr)
+	fmt.Fprintf(w, "subnet\texternal\t%s\tcidr=%s\n", action(extNet), d.ExtSubnetCidr)
+	fmt.Fprintf(w, "route-table\tmain\t%s\t0.0.0.0/0 via nat-gateway\n", mainTable)
+	fmt.Fprintf(w, "route-table\texternal\t%s\t0.0.0.0/0 via internet-gateway, subnet=external\n", action(extTable))
+	fmt.Fprintf(w, "internet-gateway\t\t%s\tvpc=%s\n", action(igw), d.Domain)
+	fmt.Fprintf(w, "elastic-ip\tnat\t%s\t\n", action(eip))
+	fmt.Fprintf(w, "nat-gateway\t\t%s\tsubnet=external\n", action(nat))
+
+	// IAM:
+	policy, err := d.lookupRexrayPolicy()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "iam-policy\tREX-Ray\t%s\tpath=/kato/, attached to node\n", action(policy))
+	for _, role := range []string{"master", "node", "edge"} {
+
+		id, err := d.lookupIAMRole(role)
+		if err != nil {
+			return err
+		}
+
+		profile, err := d.lookupInstanceProfile(role)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "iam-role\t%s\t%s\tpath=/kato/\n", role, action(id))
+		if d.DNSProvider == "route53" {
+			fmt.Fprintf(w, "iam-role-policy\t%s\tput\trole=%s, route53 record changes\n", route53Policy, role)
+		}
+		fmt.Fprintf(w, "instance-profile\t%s\t%s\trole=%s\n", role, action(profile), role)
+	}
+
+	// Firewall, using group names as placeholders for their IDs:
+	p := *d
+	p.masterSecGrp, p.nodeSecGrp, p.edgeSecGrp = "master", "node", "edge"
+
+	for _, role := range []string{"master", "node", "edge"} {
+
+		var id string
+		if vpc != "" {
+			if id, err = d.lookupSecurityGroup(role); err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(w, "security-group\t%s\t%s\t\n", role, action(id))
+		for _, r := range p.ingressRules(role) {
+			fmt.Fprintf(w, "\t\t\tingress %s\n", describeRule(r))
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: action
+//-----------------------------------------------------------------------------
+
+// action tells whether a resource found with id would be created or reused.
+func action(id string) string {
+	if id == "" {
+		return "create"
+	}
+	return "reuse " + id
+}
+
+//-----------------------------------------------------------------------------
+// func: planInstances
+//-----------------------------------------------------------------------------
+
+func (d *Data) planInstances(w io.Writer) error {
+
+	for _, r := range d.roles() {
+		for i := 1; i <= r.count; i++ {
+
+			// Host certificates are only issued at deploy time:
+			u, note := d.udataFor(r.name, i), ""
+			if crt, _ := pki.Paths(u.PKIDir, r.name+"-"+strconv.Itoa(i)); u.EtcdTLS && !exists(crt) {
+				u.EtcdTLS, note = false, ", without certificates"
+			}
+
+			// Render the user-data:
+			userData, err := u.Bytes(udata.Validated(), udata.Gzip())
+			if err != nil {
+				return err
+			}
+
+			size := strconv.Itoa(len(userData)) + " B (gzip" + note + ")"
+			if len(userData) > maxUserData {
+				size += " exceeds " + strconv.Itoa(maxUserData) + " B"
+			}
+
+			fmt.Fprintf(w, "%s-%d.%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.name, i, d.Domain, r.instanceType, r.name, r.subnet,
+				r.name, r.publicIP, d.ImageID, size)
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: describeRule
+//-----------------------------------------------------------------------------
+
+// describeRule returns a one-line description of an ingress rule.
+func describeRule(r *ec2.IpPermission) string {
+
+	// Protocol and port:
+	what := "all"
+	if *r.IpProtocol != "-1" {
+		what = *r.IpProtocol + "/" + strconv.FormatInt(*r.FromPort, 10)
+	}
+
+	// Sources:
+	var from []string
+	for _, v := range r.UserIdGroupPairs {
+		from = append(from, "sg:"+*v.GroupId)
+	}
+	for _, v := range r.IpRanges {
+		from = append(from, *v.CidrIp)
+	}
+
+	return what + " from " + strings.Join(from, ",")
+}
+
+//-----------------------------------------------------------------------------
+// func: exists
+//-----------------------------------------------------------------------------
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
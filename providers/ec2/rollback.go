@@ -0,0 +1,233 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/h0tbird/kato/state"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// errInterrupted is returned by the setup steps after a SIGINT or SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// journal lists, in creation order, the resources created by a single setup
+// run so that they can be rolled back if the run does not complete.
+type journal struct {
+	mu          sync.Mutex
+	entries     []state.Resource
+	interrupted bool
+}
+
+//-----------------------------------------------------------------------------
+// func: add
+//-----------------------------------------------------------------------------
+
+func (j *journal) add(r state.Resource) {
+
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, r)
+}
+
+//-----------------------------------------------------------------------------
+// func: reversed
+//-----------------------------------------------------------------------------
+
+func (j *journal) reversed() []state.Resource {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	res := make([]state.Resource, len(j.entries))
+	for i, v := range j.entries {
+		res[len(j.entries)-1-i] = v
+	}
+
+	return res
+}
+
+//-----------------------------------------------------------------------------
+// func: check
+//-----------------------------------------------------------------------------
+
+// check returns errInterrupted once the user has asked to stop.
+func (j *journal) check() error {
+
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.interrupted {
+		return errInterrupted
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: trap
+//-----------------------------------------------------------------------------
+
+// trap catches SIGINT and SIGTERM. The first signal marks the journal as
+// interrupted so that the running steps stop at their next checkpoint; the
+// second one exits right away, leaving everything behind. The returned
+// function releases the signals.
+func (j *journal) trap() func() {
+
+	sigs := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+
+		select {
+		case <-sigs:
+			j.mu.Lock()
+			j.interrupted = true
+			j.mu.Unlock()
+			log.WithField("cmd", "setup:ec2").
+				Warn("Interrupted, stopping after the current step (again to abort)")
+		case <-done:
+			return
+		}
+
+		select {
+		case <-sigs:
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: rollback
+//-----------------------------------------------------------------------------
+
+// rollback deletes, in reverse dependency order, every resource journaled by
+// the current setup run. Resources reused from a previous run are kept.
+func (d *Data) rollback() error {
+
+	entries := d.journal.reversed()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	log.WithField("cmd", d.command+":ec2").
+		Warn("Rolling back " + strconv.Itoa(len(entries)) + " resources created by this run")
+
+	// Sort the journal by kind:
+	inv := &inventory{}
+	var vpc, policy bool
+	var profiles, roles []string
+
+	for _, r := range entries {
+		switch r.Kind {
+		case "vpc":
+			vpc = true
+		case "subnet":
+			inv.subnets = append(inv.subnets, r.ID)
+		case "route-table":
+			inv.routeTables = append(inv.routeTables, r.ID)
+		case "internet-gateway":
+			inv.internetGateways = append(inv.internetGateways, r.ID)
+		case "elastic-ip":
+			inv.allocations = append(inv.allocations, r.ID)
+		case "nat-gateway":
+			inv.natGateways = append(inv.natGateways, r.ID)
+		case "security-group":
+			inv.securityGroups = append(inv.securityGroups, r.ID)
+		case "instance-profile":
+			profiles = append(profiles, r.Role)
+		case "iam-role":
+			roles = append(roles, r.Role)
+		case "iam-policy":
+			policy = true
+		}
+	}
+
+	// Delete the NAT gateways:
+	if err := d.deleteNatGateways(inv.natGateways); err != nil {
+		return err
+	}
+
+	// Release the elastic IPs:
+	if err := d.releaseElasticIPs(inv.allocations); err != nil {
+		return err
+	}
+
+	// Detach and delete the internet gateways:
+	if err := d.deleteInternetGateways(inv.internetGateways); err != nil {
+		return err
+	}
+
+	// Delete the route tables:
+	if err := d.deleteRouteTables(inv.routeTables); err != nil {
+		return err
+	}
+
+	// Delete the subnets:
+	if err := d.deleteSubnets(inv.subnets); err != nil {
+		return err
+	}
+
+	// Delete the security groups:
+	if err := d.deleteSecurityGroups(inv.securityGroups); err != nil {
+		return err
+	}
+
+	// Delete the VPC:
+	if vpc {
+		if err := d.deleteVpc(); err != nil {
+			return err
+		}
+	}
+
+	// Delete the instance profiles:
+	if err := d.deleteInstanceProfiles(profiles); err != nil {
+		return err
+	}
+
+	// Delete the IAM roles:
+	if err := d.deleteIAMRoles(roles); err != nil {
+		return err
+	}
+
+	// Delete the REX-Ray policy:
+	if policy {
+		return d.deleteRexrayPolicy()
+	}
+
+	return nil
+}
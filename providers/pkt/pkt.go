@@ -9,6 +9,9 @@ import (
 	// Stdlib:
 	"fmt"
 
+	// Local:
+	"github.com/h0tbird/kato/state"
+
 	// Community:
 	"github.com/packethost/packngo"
 )
@@ -26,6 +29,8 @@ type Data struct {
 	OS        string
 	Facility  string
 	Billing   string
+	Domain    string
+	StateDir  string
 }
 
 //--------------------------------------------------------------------------
@@ -46,6 +51,15 @@ func (d *Data) Setup() error {
 	return nil
 }
 
+//--------------------------------------------------------------------------
+// func: Destroy
+//--------------------------------------------------------------------------
+
+// Destroy a Packet.net deployment.
+func (d *Data) Destroy() error {
+	return nil
+}
+
 //--------------------------------------------------------------------------
 // func: Run
 //--------------------------------------------------------------------------
@@ -75,5 +89,21 @@ func (d *Data) Run(udata []byte) error {
 
 	// Pretty-print the response data:
 	fmt.Println(newDevice)
+
+	// Record the device:
+	if d.Domain != "" {
+		cluster, err := state.Open(d.StateDir, d.Domain)
+		if err != nil {
+			return err
+		}
+		if cluster.Provider == "" {
+			if err = cluster.SetProvider("packet", d.Facility); err != nil {
+				return err
+			}
+		}
+		return cluster.Record(state.Resource{
+			Kind: state.Device, ID: newDevice.ID, Name: d.HostName})
+	}
+
 	return nil
 }
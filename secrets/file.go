@@ -0,0 +1,175 @@
+package secrets
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// file wraps data keys with a local key encryption key.
+type file struct {
+	key []byte
+}
+
+//-----------------------------------------------------------------------------
+// func: newFile
+//-----------------------------------------------------------------------------
+
+// newFile reads the key encryption key from keyFile. If the file does not
+// exist yet and a CA key is given, the key is derived from it and saved.
+func newFile(keyFile, caKeyFile string) (*file, error) {
+
+	if keyFile == "" {
+		err := errors.New("the file secrets backend needs a key file")
+		log.WithField("cmd", "secrets:file").Error(err)
+		return nil, err
+	}
+
+	// Derive the key from the CA:
+	if _, err := os.Stat(keyFile); os.IsNotExist(err) && caKeyFile != "" {
+		key, err := DeriveKey(caKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := WriteKey(keyFile, key); err != nil {
+			return nil, err
+		}
+		log.WithFields(log.Fields{"cmd": "secrets:file", "id": keyFile}).
+			Info("- New secrets key derived from the cluster CA")
+	}
+
+	key, err := ReadKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{key: key}, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Wrap
+//-----------------------------------------------------------------------------
+
+// Wrap encrypts the data key with the key encryption key.
+func (f *file) Wrap(dataKey []byte) (string, error) {
+
+	nonce, data, err := encrypt(f.key, dataKey)
+	if err != nil {
+		log.WithField("cmd", "secrets:file").Error(err)
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(append(nonce, data...)), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Unwrap
+//-----------------------------------------------------------------------------
+
+// Unwrap decrypts a data key wrapped by Wrap.
+func (f *file) Unwrap(wrapped string) ([]byte, error) {
+
+	raw, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(raw) < 12 {
+		err = errors.New("invalid wrapped key")
+		log.WithField("cmd", "secrets:file").Error(err)
+		return nil, err
+	}
+
+	dataKey, err := decrypt(f.key, raw[:12], raw[12:])
+	if err != nil {
+		log.WithField("cmd", "secrets:file").Error(err)
+		return nil, err
+	}
+
+	return dataKey, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: DeriveKey
+//-----------------------------------------------------------------------------
+
+// DeriveKey derives a key encryption key from the private key of the cluster
+// CA. The same CA always yields the same key.
+func DeriveKey(caKeyFile string) ([]byte, error) {
+
+	data, err := ioutil.ReadFile(caKeyFile)
+	if err != nil {
+		log.WithField("cmd", "secrets:file").Error(err)
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err := errors.New("no PEM data in " + caKeyFile)
+		log.WithField("cmd", "secrets:file").Error(err)
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, block.Bytes)
+	mac.Write([]byte("kato secrets v1"))
+	return mac.Sum(nil), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: ReadKey
+//-----------------------------------------------------------------------------
+
+// ReadKey reads a base64 encoded 256-bit key.
+func ReadKey(path string) ([]byte, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithField("cmd", "secrets:file").Error(err)
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		err = errors.New(path + " does not hold a base64 encoded 256-bit key")
+		log.WithField("cmd", "secrets:file").Error(err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: WriteKey
+//-----------------------------------------------------------------------------
+
+// WriteKey saves a key readable by its owner only.
+func WriteKey(path string, key []byte) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.WithField("cmd", "secrets:file").Error(err)
+		return err
+	}
+
+	data := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		log.WithField("cmd", "secrets:file").Error(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package secrets
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// envelopeVersion is bumped whenever the sealed format changes.
+const envelopeVersion = 1
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// Backends lists the supported key backends.
+var Backends = []string{"none", "file", "vault"}
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Backend protects the data key of a bundle. Wrap runs where the user-data
+// is rendered and Unwrap on the host at boot.
+type Backend interface {
+	Wrap(dataKey []byte) (string, error)
+	Unwrap(wrapped string) ([]byte, error)
+}
+
+// Config holds the settings of every backend.
+type Config struct {
+	Backend string
+
+	KeyFile   string // Key encryption key of the file backend.
+	CAKeyFile string // Derive KeyFile from this CA key if it does not exist.
+
+	VaultAddr  string // Vault server, as in VAULT_ADDR.
+	VaultToken string // Vault token, as in VAULT_TOKEN.
+	VaultKey   string // Name of the transit key.
+}
+
+// envelope is the sealed bundle: the secrets encrypted with a random data
+// key, and the data key wrapped by the backend.
+type envelope struct {
+	Version int    `json:"v"`
+	Backend string `json:"backend"`
+	Key     string `json:"key"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+//-----------------------------------------------------------------------------
+// func: New
+//-----------------------------------------------------------------------------
+
+// New returns the configured backend.
+func New(c Config) (Backend, error) {
+
+	var err error
+
+	switch c.Backend {
+	case "file":
+		return newFile(c.KeyFile, c.CAKeyFile)
+	case "vault":
+		return newVault(c.VaultAddr, c.VaultToken, c.VaultKey)
+	default:
+		err = errors.New("unknown secrets backend: " + c.Backend)
+	}
+
+	log.WithField("cmd", "secrets").Error(err)
+	return nil, err
+}
+
+//-----------------------------------------------------------------------------
+// func: Seal
+//-----------------------------------------------------------------------------
+
+// Seal encrypts the secrets into a text bundle that only b can open.
+func Seal(b Backend, name string, secrets map[string]string) (string, error) {
+
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		log.WithField("cmd", "secrets").Error(err)
+		return "", err
+	}
+
+	// A fresh data key for every bundle:
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		log.WithField("cmd", "secrets").Error(err)
+		return "", err
+	}
+
+	nonce, data, err := encrypt(dataKey, plain)
+	if err != nil {
+		log.WithField("cmd", "secrets").Error(err)
+		return "", err
+	}
+
+	// Wrap the data key:
+	wrapped, err := b.Wrap(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	env, err := json.Marshal(envelope{
+		Version: envelopeVersion,
+		Backend: name,
+		Key:     wrapped,
+		Nonce:   nonce,
+		Data:    data,
+	})
+	if err != nil {
+		log.WithField("cmd", "secrets").Error(err)
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(env), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Open
+//-----------------------------------------------------------------------------
+
+// Open decrypts a bundle sealed by Seal.
+func Open(b Backend, sealed string) (map[string]string, error) {
+
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		log.WithField("cmd", "secrets").Error(err)
+		return nil, err
+	}
+
+	env := envelope{}
+	if err := json.Unmarshal(raw, &env); err != nil {
+		log.WithField("cmd", "secrets").Error(err)
+		return nil, err
+	}
+
+	if env.Version != envelopeVersion {
+		err := errors.New("unsupported secrets bundle version")
+		log.WithField("cmd", "secrets").Error(err)
+		return nil, err
+	}
+
+	// Unwrap the data key:
+	dataKey, err := b.Unwrap(env.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := decrypt(dataKey, env.Nonce, env.Data)
+	if err != nil {
+		log.WithField("cmd", "secrets").Error(err)
+		return nil, err
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		log.WithField("cmd", "secrets").Error(err)
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: encrypt
+//-----------------------------------------------------------------------------
+
+// encrypt seals plain with AES-256-GCM under key.
+func encrypt(key, plain []byte) (nonce, data []byte, err error) {
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return nonce, aead.Seal(nil, nonce, plain, nil), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: decrypt
+//-----------------------------------------------------------------------------
+
+func decrypt(key, nonce, data []byte) ([]byte, error) {
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	plain, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, errors.New("the secrets bundle does not open with this key")
+	}
+
+	return plain, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: newGCM
+//-----------------------------------------------------------------------------
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
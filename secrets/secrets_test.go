@@ -0,0 +1,293 @@
+package secrets
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// testSecrets is sealed by every test.
+var testSecrets = map[string]string{
+	"KATO_AGENT_DNS_PUBLISH_NS1_API_KEY":      "ns1-test-key",
+	"KATO_AGENT_DNS_PUBLISH_RFC2136_TSIG_KEY": "hmac-sha256:kato:c2VjcmV0",
+}
+
+//-----------------------------------------------------------------------------
+// func: TestFileRoundTrip
+//-----------------------------------------------------------------------------
+
+// A bundle sealed with a key derived from the CA opens on a host holding the
+// same key, and only there.
+func TestFileRoundTrip(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	caKey := filepath.Join(dir, "ca-key.pem")
+	writeCAKey(t, caKey)
+
+	// Seal where the user-data is rendered:
+	b, err := New(Config{Backend: "file", KeyFile: filepath.Join(dir, "pki", "secrets.key"), CAKeyFile: caKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := Seal(b, "file", testSecrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(sealed, "ns1-test-key") || strings.Contains(decode(t, sealed), "ns1-test-key") {
+		t.Fatal("the bundle holds the secrets in plain text")
+	}
+
+	// Open on the host, which only has the saved key:
+	b, err = New(Config{Backend: "file", KeyFile: filepath.Join(dir, "pki", "secrets.key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Open(b, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, testSecrets) {
+		t.Errorf("got %v, want %v", got, testSecrets)
+	}
+
+	// The derivation is stable:
+	key, err := DeriveKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved, _ := ReadKey(filepath.Join(dir, "pki", "secrets.key")); !reflect.DeepEqual(key, saved) {
+		t.Error("the saved key is not the one derived from the CA")
+	}
+
+	// Another key does not open it:
+	other := filepath.Join(dir, "other.key")
+	if err := WriteKey(other, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err = New(Config{Backend: "file", KeyFile: other}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(b, sealed); err == nil {
+		t.Error("opened with the wrong key")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestVaultRoundTrip
+//-----------------------------------------------------------------------------
+
+// The data key is wrapped and unwrapped by the transit engine of a stand-in
+// Vault server, and never sent in the bundle in the clear.
+func TestVaultRoundTrip(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv, calls := standInVault(t, "s.test-token", "kato")
+
+	b, err := New(Config{Backend: "vault", VaultAddr: srv + "/", VaultToken: "s.test-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := Seal(b, "vault", testSecrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := envelope{}
+	if err := json.Unmarshal([]byte(decode(t, sealed)), &env); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.Backend != "vault" || !strings.HasPrefix(env.Key, "vault:v1:") {
+		t.Errorf("unexpected envelope: %+v", env)
+	}
+
+	got, err := Open(b, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, testSecrets) {
+		t.Errorf("got %v, want %v", got, testSecrets)
+	}
+
+	if want := []string{"encrypt", "decrypt"}; !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls: got %v, want %v", *calls, want)
+	}
+
+	// A rejected token is an error:
+	if b, err = New(Config{Backend: "vault", VaultAddr: srv, VaultToken: "s.wrong"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(b, sealed); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("got %v, want permission denied", err)
+	}
+
+	// So is an unknown transit key:
+	if b, err = New(Config{Backend: "vault", VaultAddr: srv, VaultToken: "s.test-token", VaultKey: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Seal(b, "vault", testSecrets); err == nil {
+		t.Error("sealed with an unknown transit key")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestNew
+//-----------------------------------------------------------------------------
+
+func TestNew(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, c := range []Config{
+		{Backend: "none"},
+		{Backend: "file"},
+		{Backend: "vault", VaultAddr: "https://vault.example.com:8200"},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("accepted %+v", c)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: standInVault
+//-----------------------------------------------------------------------------
+
+// standInVault serves the encrypt and decrypt endpoints of the transit engine
+// for a single token and key. It returns its address and the calls it got.
+func standInVault(t *testing.T, token, key string) (string, *[]string) {
+
+	var mu sync.Mutex
+	var calls []string
+	wrapped := map[string]string{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		var op string
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/" + key:
+			op = "encrypt"
+		case "/v1/transit/decrypt/" + key:
+			op = "decrypt"
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["no such key"]}`))
+			return
+		}
+
+		body := map[string]string{}
+		if r.Method != "POST" || json.NewDecoder(r.Body).Decode(&body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		calls = append(calls, op)
+
+		res := map[string]map[string]string{"data": {}}
+		if op == "encrypt" {
+			id := "vault:v1:" + base64.StdEncoding.EncodeToString([]byte{byte(len(wrapped))})
+			wrapped[id] = body["plaintext"]
+			res["data"]["ciphertext"] = id
+		} else {
+			plain, ok := wrapped[body["ciphertext"]]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors":["invalid ciphertext"]}`))
+				return
+			}
+			res["data"]["plaintext"] = plain
+		}
+
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL, &calls
+}
+
+//-----------------------------------------------------------------------------
+// func: writeCAKey
+//-----------------------------------------------------------------------------
+
+func writeCAKey(t *testing.T, path string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: decode
+//-----------------------------------------------------------------------------
+
+func decode(t *testing.T, sealed string) string {
+
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(raw)
+}
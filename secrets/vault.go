@@ -0,0 +1,151 @@
+package secrets
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// vault wraps data keys with the transit engine of a Vault server, so the
+// key encryption key never leaves Vault.
+type vault struct {
+	addr   string
+	token  string
+	key    string
+	client *http.Client
+}
+
+type vaultResponse struct {
+	Data struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+//-----------------------------------------------------------------------------
+// func: newVault
+//-----------------------------------------------------------------------------
+
+func newVault(addr, token, key string) (*vault, error) {
+
+	if addr == "" || token == "" {
+		err := errors.New("the vault secrets backend needs an address and a token")
+		log.WithField("cmd", "secrets:vault").Error(err)
+		return nil, err
+	}
+
+	if key == "" {
+		key = "kato"
+	}
+
+	return &vault{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		key:    key,
+		client: http.DefaultClient,
+	}, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Wrap
+//-----------------------------------------------------------------------------
+
+// Wrap encrypts the data key with the transit key.
+func (v *vault) Wrap(dataKey []byte) (string, error) {
+
+	r, err := v.do("encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return r.Data.Ciphertext, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Unwrap
+//-----------------------------------------------------------------------------
+
+// Unwrap asks Vault to decrypt the data key.
+func (v *vault) Unwrap(wrapped string) ([]byte, error) {
+
+	r, err := v.do("decrypt", map[string]string{"ciphertext": wrapped})
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := base64.StdEncoding.DecodeString(r.Data.Plaintext)
+	if err != nil {
+		log.WithField("cmd", "secrets:vault").Error(err)
+		return nil, err
+	}
+
+	return dataKey, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: do
+//-----------------------------------------------------------------------------
+
+// do calls /v1/transit/<op>/<key>.
+func (v *vault) do(op string, body map[string]string) (*vaultResponse, error) {
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.WithField("cmd", "secrets:vault").Error(err)
+		return nil, err
+	}
+
+	// Forge the request:
+	req, err := http.NewRequest("POST", v.addr+"/v1/transit/"+op+"/"+v.key, bytes.NewReader(data))
+	if err != nil {
+		log.WithField("cmd", "secrets:vault").Error(err)
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send the request:
+	res, err := v.client.Do(req)
+	if err != nil {
+		log.WithField("cmd", "secrets:vault").Error(err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	r := &vaultResponse{}
+	if err := json.NewDecoder(res.Body).Decode(r); err != nil && res.StatusCode == http.StatusOK {
+		log.WithField("cmd", "secrets:vault").Error(err)
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		msg := res.Status
+		if len(r.Errors) > 0 {
+			msg = strings.Join(r.Errors, "; ")
+		}
+		err := errors.New("vault: " + op + ": " + msg)
+		log.WithField("cmd", "secrets:vault").Error(err)
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,292 @@
+package spec
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	// Local:
+	"github.com/h0tbird/kato/providers/ec2"
+	"github.com/h0tbird/kato/providers/pkt"
+	"github.com/h0tbird/kato/udata"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// Version is the only cluster spec version understood by this katoctl.
+const Version = "v1"
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Role describes how many instances of a role to deploy and their size.
+type Role struct {
+	Count int    `yaml:"count"`
+	Type  string `yaml:"type"`
+}
+
+// Network contains the VPC addressing plan.
+type Network struct {
+	VpcCidrBlock  string `yaml:"vpcCidrBlock"`
+	IntSubnetCidr string `yaml:"internalSubnetCidr"`
+	ExtSubnetCidr string `yaml:"externalSubnetCidr"`
+}
+
+// Flannel contains the overlay network settings.
+type Flannel struct {
+	Network   string `yaml:"network"`
+	SubnetLen string `yaml:"subnetLen"`
+	SubnetMin string `yaml:"subnetMin"`
+	SubnetMax string `yaml:"subnetMax"`
+	Backend   string `yaml:"backend"`
+}
+
+// Packet contains the Packet.net specific settings.
+type Packet struct {
+	APIKey    string `yaml:"apiKey"`
+	ProjectID string `yaml:"projectID"`
+	Plan      string `yaml:"plan"`
+	OS        string `yaml:"os"`
+	Facility  string `yaml:"facility"`
+	Billing   string `yaml:"billing"`
+}
+
+// Cluster is a declarative description of a Kato cluster.
+type Cluster struct {
+	Version          string   `yaml:"version"`
+	Provider         string   `yaml:"provider"`
+	Domain           string   `yaml:"domain"`
+	Region           string   `yaml:"region"`
+	Channel          string   `yaml:"channel"`
+	KeyPair          string   `yaml:"keyPair"`
+	EtcdToken        string   `yaml:"etcdToken"`
+	EtcdDiscoveryURL string   `yaml:"etcdDiscoveryURL"`
+	EtcdTLS          bool     `yaml:"etcdTLS"`
+	DNSProvider      string   `yaml:"dnsProvider"`
+	Ns1ApiKey        string   `yaml:"ns1ApiKey"`
+	Rfc2136Server    string   `yaml:"rfc2136Server"`
+	Rfc2136TSIGKey   string   `yaml:"rfc2136TSIGKey"`
+	KatoctlURL       string   `yaml:"katoctlURL"`
+	KatoctlSHA256    string   `yaml:"katoctlSHA256"`
+	CaCert           string   `yaml:"caCert"`
+	VersionsFile     string   `yaml:"versionsFile"`
+	RolesFile        string   `yaml:"rolesFile"`
+	TemplatesDir     string   `yaml:"templatesDir"`
+	SecretsBackend   string   `yaml:"secretsBackend"`
+	SecretsKeyFile   string   `yaml:"secretsKeyFile"`
+	VaultAddr        string   `yaml:"vaultAddr"`
+	VaultTransitKey  string   `yaml:"vaultTransitKey"`
+	ExtraFiles       []string `yaml:"extraFiles"`
+	ExtraUnits       []string `yaml:"extraUnits"`
+	SSHKeys          []string `yaml:"sshKeys"`
+	Master           Role     `yaml:"master"`
+	Node             Role     `yaml:"node"`
+	Edge             Role     `yaml:"edge"`
+	Network          Network  `yaml:"network"`
+	Flannel          Flannel  `yaml:"flannel"`
+	Packet           Packet   `yaml:"packet"`
+}
+
+//-----------------------------------------------------------------------------
+// func: Load
+//-----------------------------------------------------------------------------
+
+// Load reads a cluster spec file. Relative paths inside the spec are
+// resolved against the directory holding the file.
+func Load(path string) (*Cluster, error) {
+
+	// Read the spec file:
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithField("cmd", "spec").Error(err)
+		return nil, err
+	}
+
+	// Decode YAML into Go values, rejecting unknown keys:
+	c := &Cluster{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		log.WithField("cmd", "spec").Error(err)
+		return nil, err
+	}
+
+	// Check the spec version:
+	if c.Version != Version {
+		err := fmt.Errorf("%s: unsupported spec version %q (want %q)",
+			path, c.Version, Version)
+		log.WithField("cmd", "spec").Error(err)
+		return nil, err
+	}
+
+	// Resolve the paths of the files referenced by the spec:
+	for _, p := range []*string{&c.CaCert, &c.VersionsFile, &c.RolesFile, &c.TemplatesDir, &c.SecretsKeyFile} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(filepath.Dir(path), *p)
+		}
+	}
+
+	// Extra files are src:dest[:mode], only src is resolved:
+	for i, f := range c.ExtraFiles {
+		src, _, _, err := udata.ParseExtraFile(f)
+		if err != nil {
+			err = errors.New(path + ": " + err.Error())
+			log.WithField("cmd", "spec").Error(err)
+			return nil, err
+		}
+		if !filepath.IsAbs(src) {
+			c.ExtraFiles[i] = filepath.Join(filepath.Dir(path), src) + f[len(src):]
+		}
+	}
+
+	// SSH keys may be inline:
+	for i, u := range c.ExtraUnits {
+		if !filepath.IsAbs(u) {
+			c.ExtraUnits[i] = filepath.Join(filepath.Dir(path), u)
+		}
+	}
+	for i, k := range c.SSHKeys {
+		if !udata.IsSSHKey(k) && !filepath.IsAbs(k) {
+			c.SSHKeys[i] = filepath.Join(filepath.Dir(path), k)
+		}
+	}
+
+	return c, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Validate
+//-----------------------------------------------------------------------------
+
+// Validate checks that all the fields required by the provider are set.
+func (c *Cluster) Validate() error {
+
+	var missing []string
+
+	// Fields required by every provider:
+	req := map[string]string{
+		"domain": c.Domain,
+	}
+
+	// Provider specific fields:
+	switch c.Provider {
+	case "ec2":
+		req["region"] = c.Region
+		req["channel"] = c.Channel
+		req["key-pair"] = c.KeyPair
+		switch c.DNSProvider {
+		case "", "ns1":
+			req["ns1-api-key"] = c.Ns1ApiKey
+		case "rfc2136":
+			req["rfc2136-server"] = c.Rfc2136Server
+		}
+		if c.SecretsBackend == "vault" {
+			req["vault-addr"] = c.VaultAddr
+		}
+		if c.Master.Count < 1 {
+			missing = append(missing, "master-count")
+		}
+	case "packet":
+		req["api-key"] = c.Packet.APIKey
+		req["project-id"] = c.Packet.ProjectID
+	default:
+		err := fmt.Errorf("unknown provider %q", c.Provider)
+		log.WithField("cmd", "spec").Error(err)
+		return err
+	}
+
+	for k, v := range req {
+		if v == "" {
+			missing = append(missing, k)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		err := errors.New("missing required values: " + fmt.Sprint(missing))
+		log.WithField("cmd", "spec").Error(err)
+		return err
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: EC2
+//-----------------------------------------------------------------------------
+
+// EC2 returns the EC2 provider data described by the spec.
+func (c *Cluster) EC2() *ec2.Data {
+	return &ec2.Data{
+		MasterCount:      c.Master.Count,
+		NodeCount:        c.Node.Count,
+		EdgeCount:        c.Edge.Count,
+		MasterType:       c.Master.Type,
+		NodeType:         c.Node.Type,
+		EdgeType:         c.Edge.Type,
+		Channel:          c.Channel,
+		EtcdToken:        c.EtcdToken,
+		EtcdDiscoveryURL: c.EtcdDiscoveryURL,
+		EtcdTLS:          c.EtcdTLS,
+		DNSProvider:      c.DNSProvider,
+		Ns1ApiKey:        c.Ns1ApiKey,
+		Rfc2136Server:    c.Rfc2136Server,
+		Rfc2136TSIGKey:   c.Rfc2136TSIGKey,
+		KatoctlURL:       c.KatoctlURL,
+		KatoctlSHA256:    c.KatoctlSHA256,
+		CaCert:           c.CaCert,
+		VersionsFile:     c.VersionsFile,
+		RolesFile:        c.RolesFile,
+		TemplatesDir:     c.TemplatesDir,
+		SecretsBackend:   c.SecretsBackend,
+		SecretsKeyFile:   c.SecretsKeyFile,
+		VaultAddr:        c.VaultAddr,
+		VaultTransitKey:  c.VaultTransitKey,
+		ExtraFiles:       c.ExtraFiles,
+		ExtraUnits:       c.ExtraUnits,
+		SSHKeys:          c.SSHKeys,
+		Domain:           c.Domain,
+		Region:           c.Region,
+		KeyPair:          c.KeyPair,
+		VpcCidrBlock:     c.Network.VpcCidrBlock,
+		IntSubnetCidr:    c.Network.IntSubnetCidr,
+		ExtSubnetCidr:    c.Network.ExtSubnetCidr,
+		FlannelNetwork:   c.Flannel.Network,
+		FlannelSubnetLen: c.Flannel.SubnetLen,
+		FlannelSubnetMin: c.Flannel.SubnetMin,
+		FlannelSubnetMax: c.Flannel.SubnetMax,
+		FlannelBackend:   c.Flannel.Backend,
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: Pkt
+//-----------------------------------------------------------------------------
+
+// Pkt returns the Packet.net provider data described by the spec.
+func (c *Cluster) Pkt() *pkt.Data {
+	return &pkt.Data{
+		APIKey:    c.Packet.APIKey,
+		ProjectID: c.Packet.ProjectID,
+		Plan:      c.Packet.Plan,
+		OS:        c.Packet.OS,
+		Facility:  c.Packet.Facility,
+		Billing:   c.Packet.Billing,
+	}
+}
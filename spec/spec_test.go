@@ -0,0 +1,70 @@
+package spec
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// func: TestLoadExtraFiles
+//-----------------------------------------------------------------------------
+
+// Only the source of an extra file is relative to the spec, the destination
+// and the mode are taken as written.
+func TestLoadExtraFiles(t *testing.T) {
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cluster.yaml")
+
+	spec := `version: v1
+provider: ec2
+domain: cell-1.dc-1.example.com
+extraFiles:
+  - files/x:/etc/x/../y:0600
+  - ../shared/z.conf:/etc/z.conf
+  - /srv/kato/w:/etc/w
+`
+	if err := ioutil.WriteFile(path, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "files", "x") + ":/etc/x/../y:0600",
+		filepath.Join(filepath.Dir(dir), "shared", "z.conf") + ":/etc/z.conf",
+		"/srv/kato/w:/etc/w",
+	}
+
+	if !reflect.DeepEqual(c.ExtraFiles, want) {
+		t.Errorf("got %q, want %q", c.ExtraFiles, want)
+	}
+
+	// A malformed entry names the spec:
+	spec += "  - files/v\n"
+	if err := ioutil.WriteFile(path, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Error("accepted an extra file without a destination")
+	}
+}
@@ -0,0 +1,298 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// Resource kinds shared by several commands:
+const (
+	Instance = "instance"
+	Device   = "device"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Resource is a single piece of infrastructure created by katoctl.
+type Resource struct {
+	Kind    string    `json:"kind"`
+	Role    string    `json:"role,omitempty"`
+	ID      string    `json:"id"`
+	Name    string    `json:"name,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Cluster is the persistent state of a cluster, keyed by its domain.
+type Cluster struct {
+	Domain    string     `json:"domain"`
+	Provider  string     `json:"provider,omitempty"`
+	Region    string     `json:"region,omitempty"`
+	Resources []Resource `json:"resources"`
+
+	dir string
+	mu  sync.Mutex
+}
+
+//-----------------------------------------------------------------------------
+// func: DefaultDir
+//-----------------------------------------------------------------------------
+
+// DefaultDir returns the default state directory.
+func DefaultDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".kato")
+}
+
+//-----------------------------------------------------------------------------
+// func: Open
+//-----------------------------------------------------------------------------
+
+// Open loads the state of the cluster identified by domain from the state
+// directory dir. A new empty state is returned if none exists yet.
+func Open(dir, domain string) (*Cluster, error) {
+
+	c := &Cluster{Domain: domain, dir: filepath.Join(dir, domain)}
+
+	// Create the cluster directory:
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		log.WithField("cmd", "state").Error(err)
+		return nil, err
+	}
+
+	// Load the current state:
+	if err := c.load(); err != nil {
+		log.WithField("cmd", "state").Error(err)
+		return nil, err
+	}
+
+	return c, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Dir
+//-----------------------------------------------------------------------------
+
+// Dir returns the directory holding the cluster state and its companions.
+func (c *Cluster) Dir() string {
+	return c.dir
+}
+
+//-----------------------------------------------------------------------------
+// func: SetProvider
+//-----------------------------------------------------------------------------
+
+// SetProvider records the provider and region hosting the cluster.
+func (c *Cluster) SetProvider(provider, region string) error {
+	return c.update(func() {
+		c.Provider = provider
+		c.Region = region
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: Record
+//-----------------------------------------------------------------------------
+
+// Record adds a resource to the state, or refreshes it if the same kind and
+// ID is already known (keeping its creation time), and persists the state.
+func (c *Cluster) Record(r Resource) error {
+
+	if r.Created.IsZero() {
+		r.Created = time.Now().UTC()
+	}
+
+	return c.update(func() {
+		for i, v := range c.Resources {
+			if v.Kind == r.Kind && v.ID == r.ID {
+				r.Created = v.Created
+				c.Resources[i] = r
+				return
+			}
+		}
+		c.Resources = append(c.Resources, r)
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: Forget
+//-----------------------------------------------------------------------------
+
+// Forget removes a resource from the state and persists the state.
+func (c *Cluster) Forget(kind, id string) error {
+	return c.update(func() {
+		for i, v := range c.Resources {
+			if v.Kind == kind && v.ID == id {
+				c.Resources = append(c.Resources[:i], c.Resources[i+1:]...)
+				return
+			}
+		}
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: Find
+//-----------------------------------------------------------------------------
+
+// Find returns all the resources of the given kind. An empty role matches
+// any role.
+func (c *Cluster) Find(kind, role string) []Resource {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var res []Resource
+	for _, v := range c.Resources {
+		if v.Kind == kind && (role == "" || v.Role == role) {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+//-----------------------------------------------------------------------------
+// func: Lookup
+//-----------------------------------------------------------------------------
+
+// Lookup returns the ID of the most recently recorded resource of the given
+// kind and role, or an empty string if there is none.
+func (c *Cluster) Lookup(kind, role string) string {
+
+	res := c.Find(kind, role)
+	if len(res) == 0 {
+		return ""
+	}
+
+	return res[len(res)-1].ID
+}
+
+//-----------------------------------------------------------------------------
+// func: Remove
+//-----------------------------------------------------------------------------
+
+// Remove deletes the persisted state of the cluster.
+func (c *Cluster) Remove() error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Remove(c.file()); err != nil && !os.IsNotExist(err) {
+		log.WithField("cmd", "state").Error(err)
+		return err
+	}
+
+	c.Resources = nil
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: update
+//-----------------------------------------------------------------------------
+
+// update applies fn to the freshest on-disk state while holding both the
+// in-process and the cross-process locks, then writes the result back.
+func (c *Cluster) update(fn func()) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Lock the state across processes:
+	lock, err := os.OpenFile(filepath.Join(c.dir, "state.lock"),
+		os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		log.WithField("cmd", "state").Error(err)
+		return err
+	}
+	defer lock.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		log.WithField("cmd", "state").Error(err)
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	// Reload, apply and save:
+	provider, region := c.Provider, c.Region
+	if err := c.load(); err != nil {
+		log.WithField("cmd", "state").Error(err)
+		return err
+	}
+
+	if c.Provider == "" {
+		c.Provider, c.Region = provider, region
+	}
+
+	fn()
+
+	if err := c.save(); err != nil {
+		log.WithField("cmd", "state").Error(err)
+		return err
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: load
+//-----------------------------------------------------------------------------
+
+func (c *Cluster) load() error {
+
+	data, err := ioutil.ReadFile(c.file())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return json.Unmarshal(data, c)
+}
+
+//-----------------------------------------------------------------------------
+// func: save
+//-----------------------------------------------------------------------------
+
+func (c *Cluster) save() error {
+
+	// Marshal the data:
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and atomically replace the state:
+	tmp := c.file() + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.file())
+}
+
+//-----------------------------------------------------------------------------
+// func: file
+//-----------------------------------------------------------------------------
+
+func (c *Cluster) file() string {
+	return filepath.Join(c.dir, "state.json")
+}
@@ -0,0 +1,152 @@
+package udata
+
+//---------------------------------------------------------------------------
+// CoreOS user data layout, shaped by the role of the host:
+//---------------------------------------------------------------------------
+
+// templCloudConfig lays out the fragments defined in files.go, fleet.go and
+// units.go. A fragment renders whole lines, without a trailing newline, and
+// any of them can be replaced from a templates directory.
+const templCloudConfig = `{{define "cloud-config"}}#cloud-config
+
+hostname: "{{.Role}}-{{.HostID}}.{{.Domain}}"
+{{- if .SSHAuthorizedKeys}}
+
+ssh_authorized_keys:
+{{- range .SSHAuthorizedKeys}}
+ - {{printf "%q" .}}
+{{- end}}
+{{- end}}
+
+write_files:
+
+{{template "hosts" .}}
+
+{{template "resolv" .}}
+
+{{template "kato-env" .}}
+{{- if .CaCert}}
+
+{{template "docker-ca" .}}
+{{- end}}
+{{- if .HostCert}}
+
+{{template "pki" .}}
+{{- end}}
+{{- if .EtcdTLS}}
+
+{{template "etcdctl" .}}
+{{- end}}
+
+{{template "docker" .}}
+{{- if .Fragment "rexray"}}
+
+{{template "rexray-config" .}}
+{{- end}}
+
+{{template "bashrc" .}}
+
+{{template "sshd" .}}
+{{- if ne .DNSProvider "none"}}
+
+{{template "dns-env" .}}
+{{- end}}
+{{- if .SealedSecrets}}
+
+{{template "secrets" .}}
+{{- end}}
+{{- if .FleetUnit "zookeeper"}}
+
+{{template "fleet-zookeeper" .}}
+{{- end}}
+{{- if .FleetUnit "mesos-master"}}
+
+{{template "fleet-mesos-master" .}}
+{{- end}}
+{{- if .FleetUnit "mesos-node"}}
+
+{{template "fleet-mesos-node" .}}
+{{- end}}
+{{- if .FleetUnit "mesos-dns"}}
+
+{{template "fleet-mesos-dns" .}}
+{{- end}}
+{{- if .FleetUnit "marathon"}}
+
+{{template "fleet-marathon" .}}
+{{- end}}
+{{- if .FleetUnit "marathon-lb"}}
+
+{{template "fleet-marathon-lb" .}}
+{{- end}}
+{{- if .FleetUnit "cadvisor"}}
+
+{{template "fleet-cadvisor" .}}
+{{- end}}
+{{- if .FleetUnit "dnsmasq"}}
+
+{{template "fleet-dnsmasq" .}}
+{{- end}}
+{{- if .FleetUnit "mongodb"}}
+
+{{template "fleet-mongodb" .}}
+{{- end}}
+{{- if .FleetUnit "pritunl"}}
+
+{{template "fleet-pritunl" .}}
+{{- end}}
+{{- range .ExtraFleetUnits}}
+
+ - path: "/etc/fleet/{{.Name}}"
+   content: |
+    {{.Content}}
+{{- end}}
+{{- range .UserFiles}}
+
+ - path: "{{.Path}}"
+   permissions: "{{.Mode}}"
+   content: {{.Content}}
+{{- end}}
+
+coreos:
+
+ units:
+
+{{template "unit-etcd2" .}}
+
+{{template "unit-fleet" .}}
+{{- if .Fragment "flannel"}}
+
+{{template "unit-flanneld" .}}
+{{- end}}
+
+{{template "unit-katoctl" .}}
+{{- if ne .DNSProvider "none"}}
+{{- if .SealedSecrets}}
+
+{{template "unit-secrets" .}}
+{{- end}}
+
+{{template "unit-dns-publish" .}}
+{{- end}}
+
+{{template "unit-hosts-sync" .}}
+{{- if .Fragment "docker-gc"}}
+
+{{template "unit-docker-gc" .}}
+{{- end}}
+{{- if .Fragment "rexray"}}
+
+{{template "unit-rexray" .}}
+{{- end}}
+{{- range .UserUnits}}
+
+  - name: "{{.Name}}"
+    command: "start"
+    content: {{.Content}}
+{{- end}}
+
+{{template "fleet" .}}
+
+{{template "etcd2" .}}
+{{end}}`
@@ -0,0 +1,197 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// UserFile is a file added to write_files with --extra-file.
+type UserFile struct {
+	Path    string
+	Mode    string
+	Content string // A YAML block or quoted scalar.
+}
+
+//-----------------------------------------------------------------------------
+// func: ParseExtraFile
+//-----------------------------------------------------------------------------
+
+// ParseExtraFile splits a src:dest[:mode] extra file specification. The mode
+// defaults to 0644.
+func ParseExtraFile(s string) (src, dest, mode string, err error) {
+
+	p := strings.Split(s, ":")
+	if len(p) < 2 || len(p) > 3 || p[0] == "" {
+		return "", "", "", errors.New("extra file is not src:dest[:mode]: " + s)
+	}
+
+	src, dest, mode = p[0], p[1], "0644"
+	if len(p) == 3 {
+		mode = p[2]
+	}
+
+	if !filepath.IsAbs(dest) {
+		return "", "", "", errors.New("extra file destination must be absolute: " + s)
+	}
+
+	if !octalString.MatchString(mode) {
+		return "", "", "", errors.New("extra file mode must be octal: " + s)
+	}
+
+	return src, dest, mode, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: IsSSHKey
+//-----------------------------------------------------------------------------
+
+// IsSSHKey tells an inline public key apart from a path to a key file.
+func IsSSHKey(s string) bool {
+	for _, p := range []string{"ssh-", "ecdsa-", "sk-"} {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: extras
+//-----------------------------------------------------------------------------
+
+// extras reads the user files, units and SSH keys merged into any role.
+func (d *Data) extras() error {
+
+	// Files:
+	d.UserFiles = nil
+	for _, s := range d.ExtraFiles {
+		src, dest, mode, err := ParseExtraFile(s)
+		if err != nil {
+			log.WithField("cmd", "udata").Error(err)
+			return err
+		}
+		content, err := readBlock(src, "    ")
+		if err != nil {
+			return err
+		}
+		d.UserFiles = append(d.UserFiles, UserFile{Path: dest, Mode: mode, Content: content})
+	}
+
+	// Units:
+	d.UserUnits = nil
+	for _, u := range d.ExtraUnits {
+		name := filepath.Base(u)
+		if !unitName.MatchString(name) {
+			err := errors.New("invalid unit name: " + name)
+			log.WithField("cmd", "udata").Error(err)
+			return err
+		}
+		content, err := readBlock(u, "     ")
+		if err != nil {
+			return err
+		}
+		d.UserUnits = append(d.UserUnits, UnitFile{Name: name, Content: content})
+	}
+
+	// SSH keys:
+	d.SSHAuthorizedKeys = nil
+	for _, k := range d.SSHKeys {
+		keys, err := readSSHKeys(k)
+		if err != nil {
+			return err
+		}
+		d.SSHAuthorizedKeys = append(d.SSHAuthorizedKeys, keys...)
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: readBlock
+//-----------------------------------------------------------------------------
+
+// readBlock returns the content of a text file as a YAML block scalar with
+// its lines indented by prefix. Content a block can't hold verbatim, such as
+// a leading blank or an empty file, is double-quoted instead.
+func readBlock(path, prefix string) (string, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return "", err
+	}
+
+	if !utf8.Valid(data) {
+		err = errors.New("not a text file: " + path)
+		log.WithField("cmd", "udata").Error(err)
+		return "", err
+	}
+
+	s := string(data)
+	if s == "" || strings.ContainsAny(s[:1], " \t\n") || !strings.HasSuffix(s, "\n") ||
+		strings.HasSuffix(s, "\n\n") || strings.Contains(s, "\r") {
+		return strconv.Quote(s), nil
+	}
+
+	return "|\n" + prefix + strings.Replace(strings.TrimSuffix(s, "\n"), "\n", "\n"+prefix, -1), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: readSSHKeys
+//-----------------------------------------------------------------------------
+
+// readSSHKeys returns an inline key as is, or every key of a key file.
+func readSSHKeys(s string) ([]string, error) {
+
+	if IsSSHKey(s) {
+		return []string{s}, nil
+	}
+
+	data, err := ioutil.ReadFile(s)
+	if err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return nil, err
+	}
+
+	var keys []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !IsSSHKey(line) {
+			err = errors.New("not an SSH public key in " + s + ": " + line)
+			log.WithField("cmd", "udata").Error(err)
+			return nil, err
+		}
+		keys = append(keys, line)
+	}
+
+	if len(keys) == 0 {
+		err = errors.New("no SSH public key in " + s)
+		log.WithField("cmd", "udata").Error(err)
+		return nil, err
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,130 @@
+package udata
+
+//---------------------------------------------------------------------------
+// Fragments written to write_files:
+//---------------------------------------------------------------------------
+
+const templFiles = `{{define "hosts"}} - path: "/etc/hosts"
+   content: |
+    127.0.0.1 localhost
+    $private_ipv4 {{.Role}}-{{.HostID}}.{{.Domain}} {{.Role}}-{{.HostID}}{{.HostAliases}}
+    $private_ipv4 {{.Role}}-{{.HostID}}.int.{{.Domain}} {{.Role}}-{{.HostID}}.int
+
+ - path: "/etc/.hosts"
+   content: |
+    127.0.0.1 localhost
+    $private_ipv4 {{.Role}}-{{.HostID}}.{{.Domain}} {{.Role}}-{{.HostID}}{{.HostAliases}}
+    $private_ipv4 {{.Role}}-{{.HostID}}.int.{{.Domain}} {{.Role}}-{{.HostID}}.int
+{{- end}}
+
+{{define "resolv"}} - path: "/etc/resolv.conf"
+   content: |
+    search {{.Domain}}
+    nameserver 8.8.8.8
+{{- end}}
+
+{{define "kato-env"}} - path: "/etc/kato.env"
+   content: |
+    KATO_MASTER_COUNT={{.MasterCount}}
+    KATO_ROLE={{.Role}}
+    KATO_HOST_ID={{.HostID}}
+    KATO_ZK={{.ZkServers}}
+{{- if .MesosAttributes}}
+    KATO_MESOS_ATTRIBUTES={{.MesosAttributes}}
+{{- end}}
+{{- end}}
+
+{{define "docker-ca"}} - path: "/etc/docker/certs.d/internal-registry-sys.marathon:5000/ca.crt"
+   content: |
+    {{.CaCert}}
+{{- end}}
+
+{{define "pki"}} - path: "/etc/kato/pki/ca.crt"
+   content: |
+    {{.ClusterCA}}
+
+ - path: "/etc/kato/pki/host.crt"
+   content: |
+    {{.HostCert}}
+
+ - path: "/etc/kato/pki/host.key"
+   permissions: "0600"
+   content: |
+    {{.HostKey}}
+{{- end}}
+
+{{define "etcdctl"}} - path: "/etc/systemd/system.conf.d/50-etcd-tls.conf"
+   content: |
+    [Manager]
+    DefaultEnvironment=ETCDCTL_ENDPOINT=https://127.0.0.1:2379 ETCDCTL_CA_FILE=/etc/kato/pki/ca.crt ETCDCTL_CERT_FILE=/etc/kato/pki/host.crt ETCDCTL_KEY_FILE=/etc/kato/pki/host.key
+
+ - path: "/etc/profile.d/etcdctl.sh"
+   content: |
+    export ETCDCTL_ENDPOINT=https://127.0.0.1:2379
+    export ETCDCTL_CA_FILE=/etc/kato/pki/ca.crt
+    export ETCDCTL_CERT_FILE=/etc/kato/pki/host.crt
+    export ETCDCTL_KEY_FILE=/etc/kato/pki/host.key
+{{- end}}
+
+{{define "docker"}} - path: "/etc/systemd/system/docker.service.d/50-docker-opts.conf"
+   content: |
+    [Service]
+    Environment='DOCKER_OPTS=--registry-mirror=http://external-registry-sys.marathon:5000'
+{{- end}}
+
+{{define "rexray-config"}} - path: "/etc/rexray/rexray.env"
+
+ - path: "/etc/rexray/config.yml"
+{{- if .RexrayStorageDriver }}
+   content: |
+    rexray:
+      storageDrivers:
+      - {{.RexrayStorageDriver}}
+
+    {{.RexrayConfigSnippet}}
+{{- end}}
+{{- end}}
+
+{{define "bashrc"}} - path: "/home/core/.bashrc"
+   owner: "core:core"
+   content: |
+    [[ $- != *i* ]] && return
+    alias ls='ls -hF --color=auto --group-directories-first'
+    alias l='ls -l'
+    alias ll='ls -la'
+    alias grep='grep --color=auto'
+    alias dim='docker images'
+    alias dps='docker ps'
+    alias drm='docker rm -v $(docker ps -qaf status=exited)'
+    alias drmi='docker rmi $(docker images -qf dangling=true)'
+    alias drmv='docker volume rm $(docker volume ls -qf dangling=true)'
+{{- end}}
+
+{{define "sshd"}} - path: "/etc/ssh/sshd_config"
+   permissions: "0600"
+   content: |
+    UsePrivilegeSeparation sandbox
+    Subsystem sftp internal-sftp
+    ClientAliveInterval 180
+    UseDNS no
+    PermitRootLogin no
+    AllowUsers core
+    PasswordAuthentication no
+    ChallengeResponseAuthentication no
+{{- end}}
+
+{{define "dns-env"}} - path: "/etc/kato/dns.env"
+   permissions: "0600"
+   content: |
+    KATO_AGENT_DNS_PUBLISH_PROVIDER={{.DNSProvider}}
+    KATO_AGENT_DNS_PUBLISH_NS1_API_KEY={{.Ns1ApiKey}}
+    KATO_AGENT_DNS_PUBLISH_RFC2136_SERVER={{.Rfc2136Server}}
+    KATO_AGENT_DNS_PUBLISH_RFC2136_TSIG_KEY={{.Rfc2136TSIGKey}}
+{{- end}}
+
+{{define "secrets"}} - path: "/etc/kato/secrets.sealed"
+   permissions: "0600"
+   content: |
+    {{.SealedSecrets}}
+{{- end}}
+`
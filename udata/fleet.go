@@ -0,0 +1,376 @@
+package udata
+
+//---------------------------------------------------------------------------
+// Fleet units shipped in /etc/fleet:
+//---------------------------------------------------------------------------
+
+const templFleetUnits = `{{define "fleet-zookeeper"}} - path: "/etc/fleet/zookeeper.service"
+   content: |
+    [Unit]
+    Description=Zookeeper
+    After=docker.service
+    Requires=docker.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    EnvironmentFile=/etc/kato.env
+    ExecStartPre=-/usr/bin/docker kill zookeeper
+    ExecStartPre=-/usr/bin/docker rm zookeeper
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.Zookeeper}}
+    ExecStart=/usr/bin/sh -c "docker run \
+      --net host \
+      --name zookeeper \
+      --env ZK_SERVER_ID=${KATO_HOST_ID} \
+      --env ZK_TICK_TIME=2000 \
+      --env ZK_INIT_LIMIT=5 \
+      --env ZK_SYNC_LIMIT=2 \
+      --env ZK_SERVERS=$${KATO_ZK//:2181/} \
+      --env ZK_DATA_DIR=/var/lib/zookeeper \
+      --env ZK_CLIENT_PORT=2181 \
+      --env ZK_CLIENT_PORT_ADDRESS=$(hostname -i) \
+      --env JMXDISABLE=true \
+      {{.Versions.Zookeeper}}"
+    ExecStop=/usr/bin/docker stop -t 5 zookeeper
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+    MachineMetadata=role=master
+{{- end}}
+
+{{define "fleet-mesos-master"}} - path: "/etc/fleet/mesos-master.service"
+   content: |
+    [Unit]
+    Description=Mesos Master
+    After=docker.service zookeeper.service
+    Requires=docker.service zookeeper.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    EnvironmentFile=/etc/kato.env
+    ExecStartPre=-/usr/bin/docker kill mesos-master
+    ExecStartPre=-/usr/bin/docker rm mesos-master
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.MesosMaster}}
+    ExecStart=/usr/bin/sh -c "docker run \
+      --privileged \
+      --name mesos-master \
+      --net host \
+      --volume /var/lib/mesos:/var/lib/mesos \
+      --volume /etc/resolv.conf:/etc/resolv.conf \
+      {{.Versions.MesosMaster}} \
+      --ip=$(hostname -i) \
+      --zk=zk://${KATO_ZK}/mesos \
+      --work_dir=/var/lib/mesos/master \
+      --log_dir=/var/log/mesos \
+      --quorum=$(($KATO_MASTER_COUNT/2 + 1))"
+    ExecStop=/usr/bin/docker stop -t 5 mesos-master
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+    MachineMetadata=role=master
+{{- end}}
+
+{{define "fleet-mesos-node"}} - path: "/etc/fleet/mesos-node.service"
+   content: |
+    [Unit]
+    Description=Mesos Node
+    After=docker.service dnsmasq.service
+    Wants=dnsmasq.service
+    Requires=docker.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    EnvironmentFile=/etc/kato.env
+    ExecStartPre=-/usr/bin/docker kill mesos-node
+    ExecStartPre=-/usr/bin/docker rm mesos-node
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.MesosSlave}}
+    ExecStart=/usr/bin/sh -c "docker run \
+      --privileged \
+      --name mesos-node \
+      --net host \
+      --pid host \
+      --volume /sys:/sys \
+      --volume /etc/resolv.conf:/etc/resolv.conf \
+      --volume /usr/bin/docker:/usr/bin/docker:ro \
+      --volume /var/run/docker.sock:/var/run/docker.sock \
+      --volume /lib64/libdevmapper.so.1.02:/lib/libdevmapper.so.1.02:ro \
+      --volume /lib64/libsystemd.so.0:/lib/libsystemd.so.0:ro \
+      --volume /lib64/libgcrypt.so.20:/lib/libgcrypt.so.20:ro \
+      --volume /var/lib/mesos:/var/lib/mesos \
+      {{.Versions.MesosSlave}} \
+      --ip=$(hostname -i) \
+      --containerizers=docker \
+      --executor_registration_timeout=2mins \
+      --master=zk://${KATO_ZK}/mesos \
+      --work_dir=/var/lib/mesos/node \
+      --log_dir=/var/log/mesos/node \
+      $${KATO_MESOS_ATTRIBUTES:+--attributes=$${KATO_MESOS_ATTRIBUTES}}"
+    ExecStop=/usr/bin/docker stop -t 5 mesos-node
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+    MachineMetadata=role=node
+{{- end}}
+
+{{define "fleet-mesos-dns"}} - path: "/etc/fleet/mesos-dns.service"
+   content: |
+    [Unit]
+    Description=Mesos DNS
+    After=docker.service zookeeper.service mesos-master.service
+    Requires=docker.service zookeeper.service mesos-master.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    EnvironmentFile=/etc/kato.env
+    ExecStartPre=-/usr/bin/docker kill mesos-dns
+    ExecStartPre=-/usr/bin/docker rm mesos-dns
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.MesosDNS}}
+    ExecStart=/usr/bin/sh -c "docker run \
+      --name mesos-dns \
+      --net host \
+      --env MDNS_ZK=zk://${KATO_ZK}/mesos \
+      --env MDNS_REFRESHSECONDS=45 \
+      --env MDNS_LISTENER=$(hostname -i) \
+      --env MDNS_HTTPON=false \
+      --env MDNS_TTL=45 \
+      --env MDNS_RESOLVERS=8.8.8.8 \
+      --env MDNS_DOMAIN=$(hostname -d | cut -d. -f-2).mesos \
+      --env MDNS_IPSOURCE=netinfo \
+      {{.Versions.MesosDNS}}"
+    ExecStartPost=/usr/bin/sh -c ' \
+      echo search $(hostname -d | cut -d. -f-2).mesos $(hostname -d) > /etc/resolv.conf && \
+      echo "nameserver $(hostname -i)" >> /etc/resolv.conf'
+    ExecStop=/usr/bin/sh -c ' \
+      echo search $(hostname -d) > /etc/resolv.conf && \
+      echo "nameserver 8.8.8.8" >> /etc/resolv.conf'
+    ExecStop=/usr/bin/docker stop -t 5 mesos-dns
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+    MachineMetadata=role=master
+{{- end}}
+
+{{define "fleet-marathon"}} - path: "/etc/fleet/marathon.service"
+   content: |
+    [Unit]
+    Description=Marathon
+    After=docker.service zookeeper.service mesos-master.service
+    Requires=docker.service zookeeper.service mesos-master.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    EnvironmentFile=/etc/kato.env
+    ExecStartPre=-/usr/bin/docker kill marathon
+    ExecStartPre=-/usr/bin/docker rm marathon
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.Marathon}}
+    ExecStart=/usr/bin/sh -c "docker run \
+      --name marathon \
+      --net host \
+      --env LIBPROCESS_IP=$(hostname -i) \
+      --env LIBPROCESS_PORT=9090 \
+      --volume /etc/resolv.conf:/etc/resolv.conf \
+      {{.Versions.Marathon}} \
+      --http_address $(hostname -i) \
+      --master zk://${KATO_ZK}/mesos \
+      --zk zk://${KATO_ZK}/marathon \
+      --task_launch_timeout 240000 \
+      --checkpoint"
+    ExecStop=/usr/bin/docker stop -t 5 marathon
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+    MachineMetadata=role=master
+{{- end}}
+
+{{define "fleet-marathon-lb"}} - path: "/etc/fleet/marathon-lb.service"
+   content: |
+    [Unit]
+    Description=marathon-lb
+    After=docker.service
+    Requires=docker.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    ExecStartPre=-/usr/bin/docker kill marathon-lb
+    ExecStartPre=-/usr/bin/docker rm marathon-lb
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.MarathonLB}}
+    ExecStart=/usr/bin/sh -c "docker run \
+      --name marathon-lb \
+      --net host \
+      --privileged \
+      --volume /etc/resolv.conf:/etc/resolv.conf \
+      --env PORTS=9090,9091 \
+      {{.Versions.MarathonLB}} sse \
+      --marathon http://marathon:8080 \
+      --health-check \
+      --group external \
+      --group internal"
+    ExecStop=/usr/bin/docker stop -t 5 marathon-lb
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+    MachineMetadata=role=node
+{{- end}}
+
+{{define "fleet-cadvisor"}} - path: "/etc/fleet/cadvisor.service"
+   content: |
+    [Unit]
+    Description=cAdvisor Service
+    After=docker.service
+    Requires=docker.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    ExecStartPre=-/usr/bin/docker kill cadvisor
+    ExecStartPre=-/usr/bin/docker rm -f cadvisor
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.Cadvisor}}
+    ExecStart=/usr/bin/sh -c "docker run \
+      --net host \
+      --name cadvisor \
+      --volume /:/rootfs:ro \
+      --volume /var/run:/var/run:rw \
+      --volume /sys:/sys:ro \
+      --volume /var/lib/docker/:/var/lib/docker:ro \
+      {{.Versions.Cadvisor}} \
+      --listen_ip $(hostname -i) \
+      --logtostderr \
+      --port=4194"
+    ExecStop=/usr/bin/docker stop -t 5 cadvisor
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+{{- end}}
+
+{{define "fleet-dnsmasq"}} - path: "/etc/fleet/dnsmasq.service"
+   content: |
+    [Unit]
+    Description=Lightweight caching DNS proxy
+    After=docker.service
+    Requires=docker.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    ExecStartPre=-/usr/bin/docker kill dnsmasq
+    ExecStartPre=-/usr/bin/docker rm -f dnsmasq
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.Dnsmasq}}
+    ExecStartPre=/usr/bin/sh -c " \
+      etcdctl member list 2>1 | awk -F [/:] '{print $9}' | tr '\n' ',' > /tmp/ns && \
+      awk '/^nameserver/ {print $2; exit}' /run/systemd/resolve/resolv.conf >> /tmp/ns"
+    ExecStart=/usr/bin/sh -c "docker run \
+      --name dnsmasq \
+      --net host \
+      --volume /etc/resolv.conf:/etc/resolv.conf \
+      {{.Versions.Dnsmasq}} \
+      --listen $(hostname -i) \
+      --nameservers $(cat /tmp/ns) \
+      --hostsfile /etc/hosts \
+      --hostsfile-poll 60 \
+      --default-resolver \
+      --search-domains $(hostname -d | cut -d. -f-2).mesos,$(hostname -d) \
+      --append-search-domains"
+    ExecStop=/usr/bin/docker stop -t 5 dnsmasq
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+    MachineMetadata=role=node
+{{- end}}
+
+{{define "fleet-mongodb"}} - path: "/etc/fleet/mongodb.service"
+   content: |
+    [Unit]
+    Description=MongoDB
+    After=docker.service
+    Requires=docker.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    ExecStartPre=-/usr/bin/docker kill mongodb
+    ExecStartPre=-/usr/bin/docker rm mongodb
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.Mongo}}
+    ExecStart=/usr/bin/sh -c "docker run \
+      --name mongodb \
+      --net host \
+      --volume /var/lib/mongo:/data/db \
+      {{.Versions.Mongo}} \
+      --bind_ip 127.0.0.1"
+    ExecStop=/usr/bin/docker stop -t 5 mongodb
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+    MachineMetadata=role=edge
+{{- end}}
+
+{{define "fleet-pritunl"}} - path: "/etc/fleet/pritunl.service"
+   content: |
+    [Unit]
+    Description=Pritunl
+    After=docker.service mongodb.service
+    Requires=docker.service mongodb.service
+
+    [Service]
+    Restart=on-failure
+    RestartSec=20
+    TimeoutStartSec=0
+    ExecStartPre=-/usr/bin/docker kill pritunl
+    ExecStartPre=-/usr/bin/docker rm pritunl
+    ExecStartPre=-/usr/bin/docker pull {{.Versions.Pritunl}}
+    ExecStart=/usr/bin/sh -c "docker run \
+      --privileged \
+      --name pritunl \
+      --net host \
+      --env MONGODB_URI=mongodb://127.0.0.1:27017/pritunl \
+      {{.Versions.Pritunl}}"
+    ExecStop=/usr/bin/docker stop -t 5 pritunl
+
+    [Install]
+    WantedBy=multi-user.target
+
+    [X-Fleet]
+    Global=true
+    MachineMetadata=role=edge
+{{- end}}
+`
@@ -0,0 +1,337 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+//-----------------------------------------------------------------------------
+// Package constants:
+//-----------------------------------------------------------------------------
+
+// ignitionVersion is the Ignition config spec emitted by katoctl.
+const ignitionVersion = "2.0.0"
+
+// coreUID is the UID and GID of the core user on Container Linux.
+const coreUID = 500
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// privateIPv4 maps each platform to the coreos-metadata variable holding the
+// instance private IPv4 address.
+var privateIPv4 = map[string]string{
+	"ec2":       "COREOS_EC2_IPV4_LOCAL",
+	"packet":    "COREOS_PACKET_IPV4_PRIVATE_0",
+	"openstack": "COREOS_OPENSTACK_IPV4_LOCAL",
+	"gce":       "COREOS_GCE_IP_LOCAL_0",
+}
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// cloudConfig is the subset of cloud-config used by the kato templates.
+type cloudConfig struct {
+	Hostname          string      `yaml:"hostname"`
+	SSHAuthorizedKeys []string    `yaml:"ssh_authorized_keys"`
+	WriteFiles        []writeFile `yaml:"write_files"`
+	CoreOS            struct {
+		Units []unit            `yaml:"units"`
+		Fleet map[string]string `yaml:"fleet"`
+		Etcd2 map[string]string `yaml:"etcd2"`
+	} `yaml:"coreos"`
+}
+
+type writeFile struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Permissions string `yaml:"permissions"`
+	Owner       string `yaml:"owner"`
+}
+
+type unit struct {
+	Name    string   `yaml:"name"`
+	Command string   `yaml:"command"`
+	Content string   `yaml:"content"`
+	DropIns []dropIn `yaml:"drop-ins"`
+}
+
+type dropIn struct {
+	Name    string `yaml:"name"`
+	Content string `yaml:"content"`
+}
+
+// ignConfig is the subset of the Ignition 2.0 config spec used by katoctl.
+type ignConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	Passwd  *ignPasswd `json:"passwd,omitempty"`
+	Storage struct {
+		Files []ignFile `json:"files,omitempty"`
+	} `json:"storage"`
+	Systemd struct {
+		Units []ignUnit `json:"units,omitempty"`
+	} `json:"systemd"`
+}
+
+type ignPasswd struct {
+	Users []ignUser `json:"users"`
+}
+
+type ignUser struct {
+	Name              string   `json:"name"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys"`
+}
+
+type ignFile struct {
+	Filesystem string `json:"filesystem"`
+	Path       string `json:"path"`
+	Contents   struct {
+		Source string `json:"source"`
+	} `json:"contents"`
+	Mode  int   `json:"mode"`
+	User  ignID `json:"user"`
+	Group ignID `json:"group"`
+}
+
+type ignID struct {
+	ID int `json:"id"`
+}
+
+type ignUnit struct {
+	Name     string      `json:"name"`
+	Enable   bool        `json:"enable,omitempty"`
+	Contents string      `json:"contents,omitempty"`
+	DropIns  []ignDropIn `json:"dropins,omitempty"`
+}
+
+type ignDropIn struct {
+	Name     string `json:"name"`
+	Contents string `json:"contents"`
+}
+
+//-----------------------------------------------------------------------------
+// func: toIgnition
+//-----------------------------------------------------------------------------
+
+// toIgnition converts a rendered cloud-config document into an equivalent
+// Ignition config. Ignition does not substitute $private_ipv4, so the files
+// using it are fixed up at boot from the coreos-metadata of the platform.
+func toIgnition(data []byte, platform string) ([]byte, error) {
+
+	// Resolve the metadata variable:
+	ipVar, ok := privateIPv4[platform]
+	if !ok {
+		err := errors.New("unsupported ignition platform: " + platform)
+		log.WithField("cmd", "udata").Error(err)
+		return nil, err
+	}
+
+	// Decode the cloud-config:
+	cc := cloudConfig{}
+	if err := yaml.Unmarshal(data, &cc); err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return nil, err
+	}
+
+	ign := ignConfig{}
+	ign.Ignition.Version = ignitionVersion
+
+	// The hostname:
+	if cc.Hostname != "" {
+		ign.Storage.Files = append(ign.Storage.Files,
+			ignitionFile(writeFile{Path: "/etc/hostname", Content: cc.Hostname + "\n"}))
+	}
+
+	// The SSH keys of the core user:
+	if len(cc.SSHAuthorizedKeys) > 0 {
+		ign.Passwd = &ignPasswd{Users: []ignUser{{
+			Name:              "core",
+			SSHAuthorizedKeys: cc.SSHAuthorizedKeys,
+		}}}
+	}
+
+	// The files:
+	var fixup []string
+	for _, f := range cc.WriteFiles {
+		file, err := ignitionFileMode(f)
+		if err != nil {
+			return nil, err
+		}
+		ign.Storage.Files = append(ign.Storage.Files, file)
+		if strings.Contains(f.Content, "$private_ipv4") {
+			fixup = append(fixup, f.Path)
+		}
+	}
+
+	// The units:
+	for _, u := range cc.CoreOS.Units {
+		iu := ignUnit{Name: u.Name, Contents: u.Content}
+		if u.Command == "start" {
+			iu.Enable = true
+			if u.Content != "" && !strings.Contains(u.Content, "[Install]") {
+				iu.Contents = strings.TrimRight(u.Content, "\n") +
+					"\n\n[Install]\nWantedBy=multi-user.target\n"
+			}
+		}
+		for _, d := range u.DropIns {
+			iu.DropIns = append(iu.DropIns, ignDropIn{Name: d.Name, Contents: d.Content})
+		}
+		ign.Systemd.Units = append(ign.Systemd.Units, iu)
+	}
+
+	// Fetch the metadata and patch the files using it:
+	ign.Systemd.Units = append(ign.Systemd.Units,
+		ignUnit{Name: "coreos-metadata.service", DropIns: []ignDropIn{{
+			Name:     "20-kato.conf",
+			Contents: "[Service]\nEnvironment=COREOS_METADATA_OPT_PROVIDER=--provider=" + platform + "\n",
+		}}})
+
+	if len(fixup) > 0 {
+		ign.Systemd.Units = append(ign.Systemd.Units, ignUnit{
+			Name:   "kato-metadata.service",
+			Enable: true,
+			Contents: "[Unit]\n" +
+				"Description=Substitute the instance metadata in configuration files\n" +
+				"Requires=coreos-metadata.service\n" +
+				"After=coreos-metadata.service\n" +
+				"Before=etcd2.service fleet.service\n\n" +
+				"[Service]\n" +
+				"Type=oneshot\n" +
+				"RemainAfterExit=yes\n" +
+				"EnvironmentFile=/run/metadata/coreos\n" +
+				"ExecStart=/usr/bin/sed -i \"s/\\$private_ipv4/${" + ipVar + "}/g\" " +
+				strings.Join(fixup, " ") + "\n\n" +
+				"[Install]\n" +
+				"WantedBy=multi-user.target\n",
+		})
+	}
+
+	// etcd2 and fleet settings become drop-ins:
+	ign.Systemd.Units = mergeDropIn(ign.Systemd.Units, "etcd2.service",
+		metadataDropIn("/usr/bin/etcd2", cc.CoreOS.Etcd2, ipVar))
+	ign.Systemd.Units = mergeDropIn(ign.Systemd.Units, "fleet.service",
+		metadataDropIn("/usr/bin/fleetd", cc.CoreOS.Fleet, ipVar))
+
+	// Encode the config:
+	out, err := json.MarshalIndent(ign, "", "  ")
+	if err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return nil, err
+	}
+
+	return append(out, '\n'), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: ignitionFileMode
+//-----------------------------------------------------------------------------
+
+func ignitionFileMode(f writeFile) (ignFile, error) {
+
+	file := ignitionFile(f)
+
+	// Permissions are octal strings:
+	if f.Permissions != "" {
+		mode, err := strconv.ParseInt(f.Permissions, 8, 32)
+		if err != nil {
+			log.WithField("cmd", "udata").Error(err)
+			return file, err
+		}
+		file.Mode = int(mode)
+	}
+
+	return file, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: ignitionFile
+//-----------------------------------------------------------------------------
+
+func ignitionFile(f writeFile) ignFile {
+
+	file := ignFile{Filesystem: "root", Path: f.Path, Mode: 0644}
+	file.Contents.Source = "data:text/plain;charset=utf-8;base64," +
+		base64.StdEncoding.EncodeToString([]byte(f.Content))
+
+	// Only the core user is ever used as owner:
+	if strings.HasPrefix(f.Owner, "core") {
+		file.User.ID, file.Group.ID = coreUID, coreUID
+	}
+
+	return file
+}
+
+//-----------------------------------------------------------------------------
+// func: metadataDropIn
+//-----------------------------------------------------------------------------
+
+// metadataDropIn turns a cloud-config settings map into a drop-in running the
+// daemon with the equivalent flags once the metadata is available.
+func metadataDropIn(daemon string, settings map[string]string, ipVar string) *ignDropIn {
+
+	if len(settings) == 0 {
+		return nil
+	}
+
+	// Sorted for a stable output:
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	exec := "ExecStart=" + daemon
+	for _, k := range keys {
+		v := strings.Replace(settings[k], "$private_ipv4", "${"+ipVar+"}", -1)
+		exec += " \\\n  --" + k + "=\"" + v + "\""
+	}
+
+	return &ignDropIn{
+		Name: "20-kato.conf",
+		Contents: "[Unit]\n" +
+			"Requires=coreos-metadata.service\n" +
+			"After=coreos-metadata.service\n\n" +
+			"[Service]\n" +
+			"EnvironmentFile=/run/metadata/coreos\n" +
+			"ExecStart=\n" +
+			exec + "\n",
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: mergeDropIn
+//-----------------------------------------------------------------------------
+
+func mergeDropIn(units []ignUnit, name string, d *ignDropIn) []ignUnit {
+
+	if d == nil {
+		return units
+	}
+
+	for i := range units {
+		if units[i].Name == name {
+			units[i].DropIns = append(units[i].DropIns, *d)
+			return units
+		}
+	}
+
+	return append(units, ignUnit{Name: name, Enable: true, DropIns: []ignDropIn{*d}})
+}
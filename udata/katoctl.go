@@ -0,0 +1,104 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/h0tbird/kato/katool"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+//-----------------------------------------------------------------------------
+// func: ReleaseURL
+//-----------------------------------------------------------------------------
+
+// ReleaseURL is where the hosts download a katoctl release from.
+func ReleaseURL(version string) string {
+	return "https://github.com/h0tbird/kato/releases/download/" +
+		version + "/katoctl-linux-amd64"
+}
+
+//-----------------------------------------------------------------------------
+// func: pinKatoctl
+//-----------------------------------------------------------------------------
+
+// pinKatoctl makes the hosts run the katoctl release rendering the user-data
+// and verify it before installing it. A release built for the hosts knows its
+// own checksum; any other katoctl or URL needs an explicit one.
+func (d *Data) pinKatoctl() error {
+
+	// The release of this katoctl:
+	if d.KatoctlURL == "" {
+		if katool.Version == "dev" {
+			err := errors.New("a development katoctl has no release to download, set the katoctl URL and its sha256")
+			log.WithField("cmd", "udata").Error(err)
+			return err
+		}
+		d.KatoctlURL = ReleaseURL(katool.Version)
+	}
+
+	// The checksum of this katoctl:
+	if d.KatoctlSHA256 == "" && d.KatoctlURL == ReleaseURL(katool.Version) &&
+		katool.Version != "dev" && runtime.GOOS == "linux" && runtime.GOARCH == "amd64" {
+		sum, err := selfSHA256()
+		if err != nil {
+			log.WithField("cmd", "udata").Error(err)
+			return err
+		}
+		d.KatoctlSHA256 = sum
+	}
+
+	d.KatoctlSHA256 = strings.ToLower(d.KatoctlSHA256)
+	if !sha256Hex.MatchString(d.KatoctlSHA256) {
+		err := errors.New("a hex encoded sha256 is required to verify " + d.KatoctlURL)
+		log.WithField("cmd", "udata").Error(err)
+		return err
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: selfSHA256
+//-----------------------------------------------------------------------------
+
+// selfSHA256 returns the checksum of the running katoctl binary.
+func selfSHA256() (string, error) {
+
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
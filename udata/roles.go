@@ -0,0 +1,282 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// Fragments lists the base fragments a role can be built from. Every role
+// needs exactly one of etcd-member or etcd-proxy, and only the master role,
+// which makes up the etcd initial cluster, can be a member.
+var Fragments = []string{"etcd-member", "etcd-proxy", "flannel", "rexray", "docker-gc"}
+
+// FleetUnits lists the built-in fleet units a role can drop in /etc/fleet.
+var FleetUnits = []string{
+	"zookeeper", "mesos-master", "mesos-node", "mesos-dns", "marathon",
+	"marathon-lb", "cadvisor", "dnsmasq", "mongodb", "pritunl",
+}
+
+// roleName must be usable as the first label of a hostname.
+var roleName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Role describes what a host of the role runs.
+type Role struct {
+	Fragments       []string          `yaml:"fragments"`       // Base fragments, see Fragments.
+	FleetUnits      []string          `yaml:"fleetUnits"`      // Built-in units or unit files.
+	FleetMetadata   map[string]string `yaml:"fleetMetadata"`   // role=<name> unless overridden.
+	MesosAttributes map[string]string `yaml:"mesosAttributes"` // Passed to the Mesos agent.
+	HostAliases     []string          `yaml:"hostAliases"`     // Extra names in /etc/hosts.
+}
+
+// UnitFile is a unit file written to /etc/fleet.
+type UnitFile struct {
+	Name    string
+	Content string
+}
+
+//-----------------------------------------------------------------------------
+// func: DefaultRoles
+//-----------------------------------------------------------------------------
+
+// DefaultRoles returns the roles katoctl knows out of the box.
+func DefaultRoles() map[string]Role {
+	return map[string]Role{
+		"master": {
+			Fragments:  []string{"etcd-member"},
+			FleetUnits: FleetUnits,
+		},
+		"node": {
+			Fragments:   []string{"etcd-proxy", "flannel", "rexray", "docker-gc"},
+			HostAliases: []string{"marathon-lb"},
+		},
+		"edge": {
+			Fragments: []string{"etcd-proxy", "flannel"},
+		},
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: LoadRoles
+//-----------------------------------------------------------------------------
+
+// LoadRoles reads a roles file on top of the default roles. A role defined
+// in the file replaces the default role of the same name. Relative unit
+// files are resolved against the directory holding the roles file.
+func LoadRoles(path string) (map[string]Role, error) {
+
+	roles := DefaultRoles()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return roles, err
+	}
+
+	// Decode the roles:
+	var o map[string]Role
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&o); err != nil {
+		err = errors.New(path + ": " + err.Error())
+		log.WithField("cmd", "udata").Error(err)
+		return roles, err
+	}
+
+	for name, r := range o {
+
+		if err := r.check(name); err != nil {
+			err = errors.New(path + ": " + err.Error())
+			log.WithField("cmd", "udata").Error(err)
+			return roles, err
+		}
+
+		for i, u := range r.FleetUnits {
+			if !isFleetUnit(u) && !filepath.IsAbs(u) {
+				r.FleetUnits[i] = filepath.Join(filepath.Dir(path), u)
+			}
+		}
+
+		roles[name] = r
+	}
+
+	return roles, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: check
+//-----------------------------------------------------------------------------
+
+// check rejects unknown fragments, roles without an etcd flavour and etcd
+// members missing from the initial cluster, which only lists masters.
+func (r *Role) check(name string) error {
+
+	if !roleName.MatchString(name) {
+		return errors.New("invalid role name: " + name)
+	}
+
+	etcd := 0
+	for _, f := range r.Fragments {
+		switch f {
+		case "etcd-member":
+			if name != "master" {
+				return errors.New(name + ": only the master role can be an etcd-member, use etcd-proxy")
+			}
+			etcd++
+		case "etcd-proxy":
+			etcd++
+		case "flannel", "rexray", "docker-gc":
+		default:
+			return errors.New(name + ": unknown fragment: " + f)
+		}
+	}
+
+	if etcd != 1 {
+		return errors.New(name + ": needs exactly one of etcd-member or etcd-proxy")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: role
+//-----------------------------------------------------------------------------
+
+// role looks up the role of the host and forges what its template needs.
+func (d *Data) role() error {
+
+	var err error
+
+	// Find the role:
+	roles := DefaultRoles()
+	if d.RolesFile != "" {
+		if roles, err = LoadRoles(d.RolesFile); err != nil {
+			return err
+		}
+	}
+
+	r, ok := roles[d.Role]
+	if !ok {
+		err = errors.New("unknown role: " + d.Role)
+		log.WithField("cmd", "udata").Error(err)
+		return err
+	}
+
+	// Base fragments:
+	d.fragments = map[string]bool{}
+	for _, f := range r.Fragments {
+		d.fragments[f] = true
+	}
+
+	// Built-in and user fleet units:
+	d.fleetUnits = map[string]bool{}
+	d.ExtraFleetUnits = nil
+	for _, u := range r.FleetUnits {
+		if isFleetUnit(u) {
+			d.fleetUnits[u] = true
+			continue
+		}
+		content, err := readIndented(u)
+		if err != nil {
+			return err
+		}
+		d.ExtraFleetUnits = append(d.ExtraFleetUnits, UnitFile{
+			Name:    filepath.Base(u),
+			Content: content,
+		})
+	}
+
+	// Fleet metadata, role first and id last:
+	md := map[string]string{"role": d.Role}
+	for k, v := range r.FleetMetadata {
+		md[k] = v
+	}
+	d.FleetMetadata = "role=" + md["role"]
+	for _, k := range sortedKeys(md) {
+		if k != "role" && k != "id" {
+			d.FleetMetadata += "," + k + "=" + md[k]
+		}
+	}
+	d.FleetMetadata += ",id=" + d.HostID
+
+	// Mesos agent attributes:
+	var attrs []string
+	for _, k := range sortedKeys(r.MesosAttributes) {
+		attrs = append(attrs, k+":"+r.MesosAttributes[k])
+	}
+	d.MesosAttributes = strings.Join(attrs, ";")
+
+	// Extra names in /etc/hosts:
+	d.HostAliases = ""
+	for _, a := range r.HostAliases {
+		d.HostAliases += " " + a
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Fragment
+//-----------------------------------------------------------------------------
+
+// Fragment tells the templates whether the role includes a base fragment.
+func (d *Data) Fragment(name string) bool {
+	return d.fragments[name]
+}
+
+//-----------------------------------------------------------------------------
+// func: FleetUnit
+//-----------------------------------------------------------------------------
+
+// FleetUnit tells the templates whether the role ships a built-in fleet unit.
+func (d *Data) FleetUnit(name string) bool {
+	return d.fleetUnits[name]
+}
+
+//-----------------------------------------------------------------------------
+// func: isFleetUnit
+//-----------------------------------------------------------------------------
+
+func isFleetUnit(name string) bool {
+	for _, u := range FleetUnits {
+		if u == name {
+			return true
+		}
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: sortedKeys
+//-----------------------------------------------------------------------------
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,61 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+// func: TestLoadRolesEtcdMember
+//-----------------------------------------------------------------------------
+
+// The etcd initial cluster is made of the masters, so a custom role can not
+// be an etcd member: it would boot with a name missing from the cluster.
+func TestLoadRolesEtcdMember(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		roles string
+		fail  bool
+	}{
+		{
+			name:  "master",
+			roles: "master:\n  fragments: [etcd-member]\n  fleetUnits: [zookeeper]\n",
+		},
+		{
+			name:  "proxy",
+			roles: "storage:\n  fragments: [etcd-proxy, rexray]\n",
+		},
+		{
+			name:  "member",
+			roles: "storage:\n  fragments: [etcd-member, rexray]\n",
+			fail:  true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "roles.yaml")
+			if err := ioutil.WriteFile(path, []byte(c.roles), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadRoles(path)
+			switch {
+			case !c.fail && err != nil:
+				t.Error(err)
+			case c.fail && (err == nil || !strings.Contains(err.Error(), "etcd-member")):
+				t.Errorf("got %v, want an etcd-member error", err)
+			}
+		})
+	}
+}
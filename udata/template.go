@@ -0,0 +1,77 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// func: template
+//-----------------------------------------------------------------------------
+
+// template parses the built-in fragments and then the overrides found in
+// the templates directory, if any. An override is a <fragment>.tmpl file
+// holding the new body of the fragment.
+func (d *Data) template() (*template.Template, error) {
+
+	t := template.New("udata")
+
+	// The built-in fragments:
+	for _, s := range []string{templCloudConfig, templFiles, templFleetUnits, templUnits} {
+		if _, err := t.Parse(s); err != nil {
+			log.WithField("cmd", "udata").Error(err)
+			return nil, err
+		}
+	}
+
+	if d.TemplatesDir == "" {
+		return t, nil
+	}
+
+	// The site overrides:
+	paths, err := filepath.Glob(filepath.Join(d.TemplatesDir, "*.tmpl"))
+	if err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return nil, err
+	}
+
+	for _, path := range paths {
+
+		name := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		if t.Lookup(name) == nil || name == "udata" {
+			err := errors.New(path + ": unknown fragment " + name)
+			log.WithField("cmd", "udata").Error(err)
+			return nil, err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.WithField("cmd", "udata").Error(err)
+			return nil, err
+		}
+
+		// Fragments have no trailing newline:
+		body := strings.TrimRight(string(data), "\n")
+		if _, err := t.New(name).Parse(body); err != nil {
+			log.WithField("cmd", "udata").Error(err)
+			return nil, err
+		}
+
+		log.WithFields(log.Fields{"cmd": "udata", "id": name}).
+			Info("- Fragment overridden by " + path)
+	}
+
+	return t, nil
+}
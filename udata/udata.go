@@ -7,15 +7,22 @@ package udata
 import (
 
 	// Stdlib:
+	"bytes"
 	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"text/template"
 
 	// Community:
 	log "github.com/Sirupsen/logrus"
+	"github.com/h0tbird/kato/katool"
+	"github.com/h0tbird/kato/pki"
+	"github.com/h0tbird/kato/secrets"
 )
 
 //-----------------------------------------------------------------------------
@@ -28,11 +35,24 @@ type Data struct {
 	HostID              string
 	Domain              string
 	Role                string
+	DNSProvider         string
 	Ns1ApiKey           string
+	Rfc2136Server       string
+	Rfc2136TSIGKey      string
+	KatoctlURL          string
+	KatoctlSHA256       string
 	CaCert              string
 	EtcdToken           string
+	EtcdDiscoveryURL    string
+	EtcdTLS             bool
+	EtcdScheme          string
+	EtcdHost            string
+	PKIDir              string
+	ClusterCA           string
+	HostCert            string
+	HostKey             string
 	ZkServers           string
-	GzipUdata           bool
+	EtcdInitialCluster  string
 	FlannelNetwork      string
 	FlannelSubnetLen    string
 	FlannelSubnetMin    string
@@ -41,6 +61,34 @@ type Data struct {
 	RexrayStorageDriver string
 	RexrayConfigSnippet string
 	RexrayEndpointIP    string
+	VersionsFile        string
+	Versions            Versions
+	Secrets             secrets.Config
+	SealedSecrets       string
+	RolesFile           string
+	TemplatesDir        string
+	FleetMetadata       string
+	MesosAttributes     string
+	HostAliases         string
+	ExtraFleetUnits     []UnitFile
+	ExtraFiles          []string
+	ExtraUnits          []string
+	SSHKeys             []string
+	UserFiles           []UserFile
+	UserUnits           []UnitFile
+	SSHAuthorizedKeys   []string
+	fragments           map[string]bool
+	fleetUnits          map[string]bool
+}
+
+// Option changes how Render encodes the rendered document.
+type Option func(*options)
+
+type options struct {
+	gzip     bool
+	base64   bool
+	validate bool
+	platform string
 }
 
 //-----------------------------------------------------------------------------
@@ -49,21 +97,77 @@ type Data struct {
 
 func (d *Data) caCert() error {
 
+	var err error
+
 	if d.CaCert != "" {
+		d.CaCert, err = readIndented(d.CaCert)
+	}
 
-		data, err := ioutil.ReadFile(d.CaCert)
-		if err != nil {
-			log.WithField("cmd", "udata").Error(err)
-			return err
-		}
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: pkiFiles
+//-----------------------------------------------------------------------------
+
+// pkiFiles loads the cluster CA and the host certificate issued by
+// 'katoctl pki', if any. The cluster CA is also trusted by Docker unless
+// another CA is given.
+func (d *Data) pkiFiles() error {
+
+	var err error
+
+	if d.PKIDir == "" || !pki.Exists(d.PKIDir) {
+		return nil
+	}
+
+	ca, _ := pki.Paths(d.PKIDir, pki.CA)
+	crt, key := pki.Paths(d.PKIDir, d.Role+"-"+d.HostID)
+
+	// The host certificate is optional:
+	if _, err = os.Stat(crt); os.IsNotExist(err) {
+		log.WithFields(log.Fields{"cmd": "udata", "id": d.Role + "-" + d.HostID}).
+			Warn("No host certificate in " + d.PKIDir)
+		return nil
+	}
+
+	if d.ClusterCA, err = readIndented(ca); err != nil {
+		return err
+	}
+
+	if d.HostCert, err = readIndented(crt); err != nil {
+		return err
+	}
 
-		d.CaCert = strings.TrimSpace(strings.
-			Replace(string(data), "\n", "\n    ", -1))
+	if d.HostKey, err = readIndented(key); err != nil {
+		return err
+	}
+
+	if d.CaCert == "" {
+		d.CaCert = ca
 	}
 
 	return nil
 }
 
+//-----------------------------------------------------------------------------
+// func: readIndented
+//-----------------------------------------------------------------------------
+
+// readIndented returns the content of a file indented to fit in a
+// write_files content block.
+func readIndented(path string) (string, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return "", err
+	}
+
+	return strings.TrimSpace(strings.
+		Replace(string(data), "\n", "\n    ", -1)), nil
+}
+
 //-----------------------------------------------------------------------------
 // func: forgeZookeeperURL
 //-----------------------------------------------------------------------------
@@ -78,6 +182,163 @@ func (d *Data) forgeZookeeperURL() {
 	}
 }
 
+//-----------------------------------------------------------------------------
+// func: forgeEtcdInitialCluster
+//-----------------------------------------------------------------------------
+
+func (d *Data) forgeEtcdInitialCluster() {
+
+	// Peers are dialed by the names in their certificates:
+	suffix := ""
+	if d.EtcdTLS {
+		suffix = ".int." + d.Domain
+	}
+
+	for i := 1; i <= d.MasterCount; i++ {
+		id := "master-" + strconv.Itoa(i)
+		d.EtcdInitialCluster = d.EtcdInitialCluster + id + "=" + d.EtcdScheme + "://" + id + suffix + ":2380"
+		if i != d.MasterCount {
+			d.EtcdInitialCluster = d.EtcdInitialCluster + ","
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: etcdTLS
+//-----------------------------------------------------------------------------
+
+// etcdTLS selects the scheme and the host of the advertised etcd URLs. TLS
+// needs the host certificate issued by 'katoctl pki', which only holds host
+// names, so TLS URLs advertise the internal name published by the agent
+// instead of the address of the host.
+func (d *Data) etcdTLS() error {
+
+	d.EtcdScheme = "http"
+	d.EtcdHost = "$private_ipv4"
+
+	if !d.EtcdTLS {
+		return nil
+	}
+
+	if d.HostCert == "" {
+		err := errors.New("etcd TLS needs a host certificate for " + d.Role + "-" + d.HostID +
+			": run 'katoctl pki init' and 'katoctl pki issue'")
+		log.WithField("cmd", "udata").Error(err)
+		return err
+	}
+
+	d.EtcdScheme = "https"
+	d.EtcdHost = d.Role + "-" + d.HostID + ".int." + d.Domain
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: checkDNS
+//-----------------------------------------------------------------------------
+
+// checkDNS makes sure the selected DNS provider has its settings. NS1 is the
+// default provider. etcd over TLS needs one: its peers are dialed by the
+// int.<domain> names the agent publishes, before hosts-sync can run.
+func (d *Data) checkDNS() error {
+
+	var err error
+
+	switch d.DNSProvider {
+	case "", "ns1":
+		d.DNSProvider = "ns1"
+		if d.Ns1ApiKey == "" {
+			err = errors.New("the ns1 DNS provider needs an API key")
+		}
+	case "rfc2136":
+		if d.Rfc2136Server == "" {
+			err = errors.New("the rfc2136 DNS provider needs a server")
+		}
+	case "route53":
+	case "none":
+		if d.EtcdTLS {
+			err = errors.New("etcd TLS dials the hosts by their int." + d.Domain +
+				" names, which the none DNS provider never publishes")
+		}
+	default:
+		err = errors.New("unknown DNS provider: " + d.DNSProvider)
+	}
+
+	if err != nil {
+		log.WithField("cmd", "udata").Error(err)
+	}
+
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: sealSecrets
+//-----------------------------------------------------------------------------
+
+// sealSecrets moves the DNS credentials into a sealed bundle, opened at boot
+// by 'katoctl agent secrets', so they never show up in plain text.
+func (d *Data) sealSecrets() error {
+
+	// Collect the secrets:
+	s := map[string]string{}
+	if d.Ns1ApiKey != "" {
+		s["KATO_AGENT_DNS_PUBLISH_NS1_API_KEY"] = d.Ns1ApiKey
+	}
+	if d.Rfc2136TSIGKey != "" {
+		s["KATO_AGENT_DNS_PUBLISH_RFC2136_TSIG_KEY"] = d.Rfc2136TSIGKey
+	}
+
+	if len(s) == 0 {
+		return nil
+	}
+
+	// Without a backend the secrets stay in the user-data:
+	if d.Secrets.Backend == "" || d.Secrets.Backend == "none" {
+		log.WithField("cmd", "udata").
+			Warn("- DNS credentials left in plain text in the user-data, seal them with --secrets-backend")
+		return nil
+	}
+
+	// The file backend defaults to a key derived from the cluster CA:
+	if d.Secrets.Backend == "file" && d.PKIDir != "" {
+		if d.Secrets.KeyFile == "" {
+			d.Secrets.KeyFile = filepath.Join(d.PKIDir, "secrets.key")
+		}
+		if d.Secrets.CAKeyFile == "" && pki.Exists(d.PKIDir) {
+			_, d.Secrets.CAKeyFile = pki.Paths(d.PKIDir, pki.CA)
+		}
+	}
+
+	b, err := secrets.New(d.Secrets)
+	if err != nil {
+		return err
+	}
+
+	if d.SealedSecrets, err = secrets.Seal(b, d.Secrets.Backend, s); err != nil {
+		return err
+	}
+
+	// Nothing left in plain text:
+	d.Ns1ApiKey, d.Rfc2136TSIGKey = "", ""
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: checkMasterCount
+//-----------------------------------------------------------------------------
+
+// checkMasterCount only accepts odd-sized etcd and Zookeeper quorums.
+func (d *Data) checkMasterCount() error {
+
+	switch d.MasterCount {
+	case 1, 3, 5:
+		return nil
+	}
+
+	err := errors.New("unsupported master count: " + strconv.Itoa(d.MasterCount) + " (use 1, 3 or 5)")
+	log.WithField("cmd", "udata").Error(err)
+	return err
+}
+
 //-----------------------------------------------------------------------------
 // func: rexraySnippet
 //-----------------------------------------------------------------------------
@@ -97,57 +358,181 @@ func (d *Data) rexraySnippet() {
 	}
 }
 
+//-----------------------------------------------------------------------------
+// func: Gzip
+//-----------------------------------------------------------------------------
+
+// Gzip compresses the rendered document.
+func Gzip() Option {
+	return func(o *options) { o.gzip = true }
+}
+
+//-----------------------------------------------------------------------------
+// func: Base64
+//-----------------------------------------------------------------------------
+
+// Base64 encodes the rendered, and possibly compressed, document in base64.
+func Base64() Option {
+	return func(o *options) { o.base64 = true }
+}
+
+//-----------------------------------------------------------------------------
+// func: Validated
+//-----------------------------------------------------------------------------
+
+// Validated checks the rendered cloud-config with Validate and fails instead
+// of writing an invalid document.
+func Validated() Option {
+	return func(o *options) { o.validate = true }
+}
+
+//-----------------------------------------------------------------------------
+// func: Ignition
+//-----------------------------------------------------------------------------
+
+// Ignition converts the rendered cloud-config into an equivalent Ignition
+// config for the given platform before any other encoding takes place.
+func Ignition(platform string) Option {
+	return func(o *options) { o.platform = platform }
+}
+
 //-----------------------------------------------------------------------------
 // func: Render
 //-----------------------------------------------------------------------------
 
-// Render takes a Data structure and outputs valid CoreOS cloud-config
-// in YAML format to stdout.
-func (d *Data) Render() error {
+// Render writes valid CoreOS cloud-config in YAML format to w, or an Ignition
+// config in JSON format, encoded as requested by opts. The receiver is not
+// modified.
+func (d *Data) Render(w io.Writer, opts ...Option) error {
 
 	var err error
 
+	// Apply the options:
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// Work on a copy:
+	c := *d
+
+	// Read the cluster PKI:
+	if err = c.pkiFiles(); err != nil {
+		return err
+	}
+
 	// Read the CA certificate:
-	if err = d.caCert(); err != nil {
+	if err = c.caCert(); err != nil {
+		return err
+	}
+
+	// Check the master count:
+	if err = c.checkMasterCount(); err != nil {
 		return err
 	}
 
 	// Forge the Zookeeper URL:
-	d.forgeZookeeperURL()
+	c.forgeZookeeperURL()
+
+	// Secure etcd:
+	if err = c.etcdTLS(); err != nil {
+		return err
+	}
+
+	// Forge the static etcd cluster:
+	c.forgeEtcdInitialCluster()
+
+	// The DNS provider and the agent:
+	if err = c.checkDNS(); err != nil {
+		return err
+	}
+	if err = c.sealSecrets(); err != nil {
+		return err
+	}
+	if err = c.pinKatoctl(); err != nil {
+		return err
+	}
+
+	// The etcd discovery service:
+	if c.EtcdDiscoveryURL == "" {
+		c.EtcdDiscoveryURL = katool.DefaultDiscoveryURL
+	}
+	c.EtcdDiscoveryURL = strings.TrimRight(c.EtcdDiscoveryURL, "/")
 
 	// REX-Ray configuration snippet:
-	d.rexraySnippet()
+	c.rexraySnippet()
 
-	// Role-based parsing:
-	t := template.New("udata")
+	// The component images:
+	if err = c.versions(); err != nil {
+		return err
+	}
 
-	switch d.Role {
-	case "master":
-		t, err = t.Parse(templMaster)
-	case "node":
-		t, err = t.Parse(templNode)
-	case "edge":
-		t, err = t.Parse(templEdge)
+	// The role of the host:
+	if err = c.role(); err != nil {
+		return err
 	}
 
+	// User files, units and SSH keys:
+	if err = c.extras(); err != nil {
+		return err
+	}
+
+	// Parse the fragments:
+	t, err := c.template()
 	if err != nil {
-		log.WithField("cmd", "udata").Error(err)
 		return err
 	}
 
+	// Stack the encoders, base64 being the outermost one:
+	var closers []io.Closer
+
+	if o.base64 {
+		b64 := base64.NewEncoder(base64.StdEncoding, w)
+		closers = append([]io.Closer{b64}, closers...)
+		w = b64
+	}
+
+	if o.gzip {
+		gz := gzip.NewWriter(w)
+		closers = append([]io.Closer{gz}, closers...)
+		w = gz
+	}
+
 	// Apply parsed template to data object:
-	if d.GzipUdata {
-		log.WithFields(log.Fields{"cmd": "udata", "id": d.Role + "-" + d.HostID}).
-			Info("- Rendering gzipped cloud-config template")
-		w := gzip.NewWriter(os.Stdout)
-		defer w.Close()
-		if err = t.Execute(w, d); err != nil {
-			log.WithField("cmd", "udata").Error(err)
+	log.WithFields(log.Fields{"cmd": "udata", "id": c.Role + "-" + c.HostID}).
+		Info("- Rendering " + o.String() + " template")
+
+	var buf bytes.Buffer
+	if err = t.ExecuteTemplate(&buf, "cloud-config", &c); err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return err
+	}
+
+	doc := buf.Bytes()
+
+	// Validate the cloud-config, always catching clashes with user files:
+	if o.validate || len(c.UserFiles) > 0 || len(c.UserUnits) > 0 {
+		if err = Validate(doc); err != nil {
+			log.WithFields(log.Fields{"cmd": "udata", "id": c.Role + "-" + c.HostID}).Error(err)
 			return err
 		}
-	} else {
-		log.WithField("cmd", "udata").Info("- Rendering plain text cloud-config template")
-		if err = t.Execute(os.Stdout, d); err != nil {
+	}
+
+	// Convert to Ignition:
+	if o.platform != "" {
+		if doc, err = toIgnition(doc, o.platform); err != nil {
+			return err
+		}
+	}
+
+	if _, err = w.Write(doc); err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return err
+	}
+
+	// Flush the encoders, innermost first:
+	for _, cl := range closers {
+		if err = cl.Close(); err != nil {
 			log.WithField("cmd", "udata").Error(err)
 			return err
 		}
@@ -156,3 +541,41 @@ func (d *Data) Render() error {
 	// Return on success:
 	return nil
 }
+
+//-----------------------------------------------------------------------------
+// func: Bytes
+//-----------------------------------------------------------------------------
+
+// Bytes returns the document Render would write.
+func (d *Data) Bytes(opts ...Option) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	if err := d.Render(&buf, opts...); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: String
+//-----------------------------------------------------------------------------
+
+func (o options) String() string {
+
+	format := "cloud-config"
+	if o.platform != "" {
+		format = "ignition (" + o.platform + ")"
+	}
+
+	switch {
+	case o.gzip && o.base64:
+		return "gzipped base64 " + format
+	case o.gzip:
+		return "gzipped " + format
+	case o.base64:
+		return "base64 " + format
+	}
+	return "plain text " + format
+}
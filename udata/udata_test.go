@@ -0,0 +1,282 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/h0tbird/kato/katool"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// Run 'go test ./udata -update' to accept a template change.
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// testSHA256 stands for the checksum of a katoctl release.
+const testSHA256 = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// renderCase is a host rendered by TestRender. Cases differing only in
+// compression share their golden file.
+type renderCase struct {
+	name   string
+	data   Data
+	golden string
+	gzip   bool
+}
+
+//-----------------------------------------------------------------------------
+// func: TestMain
+//-----------------------------------------------------------------------------
+
+func TestMain(m *testing.M) {
+
+	flag.Parse()
+	log.SetOutput(ioutil.Discard)
+
+	// The virtualbox snippet embeds $HOME:
+	os.Setenv("HOME", "/home/kato")
+
+	os.Exit(m.Run())
+}
+
+//-----------------------------------------------------------------------------
+// func: renderCases
+//-----------------------------------------------------------------------------
+
+// renderCases returns every role against every combination of options.
+func renderCases() []renderCase {
+
+	var cases []renderCase
+
+	for _, role := range []string{"master", "node", "edge"} {
+		for _, ca := range []string{"noca", "ca"} {
+			for _, token := range []string{"notoken", "token"} {
+				for _, driver := range []string{"none", "ec2", "virtualbox"} {
+
+					d := Data{
+						MasterCount:      3,
+						HostID:           "1",
+						Domain:           "cell-1.dc-1.kato.lan",
+						Role:             role,
+						DNSProvider:      "ns1",
+						Ns1ApiKey:        "ns1-test-key",
+						FlannelNetwork:   "10.128.0.0/21",
+						FlannelSubnetLen: "27",
+						FlannelSubnetMin: "10.128.0.192",
+						FlannelSubnetMax: "10.128.7.224",
+						FlannelBackend:   "vxlan",
+						RexrayEndpointIP: "172.17.8.1",
+						KatoctlURL:       ReleaseURL("v0.0.0"),
+						KatoctlSHA256:    testSHA256,
+					}
+
+					if ca == "ca" {
+						d.CaCert = filepath.Join("testdata", "ca.crt")
+					}
+
+					if token == "token" {
+						d.EtcdToken = "0123456789abcdef0123456789abcdef"
+					}
+
+					if driver != "none" {
+						d.RexrayStorageDriver = driver
+					}
+
+					name := role + "-" + ca + "-" + token + "-" + driver
+					for _, gz := range []bool{false, true} {
+						c := renderCase{name: name, data: d, golden: name + ".golden", gzip: gz}
+						if gz {
+							c.name += "-gzip"
+						}
+						cases = append(cases, c)
+					}
+				}
+			}
+		}
+	}
+
+	return cases
+}
+
+//-----------------------------------------------------------------------------
+// func: TestRender
+//-----------------------------------------------------------------------------
+
+func TestRender(t *testing.T) {
+
+	for _, c := range renderCases() {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+
+			opts := []Option{Validated()}
+			if c.gzip {
+				opts = append(opts, Gzip())
+			}
+
+			got, err := c.data.Bytes(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Inflate the compressed cases:
+			if c.gzip {
+				gz, err := gzip.NewReader(bytes.NewReader(got))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err = ioutil.ReadAll(gz); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			golden := filepath.Join("testdata", c.golden)
+
+			if *update && !c.gzip {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("rendered user-data differs from %s:\n%s", golden, diff(want, got))
+			}
+		})
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: diff
+//-----------------------------------------------------------------------------
+
+// diff shows the first line where two documents differ.
+func diff(want, got []byte) string {
+
+	w := bytes.Split(want, []byte("\n"))
+	g := bytes.Split(got, []byte("\n"))
+
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl []byte
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if !bytes.Equal(wl, gl) {
+			return "line " + strconv.Itoa(i+1) + ":\n  want: " + string(wl) + "\n  got:  " + string(gl)
+		}
+	}
+
+	return ""
+}
+
+//-----------------------------------------------------------------------------
+// func: TestPinKatoctl
+//-----------------------------------------------------------------------------
+
+// A development katoctl must be told what to install, and nothing is
+// installed unverified.
+func TestPinKatoctl(t *testing.T) {
+
+	defer func(v string) { katool.Version = v }(katool.Version)
+
+	cases := []struct {
+		name    string
+		version string
+		data    Data
+		url     string
+		fail    bool
+	}{
+		{name: "dev", version: "dev", fail: true},
+		{name: "dev url", version: "dev", data: Data{KatoctlURL: "https://example.com/katoctl"}, fail: true},
+		{name: "bad sum", version: "dev", data: Data{KatoctlURL: "https://example.com/katoctl", KatoctlSHA256: "abc"}, fail: true},
+		{name: "url", version: "dev", data: Data{KatoctlURL: "https://example.com/katoctl", KatoctlSHA256: testSHA256}, url: "https://example.com/katoctl"},
+		{name: "release", version: "v1.2.3", data: Data{KatoctlSHA256: testSHA256}, url: "https://github.com/h0tbird/kato/releases/download/v1.2.3/katoctl-linux-amd64"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+
+			katool.Version = c.version
+			err := c.data.pinKatoctl()
+
+			if c.fail {
+				if err == nil {
+					t.Errorf("accepted %+v", c.data)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.data.KatoctlURL != c.url {
+				t.Errorf("url: got %q, want %q", c.data.KatoctlURL, c.url)
+			}
+		})
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestRenderEtcdTLSNeedsDNS
+//-----------------------------------------------------------------------------
+
+// etcd over TLS can not bootstrap when nothing publishes the names its peers
+// are dialed by.
+func TestRenderEtcdTLSNeedsDNS(t *testing.T) {
+
+	for _, provider := range []string{"ns1", "none"} {
+		t.Run(provider, func(t *testing.T) {
+
+			d := Data{
+				MasterCount:   3,
+				HostID:        "1",
+				Domain:        "cell-1.dc-1.kato.lan",
+				Role:          "master",
+				DNSProvider:   provider,
+				Ns1ApiKey:     "ns1-test-key",
+				EtcdTLS:       true,
+				ClusterCA:     "ca",
+				HostCert:      "cert",
+				HostKey:       "key",
+				KatoctlURL:    ReleaseURL("v0.0.0"),
+				KatoctlSHA256: testSHA256,
+			}
+
+			_, err := d.Bytes()
+			switch {
+			case provider == "ns1" && err != nil:
+				t.Error(err)
+			case provider == "none" && (err == nil || !strings.Contains(err.Error(), "DNS provider")):
+				t.Errorf("got %v, want a DNS provider error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package udata
+
+//---------------------------------------------------------------------------
+// Systemd units and CoreOS settings:
+//---------------------------------------------------------------------------
+
+const templUnits = `{{define "unit-etcd2"}}  - name: "etcd2.service"
+    command: "start"
+{{- end}}
+
+{{define "unit-fleet"}}  - name: "fleet.service"
+    command: "start"
+{{- end}}
+
+{{define "unit-flanneld"}}  - name: flanneld.service
+    command: "start"
+    drop-ins:
+     - name: 50-network-config.conf
+       content: |
+        [Service]
+        ExecStartPre=/usr/bin/etcdctl set /coreos.com/network/config '{ "Network": "{{.FlannelNetwork}}","SubnetLen":{{.FlannelSubnetLen}} ,"SubnetMin": "{{.FlannelSubnetMin}}","SubnetMax": "{{.FlannelSubnetMax}}","Backend": {"Type": "{{.FlannelBackend}}"} }'
+{{- if .EtcdTLS}}
+     - name: 50-etcd-tls.conf
+       content: |
+        [Service]
+        Environment=ETCD_SSL_DIR=/etc/kato/pki
+        Environment=FLANNELD_ETCD_ENDPOINTS=https://127.0.0.1:2379
+        Environment=FLANNELD_ETCD_CAFILE=/etc/ssl/etcd/ca.crt
+        Environment=FLANNELD_ETCD_CERTFILE=/etc/ssl/etcd/host.crt
+        Environment=FLANNELD_ETCD_KEYFILE=/etc/ssl/etcd/host.key
+{{- end}}
+{{- end}}
+
+{{define "unit-katoctl"}}  - name: "katoctl.service"
+    content: |
+     [Unit]
+     Description=Install katoctl
+     ConditionPathExists=!/opt/bin/katoctl
+     Wants=network-online.target
+     After=network-online.target
+
+     [Service]
+     Type=oneshot
+     ExecStart=/usr/bin/mkdir -p /opt/bin
+     ExecStart=/usr/bin/curl -sfLo /opt/bin/katoctl.tmp {{.KatoctlURL}}
+     ExecStart=/usr/bin/sh -c 'echo "{{.KatoctlSHA256}}  /opt/bin/katoctl.tmp" | /usr/bin/sha256sum -c -'
+     ExecStart=/usr/bin/chmod +x /opt/bin/katoctl.tmp
+     ExecStart=/usr/bin/mv /opt/bin/katoctl.tmp /opt/bin/katoctl
+{{- end}}
+
+{{define "unit-secrets"}}  - name: "secrets.service"
+    content: |
+     [Unit]
+     Description=Open the sealed secrets
+     Requires=katoctl.service
+     After=katoctl.service
+     ConditionPathExists=/etc/kato/secrets.sealed
+
+     [Service]
+     Type=oneshot
+     RemainAfterExit=yes
+     ExecStart=/opt/bin/katoctl agent secrets --backend {{.Secrets.Backend}}
+{{- if eq .Secrets.Backend "vault"}} --vault-addr {{.Secrets.VaultAddr}} --vault-transit-key {{.Secrets.VaultKey}}{{end}}
+{{- end}}
+
+{{define "unit-dns-publish"}}  - name: "dns-publish.service"
+    command: "start"
+    content: |
+     [Unit]
+     Description=Publish DNS records
+     Requires=katoctl.service{{if .SealedSecrets}} secrets.service{{end}}
+     After=katoctl.service{{if .SealedSecrets}} secrets.service{{end}}
+     Before=etcd2.service
+
+     [Service]
+     Type=oneshot
+     EnvironmentFile=/etc/kato/dns.env
+{{- if .SealedSecrets}}
+     EnvironmentFile=/etc/kato/secrets.env
+{{- end}}
+     ExecStart=/opt/bin/katoctl agent dns-publish
+{{- end}}
+
+{{define "unit-hosts-sync"}}  - name: "hosts-sync.service"
+    command: "start"
+    content: |
+     [Unit]
+     Description=Keeps /etc/hosts in sync with etcd
+     Requires=katoctl.service etcd2.service
+     After=katoctl.service etcd2.service
+
+     [Service]
+     Restart=always
+     RestartSec=10
+     ExecStart=/opt/bin/katoctl agent hosts-sync
+{{- end}}
+
+{{define "unit-docker-gc"}}  - name: docker-gc.service
+    command: start
+    content: |
+     [Unit]
+     Description=Docker garbage collector
+     Requires=katoctl.service etcd2.service docker.service
+     After=katoctl.service etcd2.service docker.service
+
+     [Service]
+     Type=oneshot
+     ExecStart=/opt/bin/katoctl agent docker-gc
+
+  - name: docker-gc.timer
+    command: start
+    content: |
+     [Unit]
+     Description=Run docker-gc.service every 30 minutes
+
+     [Timer]
+     OnBootSec=1min
+     OnUnitActiveSec=30min
+{{- end}}
+
+{{define "unit-rexray"}}  - name: "rexray.service"
+    command: "start"
+    content: |
+     [Unit]
+     Description=REX-Ray volume plugin
+     Before=docker.service
+
+     [Service]
+     EnvironmentFile=/etc/rexray/rexray.env
+     ExecStartPre=-/bin/bash -c '\
+       REXRAY_URL=https://dl.bintray.com/emccode/rexray/stable/latest/rexray-Linux-x86_64.tar.gz; \
+       [ -f /opt/bin/rexray ] || { curl -sL $${REXRAY_URL} | tar -xz -C /opt/bin; }; \
+       [ -x /opt/bin/rexray ] || { chmod +x /opt/bin/rexray; }'
+     ExecStart=/opt/bin/rexray start -f
+     ExecReload=/bin/kill -HUP $MAINPID
+     KillMode=process
+
+     [Install]
+     WantedBy=docker.service
+{{- end}}
+
+{{define "fleet"}} fleet:
+  public-ip: "$private_ipv4"
+  metadata: "{{.FleetMetadata}}"
+{{- if .EtcdTLS}}
+  etcd_servers: "https://127.0.0.1:2379"
+  etcd_cafile: "/etc/kato/pki/ca.crt"
+  etcd_certfile: "/etc/kato/pki/host.crt"
+  etcd_keyfile: "/etc/kato/pki/host.key"
+{{- end}}
+{{- end}}
+
+{{define "etcd2"}} etcd2:
+{{- if .EtcdToken}}
+  discovery: {{.EtcdDiscoveryURL}}/{{.EtcdToken}}
+{{- else}}
+  name: "{{.Role}}-{{.HostID}}"
+  initial-cluster: "{{.EtcdInitialCluster}}"
+{{- if .Fragment "etcd-member"}}
+  initial-cluster-state: "new"
+{{- end}}
+{{- end}}
+  advertise-client-urls: "{{.EtcdScheme}}://{{.EtcdHost}}:2379"
+{{- if .Fragment "etcd-member"}}
+  initial-advertise-peer-urls: "{{.EtcdScheme}}://{{.EtcdHost}}:2380"
+{{- end}}
+  listen-client-urls: "{{.EtcdScheme}}://127.0.0.1:2379,{{.EtcdScheme}}://$private_ipv4:2379"
+{{- if .Fragment "etcd-member"}}
+  listen-peer-urls: "{{.EtcdScheme}}://$private_ipv4:2380"
+{{- else}}
+  proxy: on
+{{- end}}
+{{- if .EtcdTLS}}
+  cert-file: "/etc/kato/pki/host.crt"
+  key-file: "/etc/kato/pki/host.key"
+  trusted-ca-file: "/etc/kato/pki/ca.crt"
+  client-cert-auth: true
+  peer-cert-file: "/etc/kato/pki/host.crt"
+  peer-key-file: "/etc/kato/pki/host.key"
+  peer-trusted-ca-file: "/etc/kato/pki/ca.crt"
+  peer-client-cert-auth: true
+{{- end}}
+{{- end}}
+`
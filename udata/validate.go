@@ -0,0 +1,376 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	// Community:
+	"gopkg.in/yaml.v3"
+)
+
+//-----------------------------------------------------------------------------
+// Package variables:
+//-----------------------------------------------------------------------------
+
+// Known keys of the CoreOS cloud-config schema:
+var (
+	topLevelKeys = keySet("hostname", "write_files", "coreos",
+		"ssh_authorized_keys", "users", "manage_etc_hosts")
+
+	coreosKeys = keySet("etcd", "etcd2", "fleet", "flannel", "locksmith",
+		"update", "units", "oem")
+
+	writeFileKeys = keySet("path", "content", "permissions", "owner",
+		"encoding")
+
+	unitKeys = keySet("name", "runtime", "enable", "content", "command",
+		"mask", "drop-ins")
+
+	dropInKeys = keySet("name", "content")
+
+	unitCommands = keySet("start", "stop", "restart", "reload",
+		"try-restart", "reload-or-restart", "reload-or-try-restart")
+
+	etcd2Keys = keySet("name", "data-dir", "wal-dir", "snapshot-count",
+		"heartbeat-interval", "election-timeout", "listen-peer-urls",
+		"listen-client-urls", "max-snapshots", "max-wals", "cors",
+		"initial-advertise-peer-urls", "initial-cluster",
+		"initial-cluster-state", "initial-cluster-token",
+		"advertise-client-urls", "discovery", "discovery-srv",
+		"discovery-fallback", "discovery-proxy", "strict-reconfig-check",
+		"proxy", "proxy-failure-wait", "proxy-refresh-interval",
+		"proxy-dial-timeout", "proxy-write-timeout", "proxy-read-timeout",
+		"ca-file", "cert-file", "key-file", "client-cert-auth",
+		"trusted-ca-file", "peer-ca-file", "peer-cert-file", "peer-key-file",
+		"peer-client-cert-auth", "peer-trusted-ca-file", "debug",
+		"log-package-levels", "force-new-cluster")
+
+	fleetKeys = keySet("agent_ttl", "engine_reconcile_interval",
+		"etcd_cafile", "etcd_certfile", "etcd_keyfile", "etcd_key_prefix",
+		"etcd_request_timeout", "etcd_servers", "metadata", "public-ip",
+		"verbosity", "disable_engine", "disable_watches")
+
+	unitName    = regexp.MustCompile(`^[a-zA-Z0-9:_.\\@-]+\.(service|socket|device|mount|automount|swap|target|path|timer|snapshot|slice|scope)$`)
+	dropInName  = regexp.MustCompile(`^[a-zA-Z0-9:_.\\@-]+\.conf$`)
+	octalString = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// ValidationError lists every problem found in a cloud-config document.
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Problem is a single validation failure and the line it was found at.
+type Problem struct {
+	Line    int
+	Message string
+}
+
+// validator walks a cloud-config document collecting problems.
+type validator struct {
+	problems []Problem
+	paths    map[string]int // Line of every write_files path.
+	units    map[string]int // Line of every unit name.
+}
+
+//-----------------------------------------------------------------------------
+// func: Error
+//-----------------------------------------------------------------------------
+
+func (e *ValidationError) Error() string {
+
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = "line " + strconv.Itoa(p.Line) + ": " + p.Message
+	}
+
+	return "invalid cloud-config:\n  " + strings.Join(lines, "\n  ")
+}
+
+//-----------------------------------------------------------------------------
+// func: Validate
+//-----------------------------------------------------------------------------
+
+// Validate parses a rendered cloud-config document and checks it against the
+// CoreOS cloud-config schema. The returned error, if any, is a
+// *ValidationError.
+func Validate(doc []byte) error {
+
+	v := &validator{paths: map[string]int{}, units: map[string]int{}}
+
+	// The header is mandatory:
+	if !bytes.HasPrefix(doc, []byte("#cloud-config\n")) {
+		v.add(1, "missing #cloud-config header")
+	}
+
+	// Parse the document:
+	root := yaml.Node{}
+	if err := yaml.Unmarshal(doc, &root); err != nil {
+		v.add(yamlErrorLine(err), err.Error())
+		return v.err()
+	}
+
+	if len(root.Content) == 0 {
+		v.add(1, "empty document")
+		return v.err()
+	}
+
+	// Walk the tree:
+	v.mapping(root.Content[0], "document", topLevelKeys, func(k string, n *yaml.Node) {
+		switch k {
+		case "hostname":
+			v.scalar(n, k)
+		case "ssh_authorized_keys":
+			v.sequence(n, k, func(n *yaml.Node) { v.scalar(n, "ssh_authorized_keys entry") })
+		case "write_files":
+			v.sequence(n, k, v.writeFile)
+		case "coreos":
+			v.coreos(n)
+		}
+	})
+
+	return v.err()
+}
+
+//-----------------------------------------------------------------------------
+// func: coreos
+//-----------------------------------------------------------------------------
+
+func (v *validator) coreos(n *yaml.Node) {
+	v.mapping(n, "coreos", coreosKeys, func(k string, n *yaml.Node) {
+		switch k {
+		case "units":
+			v.sequence(n, "coreos.units", v.unit)
+		case "etcd2":
+			v.settings(n, "coreos.etcd2", etcd2Keys)
+		case "fleet":
+			v.settings(n, "coreos.fleet", fleetKeys)
+		}
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: writeFile
+//-----------------------------------------------------------------------------
+
+func (v *validator) writeFile(n *yaml.Node) {
+
+	var path bool
+
+	v.mapping(n, "write_files entry", writeFileKeys, func(k string, n *yaml.Node) {
+		switch k {
+		case "path":
+			path = true
+			if v.scalar(n, k) && !strings.HasPrefix(n.Value, "/") {
+				v.add(n.Line, "path must be absolute: "+n.Value)
+			}
+			v.unique(v.paths, n, "write_files path")
+		case "permissions":
+			if v.scalar(n, k) && !octalString.MatchString(n.Value) {
+				v.add(n.Line, "permissions must be an octal string: "+n.Value)
+			}
+		default:
+			v.scalar(n, k)
+		}
+	})
+
+	if n.Kind == yaml.MappingNode && !path {
+		v.add(n.Line, "write_files entry without path")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: unit
+//-----------------------------------------------------------------------------
+
+func (v *validator) unit(n *yaml.Node) {
+
+	var name bool
+
+	v.mapping(n, "unit", unitKeys, func(k string, n *yaml.Node) {
+		switch k {
+		case "name":
+			name = true
+			if v.scalar(n, k) && !unitName.MatchString(n.Value) {
+				v.add(n.Line, "invalid unit name: "+n.Value)
+			}
+			v.unique(v.units, n, "unit")
+		case "command":
+			if v.scalar(n, k) && !unitCommands[n.Value] {
+				v.add(n.Line, "unknown unit command: "+n.Value)
+			}
+		case "drop-ins":
+			v.sequence(n, k, v.dropIn)
+		default:
+			v.scalar(n, k)
+		}
+	})
+
+	if n.Kind == yaml.MappingNode && !name {
+		v.add(n.Line, "unit without name")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: dropIn
+//-----------------------------------------------------------------------------
+
+func (v *validator) dropIn(n *yaml.Node) {
+	v.mapping(n, "drop-in", dropInKeys, func(k string, n *yaml.Node) {
+		if v.scalar(n, k) && k == "name" && !dropInName.MatchString(n.Value) {
+			v.add(n.Line, "invalid drop-in name: "+n.Value)
+		}
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: settings
+//-----------------------------------------------------------------------------
+
+// settings checks a flat map of daemon settings such as coreos.etcd2.
+func (v *validator) settings(n *yaml.Node, what string, keys map[string]bool) {
+	v.mapping(n, what, keys, func(k string, n *yaml.Node) {
+		v.scalar(n, what+"."+k)
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: mapping
+//-----------------------------------------------------------------------------
+
+// mapping checks that n is a mapping with known, unique keys and calls fn for
+// each of its entries.
+func (v *validator) mapping(n *yaml.Node, what string, keys map[string]bool, fn func(string, *yaml.Node)) {
+
+	if n.Kind != yaml.MappingNode {
+		v.add(n.Line, what+" must be a mapping")
+		return
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, val := n.Content[i], n.Content[i+1]
+		switch {
+		case !keys[k.Value]:
+			v.add(k.Line, "unknown key in "+what+": "+k.Value)
+		case seen[k.Value]:
+			v.add(k.Line, "duplicate key in "+what+": "+k.Value)
+		default:
+			fn(k.Value, val)
+		}
+		seen[k.Value] = true
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: sequence
+//-----------------------------------------------------------------------------
+
+func (v *validator) sequence(n *yaml.Node, what string, fn func(*yaml.Node)) {
+
+	if n.Kind != yaml.SequenceNode {
+		v.add(n.Line, what+" must be a list")
+		return
+	}
+
+	for _, item := range n.Content {
+		fn(item)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: scalar
+//-----------------------------------------------------------------------------
+
+func (v *validator) scalar(n *yaml.Node, what string) bool {
+
+	if n.Kind != yaml.ScalarNode {
+		v.add(n.Line, what+" must be a scalar")
+		return false
+	}
+
+	return true
+}
+
+//-----------------------------------------------------------------------------
+// func: unique
+//-----------------------------------------------------------------------------
+
+// unique reports a scalar already seen elsewhere in the document, such as two
+// files written to the same path.
+func (v *validator) unique(seen map[string]int, n *yaml.Node, what string) {
+
+	if n.Kind != yaml.ScalarNode {
+		return
+	}
+
+	if line, ok := seen[n.Value]; ok {
+		v.add(n.Line, "duplicate "+what+" "+n.Value+" (first at line "+strconv.Itoa(line)+")")
+		return
+	}
+
+	seen[n.Value] = n.Line
+}
+
+//-----------------------------------------------------------------------------
+// func: add
+//-----------------------------------------------------------------------------
+
+func (v *validator) add(line int, msg string) {
+	v.problems = append(v.problems, Problem{Line: line, Message: msg})
+}
+
+//-----------------------------------------------------------------------------
+// func: err
+//-----------------------------------------------------------------------------
+
+func (v *validator) err() error {
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: v.problems}
+}
+
+//-----------------------------------------------------------------------------
+// func: yamlErrorLine
+//-----------------------------------------------------------------------------
+
+// yamlErrorLine extracts the line number from a yaml.v3 parse error.
+func yamlErrorLine(err error) int {
+
+	var line int
+	if _, e := fmt.Sscanf(strings.TrimPrefix(err.Error(), "yaml: "), "line %d:", &line); e != nil {
+		return 1
+	}
+
+	return line
+}
+
+//-----------------------------------------------------------------------------
+// func: keySet
+//-----------------------------------------------------------------------------
+
+func keySet(keys ...string) map[string]bool {
+
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+
+	return set
+}
@@ -0,0 +1,124 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"errors"
+	"io/ioutil"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Versions pins the container image of every component run by the fleet
+// units. Fields left empty in a versions file keep their default.
+type Versions struct {
+	Zookeeper   string `yaml:"zookeeper"`
+	MesosMaster string `yaml:"mesosMaster"`
+	MesosSlave  string `yaml:"mesosSlave"`
+	MesosDNS    string `yaml:"mesosDNS"`
+	Marathon    string `yaml:"marathon"`
+	MarathonLB  string `yaml:"marathonLB"`
+	Cadvisor    string `yaml:"cadvisor"`
+	Dnsmasq     string `yaml:"dnsmasq"`
+	Mongo       string `yaml:"mongo"`
+	Pritunl     string `yaml:"pritunl"`
+}
+
+//-----------------------------------------------------------------------------
+// func: DefaultVersions
+//-----------------------------------------------------------------------------
+
+// DefaultVersions returns the images katoctl was released with.
+func DefaultVersions() Versions {
+	return Versions{
+		Zookeeper:   "h0tbird/zookeeper:v3.4.8-2",
+		MesosMaster: "mesosphere/mesos-master:0.28.0-2.0.16.ubuntu1404",
+		MesosSlave:  "mesosphere/mesos-slave:0.28.0-2.0.16.ubuntu1404",
+		MesosDNS:    "h0tbird/mesos-dns:v0.5.2-1",
+		Marathon:    "mesosphere/marathon:v1.1.1",
+		MarathonLB:  "mesosphere/marathon-lb:v1.2.0",
+		Cadvisor:    "google/cadvisor:v0.22.0",
+		Dnsmasq:     "janeczku/go-dnsmasq:release-1.0.5",
+		Mongo:       "mongo:3.2",
+		Pritunl:     "h0tbird/pritunl:v1.21.954.48-3",
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: LoadVersions
+//-----------------------------------------------------------------------------
+
+// LoadVersions reads a versions file on top of the defaults. Unknown
+// components are rejected so a typo does not silently keep the default.
+func LoadVersions(path string) (Versions, error) {
+
+	v := DefaultVersions()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithField("cmd", "udata").Error(err)
+		return v, err
+	}
+
+	// Decode the overrides:
+	var o Versions
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&o); err != nil {
+		err = errors.New(path + ": " + err.Error())
+		log.WithField("cmd", "udata").Error(err)
+		return v, err
+	}
+
+	// Merge them:
+	for dst, src := range map[*string]string{
+		&v.Zookeeper:   o.Zookeeper,
+		&v.MesosMaster: o.MesosMaster,
+		&v.MesosSlave:  o.MesosSlave,
+		&v.MesosDNS:    o.MesosDNS,
+		&v.Marathon:    o.Marathon,
+		&v.MarathonLB:  o.MarathonLB,
+		&v.Cadvisor:    o.Cadvisor,
+		&v.Dnsmasq:     o.Dnsmasq,
+		&v.Mongo:       o.Mongo,
+		&v.Pritunl:     o.Pritunl,
+	} {
+		if src != "" {
+			*dst = src
+		}
+	}
+
+	return v, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: versions
+//-----------------------------------------------------------------------------
+
+// versions fills in the component images, from the versions file if any.
+func (d *Data) versions() error {
+
+	var err error
+
+	if d.VersionsFile != "" {
+		d.Versions, err = LoadVersions(d.VersionsFile)
+		return err
+	}
+
+	if d.Versions == (Versions{}) {
+		d.Versions = DefaultVersions()
+	}
+
+	return nil
+}